@@ -0,0 +1,691 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is a local account. Passwords are only stored as bcrypt hashes.
+type User struct {
+	Username     string      `json:"username"`
+	PasswordHash string      `json:"passwordHash"`
+	Roles        []RoleGrant `json:"roles"`
+	CreatedAt    time.Time   `json:"createdAt"`
+}
+
+// authFile is the JSON file the accounts and API tokens are stored in,
+// readable only by the user running AirGit
+type authFile struct {
+	Users  []User     `json:"users"`
+	Tokens []APIToken `json:"tokens,omitempty"`
+}
+
+type session struct {
+	Username string
+	Expires  time.Time
+}
+
+const (
+	sessionCookieName = "airgit_session"
+	sessionLifetime   = 7 * 24 * time.Hour
+	minPasswordLength = 8
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// publicPaths are the API endpoints that work without signing in. Everything
+// outside /api/ (the PWA shell, manifest, service worker and icon) is public too.
+var publicPaths = map[string]bool{
+	"/api/auth/status": true,
+	"/api/auth/login":  true,
+	"/api/auth/setup":  true,
+}
+
+var (
+	authMutex sync.Mutex
+	authData  authFile
+	// Sessions live in memory, so restarting AirGit signs everyone out
+	sessions  = make(map[string]session)
+	setupCode string // required to create the first account; empty once one exists
+
+	// Compared against when the username is unknown, so a failed login takes
+	// as long whether or not the user exists
+	dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("airgit"), bcrypt.DefaultCost)
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+func defaultAuthFilePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "airgit-auth.json"
+	}
+	return filepath.Join(homeDir, ".config", "airgit", "auth.json")
+}
+
+// loadAuth reads the accounts from config.AuthFile. Without any, it generates
+// the setup code for the first-run setup and prints it to the log.
+func loadAuth() error {
+	authMutex.Lock()
+	defer authMutex.Unlock()
+
+	data, err := os.ReadFile(config.AuthFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &authData); err != nil {
+			return fmt.Errorf("invalid auth file %s: %v", config.AuthFile, err)
+		}
+	}
+
+	for i := range authData.Users {
+		// Accounts created before roles existed had full access, and keep it
+		if authData.Users[i].Roles == nil {
+			authData.Users[i].Roles = []RoleGrant{{Repos: allRepos, Role: roleAdmin.String()}}
+		}
+	}
+
+	if len(authData.Users) == 0 {
+		setupCode = randomToken(9)
+		log.Printf("No AirGit users exist yet. Open AirGit in a browser and create the first user with setup code: %s", setupCode)
+	}
+	return nil
+}
+
+// saveAuth writes the accounts to config.AuthFile; callers hold authMutex
+func saveAuth() error {
+	data, err := json.MarshalIndent(authData, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(config.AuthFile), 0700); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash cannot leave a truncated file behind
+	tmp := config.AuthFile + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, config.AuthFile)
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// findUser returns the index of username in authData.Users, or -1; callers hold authMutex
+func findUser(username string) int {
+	for i, user := range authData.Users {
+		if user.Username == username {
+			return i
+		}
+	}
+	return -1
+}
+
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func validateCredentials(username, password string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("usernames may only contain letters, digits, '.', '_' and '-'")
+	}
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("passwords must be at least %d characters long", minPasswordLength)
+	}
+	// bcrypt ignores everything after 72 bytes
+	if len(password) > 72 {
+		return errors.New("passwords must be at most 72 bytes long")
+	}
+	return nil
+}
+
+// addUser creates an account; callers hold authMutex
+func addUser(username, password string, roles []RoleGrant) error {
+	if err := validateCredentials(username, password); err != nil {
+		return err
+	}
+	if err := validateGrants(roles); err != nil {
+		return err
+	}
+	if findUser(username) >= 0 {
+		return fmt.Errorf("user %s already exists", username)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	authData.Users = append(authData.Users, User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Roles:        append([]RoleGrant{}, roles...),
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err := saveAuth(); err != nil {
+		authData.Users = authData.Users[:len(authData.Users)-1]
+		return err
+	}
+	return nil
+}
+
+// endSessions signs username out everywhere except in the session keep; callers hold authMutex
+func endSessions(username, keep string) {
+	for token, s := range sessions {
+		if s.Username == username && token != keep {
+			delete(sessions, token)
+		}
+	}
+}
+
+// startSession signs username in by setting the session cookie
+func startSession(w http.ResponseWriter, r *http.Request, username string) {
+	setAuditUser(r, username, "")
+	token := randomToken(32)
+	expires := time.Now().Add(sessionLifetime)
+
+	authMutex.Lock()
+	sessions[token] = session{Username: username, Expires: expires}
+	authMutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// sessionToken returns the session cookie's token, if it names a live session,
+// along with the signed-in user
+func sessionToken(r *http.Request) (string, string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", "", false
+	}
+
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	s, ok := sessions[cookie.Value]
+	if !ok {
+		return "", "", false
+	}
+	if time.Now().After(s.Expires) {
+		delete(sessions, cookie.Value)
+		return "", "", false
+	}
+	return cookie.Value, s.Username, true
+}
+
+// currentUser returns the user a request was made by, or "" with authentication disabled
+func currentUser(r *http.Request) string {
+	username, _ := r.Context().Value(userContextKey).(string)
+	return username
+}
+
+// requireAuth wraps the API so that only signed-in users, or API tokens with
+// the scope an endpoint needs, can reach it
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.AuthDisabled || !strings.HasPrefix(r.URL.Path, "/api/") || publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			w.Header().Set("Content-Type", "application/json")
+			token, err := authenticateToken(strings.TrimSpace(bearer))
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(Response{
+					Error: fmt.Sprintf("Authentication failed: %v", err),
+				})
+				return
+			}
+			scope := requiredScope(r)
+			if scope == "" || !token.allows(scope) {
+				message := fmt.Sprintf("API token lacks the %s scope", scope)
+				if scope == "" {
+					message = "This endpoint cannot be used with an API token"
+				}
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(Response{
+					Error: message,
+				})
+				return
+			}
+			if err := authorize(r, token.Username); err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(Response{
+					Error: err.Error(),
+				})
+				return
+			}
+			setAuditUser(r, token.Username, token.ID)
+			w.Header().Del("Content-Type")
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, token.Username)))
+			return
+		}
+
+		_, username, ok := sessionToken(r)
+		setAuditUser(r, username, "")
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Authentication required",
+			})
+			return
+		}
+		if err := authorize(r, username); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(Response{
+				Error: err.Error(),
+			})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, username)))
+	})
+}
+
+// handleAuthStatus serves GET /api/auth/status, which the frontend checks
+// before anything else to decide between setup, login and the app itself
+func handleAuthStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	_, username, authenticated := sessionToken(r)
+	authMutex.Lock()
+	setupRequired := len(authData.Users) == 0
+	roles := []RoleGrant{}
+	if i := findUser(username); i >= 0 && authenticated {
+		roles = authData.Users[i].Roles
+	}
+	authMutex.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":       !config.AuthDisabled,
+		"setupRequired": !config.AuthDisabled && setupRequired,
+		"authenticated": config.AuthDisabled || authenticated,
+		"username":      username,
+		"roles":         roles,
+	})
+}
+
+// handleSetup serves POST /api/auth/setup, which creates the first user. It
+// requires the setup code AirGit prints on startup while no user exists, so
+// that whoever reaches the server first cannot claim it.
+func handleSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		SetupCode string `json:"setupCode"`
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if wait := loginRetryAfter(r, ""); wait > 0 {
+		writeLoginThrottled(w, wait)
+		return
+	}
+
+	authMutex.Lock()
+	if config.AuthDisabled || len(authData.Users) > 0 {
+		authMutex.Unlock()
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Setup has already been completed",
+		})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.SetupCode), []byte(setupCode)) != 1 {
+		authMutex.Unlock()
+		recordLoginFailure(r, "")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid setup code; it is printed in the AirGit server log",
+		})
+		return
+	}
+	// The first user administers everything
+	err := addUser(req.Username, req.Password, []RoleGrant{{Repos: allRepos, Role: roleAdmin.String()}})
+	if err == nil {
+		setupCode = ""
+	}
+	authMutex.Unlock()
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to create user: %v", err),
+		})
+		return
+	}
+
+	log.Printf("Setup completed: created user %s", req.Username)
+	startSession(w, r, req.Username)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"username": req.Username,
+	})
+}
+
+// handleLogin serves POST /api/auth/login
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if wait := loginRetryAfter(r, req.Username); wait > 0 {
+		log.Printf("Throttled login for %q from %s", req.Username, r.RemoteAddr)
+		writeLoginThrottled(w, wait)
+		return
+	}
+
+	authMutex.Lock()
+	hash := string(dummyPasswordHash)
+	i := findUser(req.Username)
+	if i >= 0 {
+		hash = authData.Users[i].PasswordHash
+	}
+	authMutex.Unlock()
+
+	if !checkPassword(hash, req.Password) || i < 0 {
+		log.Printf("Failed login for %q from %s", req.Username, r.RemoteAddr)
+		recordLoginFailure(r, req.Username)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid username or password",
+		})
+		return
+	}
+
+	clearLoginFailures(r, req.Username)
+	startSession(w, r, req.Username)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"username": req.Username,
+	})
+}
+
+// handleLogout serves POST /api/auth/logout
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if token, _, ok := sessionToken(r); ok {
+		authMutex.Lock()
+		delete(sessions, token)
+		authMutex.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleChangePassword serves POST /api/auth/password. Other sessions of the
+// user are signed out.
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	token, username, ok := sessionToken(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Not signed in",
+		})
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+	if err := validateCredentials(username, req.NewPassword); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	i := findUser(username)
+	if i < 0 || !checkPassword(authData.Users[i].PasswordHash, req.CurrentPassword) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Current password is incorrect",
+		})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to hash password: %v", err),
+		})
+		return
+	}
+	previous := authData.Users[i].PasswordHash
+	authData.Users[i].PasswordHash = string(hash)
+	if err := saveAuth(); err != nil {
+		authData.Users[i].PasswordHash = previous
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to save password: %v", err),
+		})
+		return
+	}
+	endSessions(username, token)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleListUsers serves GET /api/users
+func handleListUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	type userInfo struct {
+		Username  string      `json:"username"`
+		Roles     []RoleGrant `json:"roles"`
+		CreatedAt time.Time   `json:"createdAt"`
+	}
+	authMutex.Lock()
+	users := make([]userInfo, 0, len(authData.Users))
+	for _, user := range authData.Users {
+		users = append(users, userInfo{Username: user.Username, Roles: user.Roles, CreatedAt: user.CreatedAt})
+	}
+	authMutex.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+	})
+}
+
+// handleUserAction serves POST /api/users/create and /api/users/delete
+func handleUserAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	action := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if action != "create" && action != "delete" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Username string      `json:"username"`
+		Password string      `json:"password"` // create only
+		Roles    []RoleGrant `json:"roles"`    // create only
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	authMutex.Lock()
+	defer authMutex.Unlock()
+
+	if action == "create" {
+		if err := addUser(req.Username, req.Password, req.Roles); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to create user: %v", err),
+			})
+			return
+		}
+		log.Printf("User %s created by %s", req.Username, currentUser(r))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"username": req.Username,
+		})
+		return
+	}
+
+	i := findUser(req.Username)
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "User not found",
+		})
+		return
+	}
+	if req.Username == currentUser(r) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: "You cannot delete your own user",
+		})
+		return
+	}
+
+	users, tokens := authData.Users, authData.Tokens
+	authData.Users = append(append([]User{}, users[:i]...), users[i+1:]...)
+	if adminCount() == 0 {
+		authData.Users = users
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Cannot delete the last admin of all repositories",
+		})
+		return
+	}
+	deleteUserTokens(req.Username)
+	if err := saveAuth(); err != nil {
+		authData.Users, authData.Tokens = users, tokens
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to delete user: %v", err),
+		})
+		return
+	}
+	endSessions(req.Username, "")
+	log.Printf("User %s deleted by %s", req.Username, currentUser(r))
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type BlameCommit struct {
+	Hash         string `json:"hash"`
+	Author       string `json:"author"`
+	AuthorEmail  string `json:"authorEmail"`
+	AuthorDate   string `json:"authorDate"`
+	Committer    string `json:"committer"`
+	CommitDate   string `json:"commitDate"`
+	Summary      string `json:"summary"`
+	Boundary     bool   `json:"boundary,omitempty"` // the oldest commit blame reached; lines may be older
+	Previous     string `json:"previous,omitempty"`
+	PreviousPath string `json:"previousPath,omitempty"`
+}
+
+// BlameRange is a run of consecutive lines attributed to one commit.
+// Start and End are 1-based, inclusive line numbers in the blamed file.
+type BlameRange struct {
+	Commit    string `json:"commit"`
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	OrigStart int    `json:"origStart"`          // line number in the commit that introduced the lines
+	OrigPath  string `json:"origPath,omitempty"` // set when the lines came from another file or name
+}
+
+type BlameResult struct {
+	Path    string                  `json:"path"`
+	Ref     string                  `json:"ref"`
+	Commits map[string]*BlameCommit `json:"commits"`
+	Ranges  []BlameRange            `json:"ranges"`
+	Lines   []string                `json:"lines"`
+}
+
+// blameLine is where one line of the blamed file came from
+type blameLine struct {
+	commit       string
+	orig, final  int
+	origFilename string
+}
+
+// parseBlamePorcelain parses `git blame --porcelain`. Commit metadata is only
+// printed the first time a commit appears, so it is collected into a map. The
+// same goes for the filename, unless the commit touched several paths.
+func parseBlamePorcelain(output, filePath string) BlameResult {
+	result := BlameResult{
+		Commits: make(map[string]*BlameCommit),
+		Ranges:  []BlameRange{},
+		Lines:   []string{},
+	}
+
+	var commit *BlameCommit
+	var lines []blameLine
+	filenames := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if content, ok := strings.CutPrefix(line, "\t"); ok {
+			result.Lines = append(result.Lines, content)
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		if len(key) >= 40 && isHexString(key) {
+			fields := strings.Fields(value)
+			if len(fields) < 2 {
+				continue
+			}
+			origLine, _ := strconv.Atoi(fields[0])
+			finalLine, _ := strconv.Atoi(fields[1])
+
+			commit = result.Commits[key]
+			if commit == nil {
+				commit = &BlameCommit{Hash: key}
+				result.Commits[key] = commit
+			}
+			lines = append(lines, blameLine{commit: key, orig: origLine, final: finalLine, origFilename: filenames[key]})
+			continue
+		}
+		if commit == nil {
+			continue
+		}
+
+		switch key {
+		case "author":
+			commit.Author = value
+		case "author-mail":
+			commit.AuthorEmail = strings.Trim(value, "<>")
+		case "author-time":
+			commit.AuthorDate = blameTime(value)
+		case "committer":
+			commit.Committer = value
+		case "committer-time":
+			commit.CommitDate = blameTime(value)
+		case "summary":
+			commit.Summary = value
+		case "boundary":
+			commit.Boundary = true
+		case "previous":
+			commit.Previous, commit.PreviousPath, _ = strings.Cut(value, " ")
+		case "filename":
+			filenames[commit.Hash] = value
+			if n := len(lines); n > 0 {
+				lines[n-1].origFilename = value
+			}
+		}
+	}
+
+	// Consecutive lines form a range when they came from consecutive lines of the same file in the same commit
+	for _, line := range lines {
+		origPath := line.origFilename
+		if origPath == filePath {
+			origPath = ""
+		}
+		if n := len(result.Ranges); n > 0 {
+			last := &result.Ranges[n-1]
+			if last.Commit == line.commit && last.OrigPath == origPath &&
+				last.End == line.final-1 && last.OrigStart+(last.End-last.Start+1) == line.orig {
+				last.End = line.final
+				continue
+			}
+		}
+		result.Ranges = append(result.Ranges, BlameRange{
+			Commit:    line.commit,
+			Start:     line.final,
+			End:       line.final,
+			OrigStart: line.orig,
+			OrigPath:  origPath,
+		})
+	}
+	return result
+}
+
+func blameTime(unix string) string {
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+func isHexString(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// blameIgnoreRevsFile writes the ignore-revs file as it exists at ref to a temporary
+// file, since git blame only reads it from disk. The caller removes the file.
+func blameIgnoreRevsFile(repo Repo, ref, filePath string) (string, error) {
+	hash, _, err := resolveBlob(repo, ref, filePath)
+	if err != nil {
+		return "", err
+	}
+	content, err := readBlob(repo, hash, maxBlobSize)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "airgit-ignore-revs-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// handleBlame serves GET /api/blame?path=&ref=
+func handleBlame(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+	ref, filePath, ok := fileRequest(w, r, repo)
+	if !ok {
+		return
+	}
+	if _, _, err := resolveBlob(repo, ref, filePath); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to blame %s: %v", filePath, err),
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	args := []string{"blame", "--porcelain"}
+	if query.Get("ignoreWhitespace") == "true" {
+		args = append(args, "-w")
+	}
+	// -M finds lines moved within the file, -C lines moved or copied from other files
+	if query.Get("detectMoves") == "true" {
+		args = append(args, "-M")
+	}
+	if query.Get("detectCopies") == "true" {
+		args = append(args, "-C")
+	}
+
+	// ignoreRevs=true uses .git-blame-ignore-revs, ignoreRevsFile names another file
+	ignoreRevsFile := query.Get("ignoreRevsFile")
+	if ignoreRevsFile == "" && query.Get("ignoreRevs") == "true" {
+		ignoreRevsFile = ".git-blame-ignore-revs"
+	}
+	if ignoreRevsFile != "" {
+		resolved, ok := resolveRepoFilePath(repo, ignoreRevsFile)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Invalid ignoreRevsFile",
+			})
+			return
+		}
+		tmp, err := blameIgnoreRevsFile(repo, ref, resolved)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to read %s: %v", resolved, err),
+			})
+			return
+		}
+		defer os.Remove(tmp)
+		args = append(args, "--ignore-revs-file", tmp)
+	}
+
+	args = append(args, ref, "--", filePath)
+	output, err := executeRawGitCommand(repo, args...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to blame %s: %v: %s", filePath, err, strings.TrimSpace(output)),
+		})
+		return
+	}
+
+	result := parseBlamePorcelain(output, filePath)
+	result.Path = filePath
+	result.Ref = ref
+	json.NewEncoder(w).Encode(result)
+}
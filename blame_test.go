@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBlamePorcelain(t *testing.T) {
+	a := strings.Repeat("a", 40)
+	b := strings.Repeat("b", 40)
+	// Commit a wrote lines of old.go that now make up lines 1, 3 and 4 of
+	// new.go; the filename is only printed the first time a appears
+	output := strings.Join([]string{
+		a + " 1 1 1",
+		"author Alice",
+		"author-mail <alice@example.com>",
+		"author-time 1700000000",
+		"summary Add old.go",
+		"filename old.go",
+		"\tone",
+		b + " 2 2 1",
+		"author Bob",
+		"summary Rename to new.go",
+		"filename new.go",
+		"\ttwo",
+		a + " 3 3 2",
+		"\tthree",
+		a + " 9 4",
+		"\tfour",
+		a + " 10 5",
+		"\tfive",
+		"",
+	}, "\n")
+
+	result := parseBlamePorcelain(output, "new.go")
+	want := []BlameRange{
+		{Commit: a, Start: 1, End: 1, OrigStart: 1, OrigPath: "old.go"},
+		{Commit: b, Start: 2, End: 2, OrigStart: 2},
+		// Lines 3 and 4 follow each other but came from lines 3 and 9
+		{Commit: a, Start: 3, End: 3, OrigStart: 3, OrigPath: "old.go"},
+		{Commit: a, Start: 4, End: 5, OrigStart: 9, OrigPath: "old.go"},
+	}
+	if len(result.Ranges) != len(want) {
+		t.Fatalf("got %d ranges, want %d: %+v", len(result.Ranges), len(want), result.Ranges)
+	}
+	for i := range want {
+		if result.Ranges[i] != want[i] {
+			t.Errorf("range %d = %+v, want %+v", i, result.Ranges[i], want[i])
+		}
+	}
+	if len(result.Lines) != 5 || result.Lines[3] != "four" {
+		t.Errorf("lines = %q", result.Lines)
+	}
+	if c := result.Commits[a]; c == nil || c.Author != "Alice" || c.AuthorEmail != "alice@example.com" || c.Summary != "Add old.go" {
+		t.Errorf("commit a = %+v", c)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type BranchCommit struct {
+	Hash    string `json:"hash"`
+	Subject string `json:"subject"`
+	Author  string `json:"author"`
+	Date    string `json:"date"`
+}
+
+// BranchInfo is a local or remote-tracking branch. Ahead and Behind are
+// relative to the upstream; DefaultAhead and DefaultBehind to the default branch.
+type BranchInfo struct {
+	Name          string       `json:"name"`
+	Remote        string       `json:"remote,omitempty"` // set for remote-tracking branches
+	Current       bool         `json:"current,omitempty"`
+	Upstream      string       `json:"upstream,omitempty"`
+	UpstreamGone  bool         `json:"upstreamGone,omitempty"` // the upstream was deleted on the remote
+	Ahead         int          `json:"ahead"`
+	Behind        int          `json:"behind"`
+	DefaultAhead  int          `json:"defaultAhead"`
+	DefaultBehind int          `json:"defaultBehind"`
+	Merged        bool         `json:"merged"` // fully merged into the default branch
+	Protected     bool         `json:"protected,omitempty"`
+	LastCommit    BranchCommit `json:"lastCommit"`
+	AgeDays       int          `json:"ageDays"` // days since the last commit
+}
+
+const branchListFormat = "%(refname)%1f%(refname:short)%1f%(objectname)%1f%(upstream:short)%1f%(upstream:track,nobracket)%1f%(HEAD)%1f%(committerdate:iso-strict)%1f%(authorname)%1f%(contents:subject)"
+
+// getDefaultBranch returns the default branch name and the ref to compare against:
+// the local branch if it exists, otherwise the remote one. It follows origin/HEAD,
+// falling back to main or master.
+func getDefaultBranch(repo Repo) (name, ref string) {
+	candidates := []string{"main", "master"}
+	if head, err := executeGitCommand(repo, "symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		candidates = append([]string{strings.TrimPrefix(head, "origin/")}, candidates...)
+	}
+	for _, name := range candidates {
+		for _, ref := range []string{"refs/heads/" + name, "refs/remotes/origin/" + name} {
+			if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", ref); err == nil {
+				return name, ref
+			}
+		}
+	}
+	return "", ""
+}
+
+func isAncestor(repo Repo, commit, of string) bool {
+	_, err := executeGitCommand(repo, "merge-base", "--is-ancestor", commit, of)
+	return err == nil
+}
+
+func getBranchDetails(repo Repo) ([]BranchInfo, string, error) {
+	output, err := executeGitCommand(repo, "for-each-ref", "--format="+branchListFormat, "refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, "", fmt.Errorf("%v: %s", err, output)
+	}
+
+	defaultName, defaultRef := getDefaultBranch(repo)
+	merged := make(map[string]bool)
+	if defaultRef != "" {
+		mergedOutput, _ := executeGitCommand(repo, "for-each-ref", "--merged="+defaultRef, "--format=%(refname)", "refs/heads", "refs/remotes")
+		for _, ref := range strings.Split(mergedOutput, "\n") {
+			merged[ref] = true
+		}
+	}
+	settings := getRepoSettings(repo)
+	remotesOutput, _ := executeGitCommand(repo, "remote")
+	remotes := strings.Fields(remotesOutput)
+
+	branches := []BranchInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) < 9 {
+			continue
+		}
+		refName := fields[0]
+		// Skip symbolic refs such as origin/HEAD
+		if strings.HasSuffix(refName, "/HEAD") {
+			continue
+		}
+
+		branch := BranchInfo{
+			Name:         fields[1],
+			Current:      fields[5] == "*",
+			Upstream:     fields[3],
+			UpstreamGone: fields[4] == "gone",
+			Merged:       merged[refName],
+			LastCommit: BranchCommit{
+				Hash:    fields[2],
+				Subject: fields[8],
+				Author:  fields[7],
+				Date:    fields[6],
+			},
+		}
+		if rest, ok := strings.CutPrefix(refName, "refs/remotes/"); ok {
+			branch.Remote, branch.Name = splitRemoteBranch(rest, remotes)
+			if branch.Remote == "" {
+				// Left behind by a remote that has since been removed
+				branch.Remote, branch.Name, _ = strings.Cut(rest, "/")
+			}
+		} else {
+			_, branch.Protected = settings.protectedBranchPattern(branch.Name)
+			if branch.Upstream != "" && !branch.UpstreamGone {
+				branch.Ahead, branch.Behind = getAheadBehind(repo, branch.Name)
+			}
+		}
+		if defaultRef != "" {
+			branch.DefaultAhead, branch.DefaultBehind = countAheadBehind(repo, refName, defaultRef)
+		}
+		if date, err := time.Parse(time.RFC3339, branch.LastCommit.Date); err == nil {
+			branch.AgeDays = int(time.Since(date).Hours() / 24)
+		}
+		branches = append(branches, branch)
+	}
+	return branches, defaultName, nil
+}
+
+// splitRemoteBranch splits a remote-tracking branch such as origin/feature into
+// its remote and branch name. Remote names may contain slashes, so the longest
+// matching remote wins; remote is empty when none matches.
+func splitRemoteBranch(name string, remotes []string) (remote, branch string) {
+	for _, candidate := range remotes {
+		if strings.HasPrefix(name, candidate+"/") && len(candidate) > len(remote) {
+			remote = candidate
+		}
+	}
+	if remote == "" {
+		return "", name
+	}
+	return remote, strings.TrimPrefix(name, remote+"/")
+}
+
+// checkBranchName validates a new branch name with git's own rules
+func checkBranchName(repo Repo, name string) bool {
+	if !isValidRef(name) {
+		return false
+	}
+	_, err := executeGitCommand(repo, "check-ref-format", "--branch", name)
+	return err == nil
+}
+
+// trackingBranchFor maps a remote-tracking branch such as origin/feature to the
+// local branch that should be checked out for it, and whether that branch still
+// has to be created. local is empty when branch is not a remote-tracking branch.
+func trackingBranchFor(repo Repo, branch string) (local string, create bool, err error) {
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err == nil {
+		return "", false, nil
+	}
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "refs/remotes/"+branch); err != nil {
+		return "", false, nil
+	}
+
+	remotes, _ := executeGitCommand(repo, "remote")
+	remote, local := splitRemoteBranch(branch, strings.Fields(remotes))
+	if remote == "" || local == "HEAD" {
+		return "", false, nil
+	}
+
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+local); err != nil {
+		return local, true, nil
+	}
+	if upstream, _ := executeGitCommand(repo, "rev-parse", "--abbrev-ref", local+"@{u}"); upstream != branch {
+		return "", false, fmt.Errorf("a local branch %s already exists and does not track %s", local, branch)
+	}
+	return local, false, nil
+}
+
+// requireUnprotected writes a 403 if branch matches a protected branch pattern
+func requireUnprotected(w http.ResponseWriter, repo Repo, branch string) bool {
+	if pattern, protected := getRepoSettings(repo).protectedBranchPattern(branch); protected {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("%s is a protected branch (%s)", branch, pattern),
+		})
+		return false
+	}
+	return true
+}
+
+// handleDeleteBranch deletes a local branch, or with remote set, the branch on that remote.
+// Unless force is set, the branch must be merged into the default branch or its upstream.
+func handleDeleteBranch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Branch string `json:"branch"`
+		Remote string `json:"remote"`
+		Force  bool   `json:"force"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if !isValidRef(req.Branch) || (req.Remote != "" && !isValidRef(req.Remote)) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid branch or remote",
+		})
+		return
+	}
+	if !requireUnprotected(w, repo, req.Branch) {
+		return
+	}
+
+	ref := "refs/heads/" + req.Branch
+	if req.Remote != "" {
+		ref = "refs/remotes/" + req.Remote + "/" + req.Branch
+	}
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", ref); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Branch %s not found", strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/remotes/")),
+		})
+		return
+	}
+
+	if req.Remote == "" {
+		if current, _ := gitBackend.CurrentBranch(repo); current == req.Branch {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Cannot delete the checked-out branch",
+			})
+			return
+		}
+	}
+
+	if !req.Force {
+		defaultName, defaultRef := getDefaultBranch(repo)
+		merged := defaultRef != "" && isAncestor(repo, ref, defaultRef)
+		if !merged && req.Remote == "" {
+			// Commits that are pushed are not lost either
+			if upstream, err := executeGitCommand(repo, "rev-parse", "--symbolic-full-name", ref+"@{u}"); err == nil {
+				merged = isAncestor(repo, ref, upstream)
+			}
+		}
+		if !merged {
+			unmerged := 0
+			if defaultRef != "" {
+				unmerged, _ = countAheadBehind(repo, ref, defaultRef)
+			} else {
+				defaultName = "the default branch"
+			}
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":    fmt.Sprintf("%s is not fully merged into %s; delete it with force to discard its commits", req.Branch, defaultName),
+				"unmerged": unmerged,
+			})
+			return
+		}
+	}
+
+	var logs []string
+	var err error
+	if req.Remote != "" {
+		logs, _, err = runLoggedGit(repo, logs, "push", req.Remote, "--delete", req.Branch)
+	} else {
+		// The merge check above replaces git's own, which only looks at HEAD and the upstream
+		logs, _, err = runLoggedGit(repo, logs, "branch", "-D", req.Branch)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to delete branch: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	logs = append(logs, "✓ Branch deleted!")
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+// handleRenameBranch renames a local branch and, with remote set, its branch on that remote
+func handleRenameBranch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Branch  string `json:"branch"`
+		NewName string `json:"newName"`
+		Remote  string `json:"remote"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if !isValidRef(req.Branch) || !checkBranchName(repo, req.NewName) || (req.Remote != "" && !isValidRef(req.Remote)) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid branch name or remote",
+		})
+		return
+	}
+	if !requireUnprotected(w, repo, req.Branch) || !requireUnprotected(w, repo, req.NewName) {
+		return
+	}
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+req.Branch); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Branch %s not found", req.Branch),
+		})
+		return
+	}
+
+	remoteOld := "refs/remotes/" + req.Remote + "/" + req.Branch
+	if req.Remote != "" {
+		if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", remoteOld); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Branch %s not found on %s; fetch first", req.Branch, req.Remote),
+			})
+			return
+		}
+	}
+
+	var logs []string
+	var err error
+	if req.Remote == "" {
+		logs, _, err = runLoggedGit(repo, logs, "branch", "-m", req.Branch, req.NewName)
+	} else {
+		// Push exactly what the remote had under the new name first, refusing to
+		// overwrite a branch already there, and rename locally only once it landed
+		newRef := "refs/heads/" + req.NewName
+		logs, _, err = runLoggedGit(repo, logs, "push", "--force-with-lease="+newRef+":", req.Remote, remoteOld+":"+newRef)
+		if err == nil {
+			logs, _, err = runLoggedGit(repo, logs, "branch", "-m", req.Branch, req.NewName)
+			if err != nil {
+				logs, _, _ = runLoggedGit(repo, logs, "push", req.Remote, "--delete", req.NewName)
+			}
+		}
+		if err == nil {
+			logs, _, err = runLoggedGit(repo, logs, "push", req.Remote, "--delete", req.Branch)
+			if err != nil {
+				// Put both sides back rather than leave the branch under two names
+				logs, _, _ = runLoggedGit(repo, logs, "branch", "-m", req.NewName, req.Branch)
+				logs, _, _ = runLoggedGit(repo, logs, "push", req.Remote, "--delete", req.NewName)
+			}
+		}
+		if err == nil {
+			logs, _, err = runLoggedGit(repo, logs, "branch", "--set-upstream-to="+req.Remote+"/"+req.NewName, req.NewName)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to rename branch: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	logs = append(logs, "✓ Branch renamed!")
+	json.NewEncoder(w).Encode(Response{
+		Branch: req.NewName,
+		Log:    logs,
+	})
+}
+
+// handleSetUpstream sets or, with an empty upstream, unsets a branch's upstream
+func handleSetUpstream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Branch   string `json:"branch"`   // defaults to the current branch
+		Upstream string `json:"upstream"` // e.g. origin/main
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if req.Branch == "" {
+		req.Branch, _ = gitBackend.CurrentBranch(repo)
+	}
+	if !isValidRef(req.Branch) || (req.Upstream != "" && !isValidRef(req.Upstream)) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid branch or upstream",
+		})
+		return
+	}
+
+	var logs []string
+	var err error
+	if req.Upstream == "" {
+		logs, _, err = runLoggedGit(repo, logs, "branch", "--unset-upstream", req.Branch)
+	} else {
+		logs, _, err = runLoggedGit(repo, logs, "branch", "--set-upstream-to="+req.Upstream, req.Branch)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to change upstream: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	ahead, behind := getAheadBehind(repo, req.Branch)
+	logs = append(logs, "✓ Upstream updated!")
+	json.NewEncoder(w).Encode(Response{
+		Branch: req.Branch,
+		Ahead:  ahead,
+		Behind: behind,
+		Log:    logs,
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitRemoteBranch(t *testing.T) {
+	remotes := []string{"origin", "team", "team/fork"}
+	tests := []struct {
+		name, remote, branch string
+	}{
+		{"origin/main", "origin", "main"},
+		{"origin/feature/login", "origin", "feature/login"},
+		{"team/main", "team", "main"},
+		{"team/fork/main", "team/fork", "main"},
+		{"team/fork/feature/login", "team/fork", "feature/login"},
+		{"gone/main", "", "gone/main"},
+	}
+	for _, tt := range tests {
+		remote, branch := splitRemoteBranch(tt.name, remotes)
+		if remote != tt.remote || branch != tt.branch {
+			t.Errorf("splitRemoteBranch(%q) = %q, %q, want %q, %q", tt.name, remote, branch, tt.remote, tt.branch)
+		}
+	}
+}
+
+func TestHandleRenameBranchOnRemote(t *testing.T) {
+	base := useTestBase(t)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	remotePath := filepath.Join(base, "remote.git")
+	runGit(t, base, "init", "-q", "--bare", remotePath)
+	runGit(t, repoPath, "remote", "add", "origin", remotePath)
+	for _, branch := range []string{"feature", "locked", "taken"} {
+		runGit(t, repoPath, "branch", branch)
+	}
+	runGit(t, repoPath, "push", "-q", "origin", "--all")
+	runGit(t, repoPath, "fetch", "-q", "origin")
+	// The remote refuses to delete one branch
+	hook := "#!/bin/sh\nwhile read old new ref; do\n" +
+		"  [ \"$ref\" = refs/heads/locked ] && [ \"$new\" = 0000000000000000000000000000000000000000 ] && exit 1\n" +
+		"done\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(remotePath, "hooks", "pre-receive"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+	repo := Repo{Path: repoPath}
+
+	rename := func(branch, newName string) int {
+		t.Helper()
+		body := `{"branch":"` + branch + `","newName":"` + newName + `","remote":"origin"}`
+		r := httptest.NewRequest("POST", "/api/branch/rename?repoPath=web", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handleRenameBranch(w, r)
+		return w.Code
+	}
+	branches := func(dir string) string {
+		t.Helper()
+		output, _ := executeGitCommand(Repo{Path: dir}, "for-each-ref", "--format=%(refname:short)", "refs/heads")
+		return strings.Join(strings.Fields(output), " ")
+	}
+
+	if code := rename("feature", "renamed"); code != 200 {
+		t.Fatalf("rename returned %d", code)
+	}
+	if got := branches(remotePath); got != "locked main renamed taken" {
+		t.Errorf("remote branches after rename = %q", got)
+	}
+	if got := branches(repoPath); got != "locked main renamed taken" {
+		t.Errorf("local branches after rename = %q", got)
+	}
+	if upstream, _ := executeGitCommand(repo, "rev-parse", "--abbrev-ref", "renamed@{upstream}"); upstream != "origin/renamed" {
+		t.Errorf("upstream = %q, want origin/renamed", upstream)
+	}
+
+	// Deleting the old name fails: the branch keeps its old name on both sides
+	if code := rename("locked", "unlocked"); code != 500 {
+		t.Errorf("rename with a failing delete returned %d, want 500", code)
+	}
+	if got := branches(remotePath); got != "locked main renamed taken" {
+		t.Errorf("remote branches after failed delete = %q", got)
+	}
+	if got := branches(repoPath); got != "locked main renamed taken" {
+		t.Errorf("local branches after failed delete = %q", got)
+	}
+
+	// A branch already on the remote under the new name is not overwritten
+	runGit(t, repoPath, "branch", "-D", "taken")
+	runGit(t, repoPath, "commit", "-q", "--allow-empty", "-m", "ahead")
+	runGit(t, repoPath, "push", "-q", "origin", "main")
+	runGit(t, repoPath, "fetch", "-q", "origin")
+	if code := rename("main", "taken"); code != 500 {
+		t.Errorf("rename onto an existing remote branch returned %d, want 500", code)
+	}
+	if got := branches(repoPath); got != "locked main renamed" {
+		t.Errorf("local branches after refused push = %q", got)
+	}
+	taken, _ := executeGitCommand(Repo{Path: remotePath}, "rev-parse", "refs/heads/taken")
+	if head, _ := executeGitCommand(repo, "rev-parse", "HEAD~1"); taken != head {
+		t.Errorf("remote taken moved to %q, want %q", taken, head)
+	}
+}
+
+func TestHandleDeleteProtectedRemoteBranch(t *testing.T) {
+	base := useTestBase(t)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	remotePath := filepath.Join(base, "remote.git")
+	runGit(t, base, "init", "-q", "--bare", remotePath)
+	runGit(t, repoPath, "remote", "add", "origin", remotePath)
+	runGit(t, repoPath, "branch", "release/1.0")
+	runGit(t, repoPath, "push", "-q", "origin", "main", "release/1.0")
+	runGit(t, repoPath, "fetch", "-q", "origin")
+	config.ProtectedBranches = []string{"main"}
+	runGit(t, repoPath, "config", "airgit.protectedBranch", "release/*")
+
+	for _, branch := range []string{"main", "release/1.0"} {
+		body := `{"branch":"` + branch + `","remote":"origin","force":true}`
+		r := httptest.NewRequest("POST", "/api/branch/delete?repoPath=web", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handleDeleteBranch(w, r)
+		if w.Code != 403 {
+			t.Errorf("deleting %s on origin returned %d (%s), want 403", branch, w.Code, w.Body.String())
+		}
+		if _, err := executeGitCommand(Repo{Path: remotePath}, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err != nil {
+			t.Errorf("%s was deleted from the remote", branch)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FileChange is one entry of `git status --porcelain=v2`
+type FileChange struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"origPath,omitempty"` // source path of a rename or copy
+	Index    string `json:"index"`              // staged status code (X), "." when unchanged
+	Worktree string `json:"worktree"`           // unstaged status code (Y), "." when unchanged
+	State    string `json:"state"`              // modified, added, deleted, renamed, copied, typechange, untracked, conflicted
+	Staged   bool   `json:"staged"`
+	Unstaged bool   `json:"unstaged"`
+	Score    int    `json:"score,omitempty"` // rename/copy similarity percentage
+
+	// Submodule is git's "S<c><m><u>" state for submodules: C when the commit
+	// changed, M with tracked changes, U with untracked files, "." otherwise
+	Submodule string `json:"submodule,omitempty"`
+}
+
+// BranchStatus is the "# branch.*" header of `git status --porcelain=v2 --branch`
+type BranchStatus struct {
+	Head     string `json:"head"`
+	Oid      string `json:"oid,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+}
+
+// getChanges returns the working tree status of repo
+func getChanges(repo Repo) (BranchStatus, []FileChange, error) {
+	// stdout only and untrimmed: warnings on stderr would corrupt the NUL-separated records
+	output, err := executeRawGitCommand(repo, "status", "--porcelain=v2", "--branch", "-z", "--untracked-files=all")
+	if err != nil {
+		return BranchStatus{}, nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+	status, changes := parsePorcelainV2(output)
+	return status, changes, nil
+}
+
+// parsePorcelainV2 parses NUL-separated `git status --porcelain=v2 --branch -z` output
+func parsePorcelainV2(output string) (BranchStatus, []FileChange) {
+	var status BranchStatus
+	changes := []FileChange{}
+
+	records := strings.Split(output, "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" {
+			continue
+		}
+
+		switch record[0] {
+		case '#':
+			fields := strings.Fields(record)
+			if len(fields) < 3 {
+				continue
+			}
+			switch fields[1] {
+			case "branch.oid":
+				if fields[2] != "(initial)" {
+					status.Oid = fields[2]
+				}
+			case "branch.head":
+				status.Head = fields[2]
+			case "branch.upstream":
+				status.Upstream = fields[2]
+			case "branch.ab":
+				if len(fields) >= 4 {
+					status.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+					status.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+				}
+			}
+		case '1':
+			// 1 XY sub mH mI mW hH hI path
+			fields := strings.SplitN(record, " ", 9)
+			if len(fields) < 9 {
+				continue
+			}
+			change := newFileChange(fields[1], fields[8])
+			change.setSubmodule(fields[2])
+			changes = append(changes, change)
+		case '2':
+			// 2 XY sub mH mI mW hH hI Xscore path, followed by the original path as its own record
+			fields := strings.SplitN(record, " ", 10)
+			if len(fields) < 10 {
+				continue
+			}
+			change := newFileChange(fields[1], fields[9])
+			change.setSubmodule(fields[2])
+			change.Score, _ = strconv.Atoi(fields[8][1:])
+			if i+1 < len(records) {
+				i++
+				change.OrigPath = records[i]
+			}
+			changes = append(changes, change)
+		case 'u':
+			// u XY sub m1 m2 m3 mW h1 h2 h3 path
+			fields := strings.SplitN(record, " ", 11)
+			if len(fields) < 11 {
+				continue
+			}
+			change := newFileChange(fields[1], fields[10])
+			change.setSubmodule(fields[2])
+			change.State = "conflicted"
+			change.Staged = false
+			change.Unstaged = true
+			changes = append(changes, change)
+		case '?':
+			changes = append(changes, FileChange{
+				Path:     record[2:],
+				Index:    "?",
+				Worktree: "?",
+				State:    "untracked",
+				Unstaged: true,
+			})
+		}
+	}
+
+	return status, changes
+}
+
+func (c *FileChange) setSubmodule(sub string) {
+	if strings.HasPrefix(sub, "S") {
+		c.Submodule = sub
+	}
+}
+
+func newFileChange(xy, path string) FileChange {
+	change := FileChange{
+		Path:     path,
+		Index:    xy[:1],
+		Worktree: xy[1:2],
+	}
+	change.Staged = change.Index != "."
+	change.Unstaged = change.Worktree != "."
+
+	// Prefer the staged state; fall back to the unstaged one
+	code := change.Index
+	if code == "." {
+		code = change.Worktree
+	}
+	switch code {
+	case "M":
+		change.State = "modified"
+	case "A":
+		change.State = "added"
+	case "D":
+		change.State = "deleted"
+	case "R":
+		change.State = "renamed"
+	case "C":
+		change.State = "copied"
+	case "T":
+		change.State = "typechange"
+	default:
+		change.State = "modified"
+	}
+	return change
+}
+
+// hasHead reports whether the repository has at least one commit
+func hasHead(repo Repo) bool {
+	_, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "HEAD")
+	return err == nil
+}
+
+func handleListChanges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	status, changes, err := getChanges(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to get changes: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"branch":  status,
+		"changes": changes,
+	})
+}
+
+// pathsRequest is the body shared by the stage, unstage and discard endpoints
+type pathsRequest struct {
+	Paths []string `json:"paths"`
+	All   bool     `json:"all"`
+}
+
+// decodePathsRequest reads a pathsRequest, writing a 400 response if it is unusable
+func decodePathsRequest(w http.ResponseWriter, r *http.Request) (pathsRequest, bool) {
+	var req pathsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return req, false
+	}
+
+	if !req.All && len(req.Paths) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Either paths or all is required",
+		})
+		return req, false
+	}
+
+	for _, path := range req.Paths {
+		if path == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Empty path",
+			})
+			return req, false
+		}
+	}
+
+	return req, true
+}
+
+// runLoggedGit runs git and appends the command line and its output to logs
+func runLoggedGit(repo Repo, logs []string, args ...string) ([]string, string, error) {
+	logs = append(logs, "$ git "+strings.Join(args, " "))
+	output, err := executeGitCommand(repo, args...)
+	if output != "" {
+		logs = append(logs, output)
+	}
+	return logs, output, err
+}
+
+func handleStage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := decodePathsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	args := []string{"add", "-A"}
+	if !req.All {
+		args = append(args, "--")
+		args = append(args, req.Paths...)
+	}
+
+	logs, _, err := runLoggedGit(repo, nil, args...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to stage files: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	logs = append(logs, "✓ Files staged!")
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+func handleUnstage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := decodePathsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	paths := req.Paths
+	if req.All {
+		paths = []string{"."}
+	}
+
+	var args []string
+	if hasHead(repo) {
+		args = append([]string{"restore", "--staged", "--"}, paths...)
+	} else {
+		// Before the first commit there is no HEAD to restore from
+		args = append([]string{"rm", "-r", "-q", "--cached", "--"}, paths...)
+	}
+
+	logs, _, err := runLoggedGit(repo, nil, args...)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to unstage files: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	logs = append(logs, "✓ Files unstaged!")
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+// handleDiscard throws away unstaged changes. Tracked files are restored from the
+// index and untracked files are deleted.
+func handleDiscard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := decodePathsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	_, changes, err := getChanges(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to get changes: %v", err),
+		})
+		return
+	}
+
+	// A requested path selects a file or everything beneath a directory
+	requested := make([]string, len(req.Paths))
+	matched := make([]bool, len(req.Paths))
+	for i, p := range req.Paths {
+		requested[i] = path.Clean(p)
+	}
+	selected := func(file string) bool {
+		found := false
+		for i, p := range requested {
+			if p == "." || p == file || strings.HasPrefix(file, p+"/") {
+				matched[i], found = true, true
+			}
+		}
+		return found
+	}
+
+	var tracked, untracked []string
+	for _, change := range changes {
+		if !req.All && !selected(change.Path) {
+			continue
+		}
+		switch {
+		case change.State == "untracked":
+			untracked = append(untracked, change.Path)
+		case change.State == "conflicted":
+			// Conflicts must be resolved, not discarded
+			continue
+		case change.Unstaged:
+			tracked = append(tracked, change.Path)
+		}
+	}
+	if !req.All {
+		for i, ok := range matched {
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Response{
+					Error: fmt.Sprintf("No changes to discard in %s", req.Paths[i]),
+				})
+				return
+			}
+		}
+	}
+
+	var logs []string
+	if len(tracked) > 0 {
+		logs, _, err = runLoggedGit(repo, logs, append([]string{"restore", "--worktree", "--"}, tracked...)...)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to discard changes: %v", err),
+				Log:   logs,
+			})
+			return
+		}
+	}
+	if len(untracked) > 0 {
+		logs, _, err = runLoggedGit(repo, logs, append([]string{"clean", "-f", "-q", "--"}, untracked...)...)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to remove untracked files: %v", err),
+				Log:   logs,
+			})
+			return
+		}
+	}
+
+	logs = append(logs, fmt.Sprintf("✓ Discarded changes in %d file(s)", len(tracked)+len(untracked)))
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+// authorPattern matches the "Name <email>" form accepted by git commit --author
+var authorPattern = regexp.MustCompile(`^[^<>\n]+ <[^<>\s]+>$`)
+
+func handleCommit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Message    string `json:"message"`
+		Amend      bool   `json:"amend"`
+		Author     string `json:"author"`
+		SignOff    bool   `json:"signOff"`
+		AllowEmpty bool   `json:"allowEmpty"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if strings.TrimSpace(req.Message) == "" && !req.Amend {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Commit message is required",
+		})
+		return
+	}
+
+	if req.Author != "" && !authorPattern.MatchString(req.Author) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Author must be in the form \"Name <email>\"",
+		})
+		return
+	}
+
+	args := []string{"commit"}
+	if strings.TrimSpace(req.Message) != "" {
+		args = append(args, "-m", req.Message)
+	} else {
+		// Amend keeping the previous message
+		args = append(args, "--no-edit")
+	}
+	if req.Amend {
+		args = append(args, "--amend")
+	}
+	if req.Author != "" {
+		args = append(args, "--author="+req.Author)
+	}
+	if req.SignOff {
+		args = append(args, "--signoff")
+	}
+	if req.AllowEmpty {
+		args = append(args, "--allow-empty")
+	}
+
+	logs, output, err := runLoggedGit(repo, nil, args...)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(output, "nothing to commit") || strings.Contains(output, "no changes added to commit") {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("git commit failed: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	hash, _ := executeGitCommand(repo, "rev-parse", "HEAD")
+	branch, _ := gitBackend.CurrentBranch(repo)
+
+	if req.Amend {
+		logs = append(logs, "✓ Commit amended!")
+	} else {
+		logs = append(logs, "✓ Commit created!")
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Branch: branch,
+		Commit: strings.TrimSpace(hash),
+		Log:    logs,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHandleDiscardPaths(t *testing.T) {
+	tests := []struct {
+		paths string
+		code  int
+		kept  []string // files whose changes survive
+	}{
+		{`["src/"]`, 200, []string{"top.txt"}},
+		{`["./src"]`, 200, []string{"top.txt"}},
+		{`["src/app.go"]`, 200, []string{"top.txt", "src/new.go"}},
+		{`["src/new.go", "top.txt"]`, 200, []string{"src/app.go"}},
+		{`["."]`, 200, nil},
+		{`["sr"]`, 400, []string{"top.txt", "src/app.go", "src/new.go"}},
+		{`["src", "docs"]`, 400, []string{"top.txt", "src/app.go", "src/new.go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.paths, func(t *testing.T) {
+			base := useTestBase(t)
+			repoPath := filepath.Join(base, "web")
+			initTestRepo(t, repoPath)
+			write := func(name, content string) {
+				t.Helper()
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(repoPath, name)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(repoPath, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			write("top.txt", "committed\n")
+			write("src/app.go", "committed\n")
+			runGit(t, repoPath, "add", ".")
+			runGit(t, repoPath, "commit", "-q", "-m", "base")
+			write("top.txt", "changed\n")
+			write("src/app.go", "changed\n")
+			write("src/new.go", "untracked\n")
+
+			r := httptest.NewRequest("POST", "/api/discard?repoPath=web", strings.NewReader(`{"paths":`+tt.paths+`}`))
+			w := httptest.NewRecorder()
+			handleDiscard(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("discard returned %d (%s), want %d", w.Code, w.Body.String(), tt.code)
+			}
+
+			_, changes, err := getChanges(Repo{Path: repoPath})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, change := range changes {
+				kept = append(kept, change.Path)
+			}
+			want := append([]string(nil), tt.kept...)
+			sort.Strings(kept)
+			sort.Strings(want)
+			if strings.Join(kept, ",") != strings.Join(want, ",") {
+				t.Errorf("changes left = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+// useNoisyGit puts a git first in PATH that warns on stderr before running the real one
+func useNoisyGit(t *testing.T) {
+	t.Helper()
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho 'warning: unable to access somewhere: Permission denied' >&2\nexec " + git + " \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "git"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestGetChangesIgnoresWarnings(t *testing.T) {
+	repoPath := filepath.Join(useTestBase(t), "web")
+	initTestRepo(t, repoPath)
+	if err := os.WriteFile(filepath.Join(repoPath, "new.txt"), []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	useNoisyGit(t)
+
+	status, changes, err := getChanges(Repo{Path: repoPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Head != "main" || len(status.Oid) != 40 || len(changes) != 1 || changes[0].Path != "new.txt" || changes[0].State != "untracked" {
+		t.Errorf("getChanges = %+v, %+v", status, changes)
+	}
+}
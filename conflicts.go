@@ -0,0 +1,823 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ConflictStage struct {
+	Mode    string `json:"mode"`
+	Hash    string `json:"hash"`
+	Content string `json:"content,omitempty"`
+}
+
+// ConflictChunk is either unchanged text or one conflict hunk of a merged file
+type ConflictChunk struct {
+	Conflict bool   `json:"conflict"`
+	Text     string `json:"text,omitempty"`
+	Ours     string `json:"ours,omitempty"`
+	Base     string `json:"base,omitempty"`
+	Theirs   string `json:"theirs,omitempty"`
+}
+
+// ConflictFile is an unmerged path. Base, Ours and Theirs are index stages 1-3;
+// a missing stage means that side deleted (or never had) the file.
+type ConflictFile struct {
+	Path       string          `json:"path"`
+	Status     string          `json:"status"` // both modified, both added, deleted by us, ...
+	Binary     bool            `json:"binary,omitempty"`
+	Base       *ConflictStage  `json:"base,omitempty"`
+	Ours       *ConflictStage  `json:"ours,omitempty"`
+	Theirs     *ConflictStage  `json:"theirs,omitempty"`
+	Chunks     []ConflictChunk `json:"chunks,omitempty"`
+	Hunks      int             `json:"hunks,omitempty"`
+	Strategies []string        `json:"strategies"` // strategies suggested for this file
+}
+
+// getConflictStages reads the unmerged index entries, optionally limited to paths
+func getConflictStages(repo Repo, paths ...string) ([]*ConflictFile, error) {
+	args := append([]string{"-c", "core.quotepath=false", "ls-files", "-u", "-z", "--"}, paths...)
+	output, err := executeRawGitCommand(repo, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+
+	files := []*ConflictFile{}
+	byPath := make(map[string]*ConflictFile)
+	for _, record := range strings.Split(output, "\x00") {
+		meta, filePath, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) < 3 {
+			continue
+		}
+
+		file := byPath[filePath]
+		if file == nil {
+			file = &ConflictFile{Path: filePath}
+			byPath[filePath] = file
+			files = append(files, file)
+		}
+		stage := &ConflictStage{Mode: fields[0], Hash: fields[1]}
+		switch fields[2] {
+		case "1":
+			file.Base = stage
+		case "2":
+			file.Ours = stage
+		case "3":
+			file.Theirs = stage
+		}
+	}
+
+	for _, file := range files {
+		file.Status = conflictStatus(file)
+		file.Strategies = []string{}
+		for _, s := range conflictStrategies {
+			// Strategies merge content, so they cannot help when one side deleted the file
+			if file.Ours != nil && file.Theirs != nil && s.Applies(file.Path) {
+				file.Strategies = append(file.Strategies, s.Name)
+			}
+		}
+	}
+	return files, nil
+}
+
+func conflictStatus(file *ConflictFile) string {
+	base, ours, theirs := file.Base != nil, file.Ours != nil, file.Theirs != nil
+	switch {
+	case base && ours && theirs:
+		return "both modified"
+	case ours && theirs:
+		return "both added"
+	case base && ours:
+		return "deleted by them"
+	case base && theirs:
+		return "deleted by us"
+	case ours:
+		return "added by us"
+	case theirs:
+		return "added by them"
+	}
+	return "both deleted"
+}
+
+// loadConflictContent reads the stage blobs and, for text files, splits a diff3
+// merge of them into chunks
+func loadConflictContent(repo Repo, file *ConflictFile) error {
+	var contents [3]string
+	for i, stage := range []*ConflictStage{file.Base, file.Ours, file.Theirs} {
+		if stage == nil {
+			continue
+		}
+		content, err := readBlob(repo, stage.Hash, maxBlobSize+1)
+		if err != nil {
+			return err
+		}
+		if len(content) > maxBlobSize || isBinaryContent(content) {
+			file.Binary = true
+		}
+		contents[i] = string(content)
+	}
+	if file.Binary {
+		return nil
+	}
+
+	for i, stage := range []*ConflictStage{file.Base, file.Ours, file.Theirs} {
+		if stage != nil {
+			stage.Content = contents[i]
+		}
+	}
+	if file.Ours == nil || file.Theirs == nil {
+		// Modify/delete conflicts have no hunks; one side has to be taken as a whole
+		return nil
+	}
+
+	markerSize := conflictMarkerSize(contents[:]...)
+	merged, err := mergeFileContents(contents[1], contents[0], contents[2], markerSize)
+	if err != nil {
+		return err
+	}
+	file.Chunks = parseConflictChunks(merged, markerSize)
+	for _, c := range file.Chunks {
+		if c.Conflict {
+			file.Hunks++
+		}
+	}
+	return nil
+}
+
+// conflictMarkerSize returns a conflict marker length longer than any run of
+// marker characters starting a line of contents, so that lines like a setext
+// underline "=======" are not mistaken for markers
+func conflictMarkerSize(contents ...string) int {
+	size := 7
+	for _, content := range contents {
+		for _, line := range strings.Split(content, "\n") {
+			for _, c := range []string{"<", "|", "=", ">"} {
+				if n := len(line) - len(strings.TrimLeft(line, c)); n >= size {
+					size = n + 1
+				}
+			}
+		}
+	}
+	return size
+}
+
+// mergeFileContents runs a three-way merge with diff3 markers of markerSize characters,
+// labelled ours, base and theirs
+func mergeFileContents(ours, base, theirs string, markerSize int) (string, error) {
+	dir, err := os.MkdirTemp("", "airgit-merge-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"merge-file", "-p", "--diff3", fmt.Sprintf("--marker-size=%d", markerSize), "-L", "ours", "-L", "base", "-L", "theirs"}
+	for _, side := range []struct{ name, content string }{{"ours", ours}, {"base", base}, {"theirs", theirs}} {
+		name := filepath.Join(dir, side.name)
+		if err := os.WriteFile(name, []byte(side.content), 0600); err != nil {
+			return "", err
+		}
+		args = append(args, name)
+	}
+
+	cmd := exec.Command("git", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	// The exit status is the number of conflicts; failures are reported as negative
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// parseConflictChunks splits the output of mergeFileContents at its conflict markers
+func parseConflictChunks(merged string, markerSize int) []ConflictChunk {
+	oursMarker := strings.Repeat("<", markerSize) + " ours"
+	baseMarker := strings.Repeat("|", markerSize) + " base"
+	separator := strings.Repeat("=", markerSize)
+	theirsMarker := strings.Repeat(">", markerSize) + " theirs"
+
+	chunks := []ConflictChunk{}
+	var text strings.Builder
+	var hunk *ConflictChunk
+	section := ""
+
+	for _, line := range strings.SplitAfter(merged, "\n") {
+		marker := strings.TrimRight(line, "\r\n")
+		switch {
+		case hunk == nil && marker == oursMarker:
+			if text.Len() > 0 {
+				chunks = append(chunks, ConflictChunk{Text: text.String()})
+				text.Reset()
+			}
+			hunk = &ConflictChunk{Conflict: true}
+			section = "ours"
+		case hunk == nil:
+			text.WriteString(line)
+		case marker == baseMarker:
+			section = "base"
+		case marker == separator:
+			section = "theirs"
+		case marker == theirsMarker:
+			chunks = append(chunks, *hunk)
+			hunk = nil
+		case section == "ours":
+			hunk.Ours += line
+		case section == "base":
+			hunk.Base += line
+		default:
+			hunk.Theirs += line
+		}
+	}
+	if text.Len() > 0 {
+		chunks = append(chunks, ConflictChunk{Text: text.String()})
+	}
+	return chunks
+}
+
+// assembleConflict rebuilds the file, taking pick's text for each conflict hunk
+func assembleConflict(file *ConflictFile, pick func(i int, hunk ConflictChunk) (string, error)) ([]byte, error) {
+	if file.Binary || file.Ours == nil || file.Theirs == nil {
+		return nil, fmt.Errorf("%s is not a text conflict", file.Path)
+	}
+
+	var b strings.Builder
+	i := 0
+	for _, c := range file.Chunks {
+		if !c.Conflict {
+			b.WriteString(c.Text)
+			continue
+		}
+		text, err := pick(i, c)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(text)
+		i++
+	}
+	return []byte(b.String()), nil
+}
+
+// conflictStrategy resolves a whole conflicted file, or returns an error if it cannot
+type conflictStrategy struct {
+	Name    string
+	Default bool                       // tried when no strategies are named, for files it Applies to
+	Applies func(filePath string) bool // whether to suggest the strategy for a file
+	Resolve func(repo Repo, file *ConflictFile) ([]byte, error)
+}
+
+var conflictStrategies = []conflictStrategy{
+	{Name: "identical", Default: true, Applies: anyPath, Resolve: resolveIdentical},
+	{Name: "whitespace-only", Default: true, Applies: anyPath, Resolve: resolveWhitespaceOnly},
+	{Name: "union", Default: true, Applies: isChangelogPath, Resolve: resolveUnion},
+	{Name: "lockfile", Applies: isLockfilePath, Resolve: resolveLockfile},
+}
+
+func findConflictStrategy(name string) *conflictStrategy {
+	for i := range conflictStrategies {
+		if conflictStrategies[i].Name == name {
+			return &conflictStrategies[i]
+		}
+	}
+	return nil
+}
+
+func anyPath(string) bool { return true }
+
+func isChangelogPath(filePath string) bool {
+	name := strings.ToUpper(path.Base(filePath))
+	for _, prefix := range []string{"CHANGELOG", "CHANGES", "HISTORY", "NEWS"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveIdentical takes ours where both sides made the same change, differing at most in line endings
+func resolveIdentical(repo Repo, file *ConflictFile) ([]byte, error) {
+	return assembleConflict(file, func(i int, hunk ConflictChunk) (string, error) {
+		if strings.ReplaceAll(hunk.Ours, "\r\n", "\n") != strings.ReplaceAll(hunk.Theirs, "\r\n", "\n") {
+			return "", fmt.Errorf("hunk %d differs", i)
+		}
+		return hunk.Ours, nil
+	})
+}
+
+// resolveWhitespaceOnly keeps the real change in hunks where the other side only changed whitespace
+func resolveWhitespaceOnly(repo Repo, file *ConflictFile) ([]byte, error) {
+	normalize := func(s string) string { return strings.Join(strings.Fields(s), " ") }
+	return assembleConflict(file, func(i int, hunk ConflictChunk) (string, error) {
+		ours, base, theirs := normalize(hunk.Ours), normalize(hunk.Base), normalize(hunk.Theirs)
+		switch {
+		case ours == theirs || theirs == base:
+			return hunk.Ours, nil
+		case ours == base:
+			return hunk.Theirs, nil
+		}
+		return "", fmt.Errorf("hunk %d has conflicting changes", i)
+	})
+}
+
+// resolveUnion keeps both sides of every hunk, ours first, like the union merge driver
+func resolveUnion(repo Repo, file *ConflictFile) ([]byte, error) {
+	return assembleConflict(file, func(i int, hunk ConflictChunk) (string, error) {
+		return hunk.Ours + hunk.Theirs, nil
+	})
+}
+
+// lockfileCommands regenerate a lock file from its (already merged) manifest.
+// go.sum has no command: its lines are independent, so both sides are combined.
+var lockfileCommands = map[string][]string{
+	"package-lock.json":   {"npm", "install", "--package-lock-only", "--ignore-scripts"},
+	"npm-shrinkwrap.json": {"npm", "install", "--package-lock-only", "--ignore-scripts"},
+	"pnpm-lock.yaml":      {"pnpm", "install", "--lockfile-only", "--ignore-scripts"},
+	"yarn.lock":           {"yarn", "install", "--ignore-scripts"},
+	"Cargo.lock":          {"cargo", "update", "--workspace"},
+	"Gemfile.lock":        {"bundle", "lock"},
+	"composer.lock":       {"composer", "update", "--lock", "--no-scripts"},
+	"poetry.lock":         {"poetry", "lock"},
+	"go.sum":              nil,
+}
+
+var poetryVersionPattern = regexp.MustCompile(`version (\d+)\.`)
+
+// lockfileCommand returns the command regenerating the lock file name with the
+// installed tool. Poetry before 2.0 updates dependencies on lock unless told not
+// to; 2.0 keeps them by default and rejects --no-update.
+func lockfileCommand(name string) ([]string, error) {
+	command := lockfileCommands[name]
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, fmt.Errorf("%s is not installed", command[0])
+	}
+	if command[0] == "poetry" {
+		output, err := exec.Command("poetry", "--version").Output()
+		m := poetryVersionPattern.FindSubmatch(output)
+		if err != nil || m == nil {
+			return nil, fmt.Errorf("cannot tell the poetry version: %v", err)
+		}
+		if major, _ := strconv.Atoi(string(m[1])); major < 2 {
+			command = append(command[:len(command):len(command)], "--no-update")
+		}
+	}
+	return command, nil
+}
+
+// lockfileTimeout bounds a lock file regeneration, which may download packages
+const lockfileTimeout = 5 * time.Minute
+
+func isLockfilePath(filePath string) bool {
+	_, ok := lockfileCommands[path.Base(filePath)]
+	return ok
+}
+
+func resolveLockfile(repo Repo, file *ConflictFile) ([]byte, error) {
+	command, ok := lockfileCommands[path.Base(file.Path)]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a known lock file", file.Path)
+	}
+	if file.Binary || file.Ours == nil || file.Theirs == nil {
+		return nil, fmt.Errorf("%s is not a text conflict", file.Path)
+	}
+
+	if command == nil {
+		seen := make(map[string]bool)
+		var lines []string
+		for _, line := range strings.Split(file.Ours.Content+"\n"+file.Theirs.Content, "\n") {
+			if line != "" && !seen[line] {
+				seen[line] = true
+				lines = append(lines, line)
+			}
+		}
+		sort.Strings(lines)
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	}
+
+	if !config.LockfileCommands {
+		return nil, fmt.Errorf("running %s is disabled; start AirGit with --lockfile-commands to allow it", command[0])
+	}
+	command, err := lockfileCommand(path.Base(file.Path))
+	if err != nil {
+		return nil, err
+	}
+	absPath, err := resolveWritablePath(repo, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the conflicted file, markers and all, to put back if the tool fails
+	original, err := os.ReadFile(absPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	existed := err == nil
+	restore := func() {
+		if existed {
+			writeFileAtomic(absPath, original)
+		} else {
+			os.Remove(absPath)
+		}
+	}
+
+	// Start from our lock file; the tool reconciles it with the manifest
+	if err := writeFileAtomic(absPath, []byte(file.Ours.Content)); err != nil {
+		restore()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockfileTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = filepath.Dir(absPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		restore()
+		return nil, fmt.Errorf("%s failed: %v: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+	}
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		restore()
+		return nil, err
+	}
+	return content, nil
+}
+
+// writeResolution writes the resolved content and stages it, marking the file resolved
+func writeResolution(repo Repo, filePath string, content []byte) error {
+	absPath, err := resolveWritablePath(repo, filePath)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(absPath, content); err != nil {
+		return err
+	}
+	if output, err := executeGitCommand(repo, "add", "--", filePath); err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// applyConflictStrategies tries the named strategies (or the defaults) in order on each
+// conflicted path, staging the files that are resolved. It returns the strategy that
+// resolved each path and the paths that are still conflicted.
+func applyConflictStrategies(repo Repo, paths, names []string) (map[string]string, []string, error) {
+	var strategies []*conflictStrategy
+	for _, name := range names {
+		s := findConflictStrategy(name)
+		if s == nil {
+			return nil, nil, fmt.Errorf("unknown strategy: %s", name)
+		}
+		strategies = append(strategies, s)
+	}
+
+	files, err := getConflictStages(repo, paths...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileSaveMutex.Lock()
+	defer fileSaveMutex.Unlock()
+
+	resolved := make(map[string]string)
+	remaining := []string{}
+	for _, file := range files {
+		candidates := strategies
+		if len(names) == 0 {
+			for i := range conflictStrategies {
+				if s := &conflictStrategies[i]; s.Default && s.Applies(file.Path) {
+					candidates = append(candidates, s)
+				}
+			}
+		}
+
+		if err := loadConflictContent(repo, file); err == nil {
+			for _, s := range candidates {
+				content, err := s.Resolve(repo, file)
+				if err != nil {
+					continue
+				}
+				if err := writeResolution(repo, file.Path, content); err == nil {
+					resolved[file.Path] = s.Name
+				}
+				break
+			}
+		}
+		if _, ok := resolved[file.Path]; !ok {
+			remaining = append(remaining, file.Path)
+		}
+	}
+	return resolved, remaining, nil
+}
+
+// conflictFileRequest reads and validates the path of a conflicted file, writing an error response if needed
+func conflictFileRequest(w http.ResponseWriter, repo Repo, rawPath string) (*ConflictFile, bool) {
+	filePath, ok := resolveRepoFilePath(repo, rawPath)
+	if !ok || filePath == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid path",
+		})
+		return nil, false
+	}
+
+	files, err := getConflictStages(repo, filePath)
+	if err == nil && len(files) > 0 {
+		err = loadConflictContent(repo, files[0])
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read conflict: %v", err),
+		})
+		return nil, false
+	}
+	if len(files) == 0 || files[0].Path != filePath {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("%s has no conflicts", filePath),
+		})
+		return nil, false
+	}
+	return files[0], true
+}
+
+// handleListConflicts serves GET /api/conflicts
+func handleListConflicts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := getConflictStages(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list conflicts: %v", err),
+		})
+		return
+	}
+
+	strategies := []string{}
+	for _, s := range conflictStrategies {
+		strategies = append(strategies, s.Name)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conflicts":  files,
+		"strategies": strategies,
+	})
+}
+
+// handleConflictFile serves GET /api/conflicts/file?path=
+func handleConflictFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	file, ok := conflictFileRequest(w, repo, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(file)
+}
+
+// handleResolveConflict resolves one file, either hunk by hunk, with full custom
+// content, or by taking one side (or the working tree copy) as a whole
+func handleResolveConflict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path  string `json:"path"`
+		Hunks []struct {
+			Choice  string `json:"choice"` // ours, theirs, base, both or custom
+			Content string `json:"content"`
+		} `json:"hunks"`
+		Content *string `json:"content"`
+		Side    string  `json:"side"` // ours, theirs or working
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4*maxBlobSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	file, ok := conflictFileRequest(w, repo, req.Path)
+	if !ok {
+		return
+	}
+
+	fileSaveMutex.Lock()
+	defer fileSaveMutex.Unlock()
+
+	var logs []string
+	var err error
+	switch {
+	case req.Side == "ours" || req.Side == "theirs":
+		stage := file.Ours
+		if req.Side == "theirs" {
+			stage = file.Theirs
+		}
+		if stage == nil {
+			logs, _, err = runLoggedGit(repo, logs, "rm", "--quiet", "--", file.Path)
+		} else {
+			logs, _, err = runLoggedGit(repo, logs, "checkout", "--"+req.Side, "--", file.Path)
+			if err == nil {
+				logs, _, err = runLoggedGit(repo, logs, "add", "--", file.Path)
+			}
+		}
+
+	case req.Side == "working":
+		absPath, pathErr := resolveWritablePath(repo, file.Path)
+		content, readErr := os.ReadFile(absPath)
+		if pathErr != nil || readErr != nil {
+			err = fmt.Errorf("cannot read %s", file.Path)
+			break
+		}
+		if !file.Binary && hasConflictMarkers(string(content)) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("%s still contains conflict markers", file.Path),
+			})
+			return
+		}
+		logs, _, err = runLoggedGit(repo, logs, "add", "--", file.Path)
+
+	case req.Side != "":
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "side must be ours, theirs or working",
+		})
+		return
+
+	default:
+		var content []byte
+		if req.Content != nil {
+			content = []byte(*req.Content)
+		} else {
+			if len(req.Hunks) != file.Hunks {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Response{
+					Error: fmt.Sprintf("%s has %d conflict hunk(s); a resolution is needed for each", file.Path, file.Hunks),
+				})
+				return
+			}
+			content, err = assembleConflict(file, func(i int, hunk ConflictChunk) (string, error) {
+				switch choice := req.Hunks[i]; choice.Choice {
+				case "ours":
+					return hunk.Ours, nil
+				case "theirs":
+					return hunk.Theirs, nil
+				case "base":
+					return hunk.Base, nil
+				case "both":
+					return hunk.Ours + hunk.Theirs, nil
+				case "custom":
+					return choice.Content, nil
+				default:
+					return "", fmt.Errorf("invalid choice for hunk %d: %q", i, choice.Choice)
+				}
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Response{
+					Error: err.Error(),
+				})
+				return
+			}
+		}
+		err = writeResolution(repo, file.Path, content)
+		logs = append(logs, "$ git add -- "+file.Path)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to resolve %s: %v", file.Path, err),
+			Log:   logs,
+		})
+		return
+	}
+
+	remaining := getConflictFiles(repo)
+	if remaining == nil {
+		remaining = []string{}
+	}
+	logs = append(logs, fmt.Sprintf("✓ Resolved %s", file.Path))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":      file.Path,
+		"remaining": remaining,
+		"log":       logs,
+	})
+}
+
+// hasConflictMarkers reports whether content still has a line starting a conflict
+func hasConflictMarkers(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAutoResolveConflicts runs conflict strategies over the conflicted files
+func handleAutoResolveConflicts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Paths      []string `json:"paths"`
+		Strategies []string `json:"strategies"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	var paths []string
+	for _, p := range req.Paths {
+		resolved, ok := resolveRepoFilePath(repo, p)
+		if !ok || resolved == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Invalid path: %s", p),
+			})
+			return
+		}
+		paths = append(paths, resolved)
+	}
+	for _, name := range req.Strategies {
+		if findConflictStrategy(name) == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Unknown strategy: %s", name),
+			})
+			return
+		}
+	}
+
+	resolved, remaining, err := applyConflictStrategies(repo, paths, req.Strategies)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to resolve conflicts: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resolved":  resolved,
+		"remaining": remaining,
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConflictChunksWithMarkerLikeLines(t *testing.T) {
+	base := "Title\n=======\n\nbody\n"
+	ours := "Title\n=======\n\nOurs\n=======\n"
+	theirs := "Title\n=======\n\nTheirs\n-------\n"
+
+	size := conflictMarkerSize(base, ours, theirs)
+	if size != 8 {
+		t.Errorf("conflictMarkerSize = %d, want 8", size)
+	}
+	merged, err := mergeFileContents(ours, base, theirs, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ConflictChunk{
+		{Text: "Title\n=======\n\n"},
+		{Conflict: true, Ours: "Ours\n=======\n", Base: "body\n", Theirs: "Theirs\n-------\n"},
+	}
+	got := parseConflictChunks(merged, size)
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %+v\n%s", len(got), len(want), got, merged)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chunk %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResolveLockfileRestoresOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// An npm that fails after scribbling over the lock file
+	if err := os.WriteFile(filepath.Join(bin, "npm"), []byte("#!/bin/sh\necho broken > package-lock.json\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.LockfileCommands = true
+
+	conflicted := "{\n<<<<<<< ours\n  \"a\": 1\n=======\n  \"a\": 2\n>>>>>>> theirs\n}\n"
+	lockfile := filepath.Join(dir, "package-lock.json")
+	if err := os.WriteFile(lockfile, []byte(conflicted), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file := &ConflictFile{
+		Path:   "package-lock.json",
+		Ours:   &ConflictStage{Content: "{\n  \"a\": 1\n}\n"},
+		Theirs: &ConflictStage{Content: "{\n  \"a\": 2\n}\n"},
+	}
+	if _, err := resolveLockfile(Repo{Path: dir}, file); err == nil {
+		t.Fatal("resolveLockfile succeeded with a failing npm")
+	}
+	content, err := os.ReadFile(lockfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != conflicted {
+		t.Errorf("lock file = %q, want the conflicted original back", content)
+	}
+}
+
+func TestResolveLockfileCommandsDisabled(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// An npm that leaves a mark when it runs
+	if err := os.WriteFile(filepath.Join(bin, "npm"), []byte("#!/bin/sh\ntouch "+filepath.Join(dir, "ran")+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.LockfileCommands = false
+
+	file := &ConflictFile{
+		Path:   "package-lock.json",
+		Ours:   &ConflictStage{Content: "{}\n"},
+		Theirs: &ConflictStage{Content: "{}\n"},
+	}
+	if _, err := resolveLockfile(Repo{Path: dir}, file); err == nil {
+		t.Error("resolveLockfile ran npm without --lockfile-commands")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("npm was run")
+	}
+
+	// go.sum needs no command
+	sum := &ConflictFile{
+		Path:   "go.sum",
+		Ours:   &ConflictStage{Content: "b v1 h1:b\na v1 h1:a\n"},
+		Theirs: &ConflictStage{Content: "c v1 h1:c\na v1 h1:a\n"},
+	}
+	content, err := resolveLockfile(Repo{Path: dir}, sum)
+	if err != nil || string(content) != "a v1 h1:a\nb v1 h1:b\nc v1 h1:c\n" {
+		t.Errorf("go.sum merged to %q, %v", content, err)
+	}
+}
+
+func TestLockfileCommandPoetryVersion(t *testing.T) {
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	tests := map[string]string{
+		"Poetry (version 1.8.3)": "poetry lock --no-update",
+		"Poetry (version 2.1.0)": "poetry lock",
+	}
+	for version, want := range tests {
+		if err := os.WriteFile(filepath.Join(bin, "poetry"), []byte("#!/bin/sh\necho '"+version+"'\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		command, err := lockfileCommand("poetry.lock")
+		if err != nil || strings.Join(command, " ") != want {
+			t.Errorf("with %s: lockfileCommand = %v, %v; want %s", version, command, err, want)
+		}
+	}
+	if got := strings.Join(lockfileCommands["poetry.lock"], " "); got != "poetry lock" {
+		t.Errorf("lockfileCommand changed the shared command to %q", got)
+	}
+}
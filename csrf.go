@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Browsers attach cookies to requests other sites make to AirGit, so every
+// state-changing API request must come from an allowed origin and carry the
+// CSRF cookie's value in the X-CSRF-Token header. Other sites can neither
+// read the cookie nor set the header without a CORS preflight AirGit denies.
+const (
+	csrfCookieName = "airgit_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfLifetime   = 365 * 24 * time.Hour
+)
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// ensureCSRFCookie gives the browser a CSRF token if it has none yet
+func ensureCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:  csrfCookieName,
+		Value: randomToken(32),
+		Path:  "/",
+		// Not HttpOnly: the frontend copies it into the header
+		MaxAge:   int(csrfLifetime.Seconds()),
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// checkOrigin accepts requests whose Origin, or Referer if there is no Origin,
+// is AirGit itself or in config.AllowedOrigins. Requests with neither header
+// do not come from a browser page and only need the CSRF token.
+func checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer, err := url.Parse(r.Header.Get("Referer"))
+		if err != nil || referer.Host == "" {
+			return nil
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+
+	for _, allowed := range config.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return nil
+		}
+	}
+	u, err := url.Parse(origin)
+	if err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	return fmt.Errorf("Requests from %s are not allowed", origin)
+}
+
+func checkCSRFToken(r *http.Request) error {
+	cookie, err := r.Cookie(csrfCookieName)
+	header := r.Header.Get(csrfHeaderName)
+	if err != nil || cookie.Value == "" || header == "" {
+		return errors.New("Missing CSRF token: send the airgit_csrf cookie's value in the X-CSRF-Token header")
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+		return errors.New("Invalid CSRF token")
+	}
+	return nil
+}
+
+// requireCSRF rejects state-changing API requests that may have been made by another site
+func requireCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ensureCSRFCookie(w, r)
+		if isSafeMethod(r.Method) || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Browsers never send bearer tokens by themselves, so API token clients
+		// are exempt unless --csrf-exempt-tokens=false; the token is checked later
+		if config.CSRFExemptTokens && !config.AuthDisabled && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err := checkOrigin(r)
+		if err == nil {
+			err = checkCSRFToken(r)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(Response{
+				Error: err.Error(),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
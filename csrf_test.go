@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireCSRF(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.AllowedOrigins = []string{"https://airgit.example.org/"}
+
+	handler := requireCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// httptest requests are made to example.com
+	tests := []struct {
+		name         string
+		method, path string
+		origin       string
+		referer      string
+		cookie       string
+		header       string
+		bearer       bool
+		exemptTokens bool
+		authDisabled bool
+		code         int
+	}{
+		{name: "safe method", method: "GET", path: "/api/status", origin: "https://evil.example", code: 200},
+		{name: "not the API", method: "POST", path: "/login", origin: "https://evil.example", code: 200},
+		{name: "same origin", method: "POST", path: "/api/push", origin: "http://example.com", cookie: "t", header: "t", code: 200},
+		{name: "allowed origin", method: "POST", path: "/api/push", origin: "https://AirGit.example.org", cookie: "t", header: "t", code: 200},
+		{name: "no origin", method: "POST", path: "/api/push", cookie: "t", header: "t", code: 200},
+		{name: "cross origin", method: "POST", path: "/api/push", origin: "https://evil.example", cookie: "t", header: "t", code: 403},
+		{name: "cross-site referer", method: "POST", path: "/api/push", referer: "https://evil.example/page", cookie: "t", header: "t", code: 403},
+		{name: "same-site referer", method: "POST", path: "/api/push", referer: "http://example.com/", cookie: "t", header: "t", code: 200},
+		{name: "missing header", method: "POST", path: "/api/push", origin: "http://example.com", cookie: "t", code: 403},
+		{name: "missing cookie", method: "POST", path: "/api/push", origin: "http://example.com", header: "t", code: 403},
+		{name: "mismatched header", method: "DELETE", path: "/api/push", origin: "http://example.com", cookie: "t", header: "u", code: 403},
+		{name: "bearer exempt", method: "POST", path: "/api/push", origin: "https://evil.example", bearer: true, exemptTokens: true, code: 200},
+		{name: "bearer not exempt", method: "POST", path: "/api/push", bearer: true, code: 403},
+		// Without authentication the bearer header proves nothing
+		{name: "bearer with auth disabled", method: "POST", path: "/api/push", bearer: true, exemptTokens: true, authDisabled: true, code: 403},
+	}
+	for _, tt := range tests {
+		config.CSRFExemptTokens, config.AuthDisabled = tt.exemptTokens, tt.authDisabled
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.referer != "" {
+			r.Header.Set("Referer", tt.referer)
+		}
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set(csrfHeaderName, tt.header)
+		}
+		if tt.bearer {
+			r.Header.Set("Authorization", "Bearer airgit_token")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d (%s), want %d", tt.name, w.Code, w.Body.String(), tt.code)
+		}
+	}
+}
+
+func TestEnsureCSRFCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	ensureCSRFCookie(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName || cookies[0].Value == "" || cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v, want a readable %s cookie", cookies, csrfCookieName)
+	}
+
+	// An existing token is kept
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	ensureCSRFCookie(w, r)
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("cookie was replaced: %+v", w.Result().Cookies())
+	}
+}
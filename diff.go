@@ -0,0 +1,657 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type DiffFile struct {
+	OldPath    string     `json:"oldPath"`
+	NewPath    string     `json:"newPath"`
+	Status     string     `json:"status"` // added, deleted, modified, renamed, copied
+	Similarity int        `json:"similarity,omitempty"`
+	OldMode    string     `json:"oldMode,omitempty"`
+	NewMode    string     `json:"newMode,omitempty"`
+	Binary     bool       `json:"binary"`
+	Additions  int        `json:"additions"`
+	Deletions  int        `json:"deletions"`
+	Hunks      []DiffHunk `json:"hunks"`
+
+	header []string // raw "diff --git" ... "+++" lines, used to rebuild patches
+}
+
+type DiffHunk struct {
+	Header   string     `json:"header"`
+	OldStart int        `json:"oldStart"`
+	OldLines int        `json:"oldLines"`
+	NewStart int        `json:"newStart"`
+	NewLines int        `json:"newLines"`
+	Section  string     `json:"section,omitempty"` // function context git prints after the @@ markers
+	Lines    []DiffLine `json:"lines"`
+	Rows     []SplitRow `json:"rows,omitempty"` // side-by-side layout, only with view=split
+}
+
+type DiffLine struct {
+	Type      string        `json:"type"` // context, add, delete
+	Content   string        `json:"content"`
+	OldLine   int           `json:"oldLine,omitempty"`
+	NewLine   int           `json:"newLine,omitempty"`
+	NoNewline bool          `json:"noNewline,omitempty"` // "\ No newline at end of file" follows this line
+	Segments  []DiffSegment `json:"segments,omitempty"`  // intra-line word changes for paired add/delete lines
+}
+
+type DiffSegment struct {
+	Text    string `json:"text"`
+	Changed bool   `json:"changed"`
+}
+
+// SplitRow is one row of a side-by-side view; Left and Right index into the hunk's Lines, -1 when empty
+type SplitRow struct {
+	Left  int `json:"left"`
+	Right int `json:"right"`
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+// refPattern restricts user-supplied revisions so they cannot be mistaken for options
+var refPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_./@{}~^:+-]*$`)
+
+func isValidRef(ref string) bool {
+	return refPattern.MatchString(ref) && !strings.Contains(ref, "..")
+}
+
+// diffArgs returns the git arguments producing the diff selected by mode
+func diffArgs(repo Repo, mode, commit, from, to string) ([]string, error) {
+	switch mode {
+	case "", "worktree":
+		return []string{"diff"}, nil
+	case "staged":
+		return []string{"diff", "--cached"}, nil
+	case "commit":
+		if !isValidRef(commit) {
+			return nil, fmt.Errorf("invalid commit")
+		}
+		return commitDiffArgs(repo, commit), nil
+	case "range":
+		if !isValidRef(from) || !isValidRef(to) {
+			return nil, fmt.Errorf("invalid from or to ref")
+		}
+		return []string{"diff", from, to}, nil
+	default:
+		return nil, fmt.Errorf("unknown diff mode %q", mode)
+	}
+}
+
+// commitDiffArgs compares commit with its first parent, or with the empty tree for root commits
+func commitDiffArgs(repo Repo, commit string) []string {
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", commit+"^1"); err != nil {
+		return []string{"diff-tree", "-r", "--root", "--no-commit-id", commit}
+	}
+	return []string{"diff", commit + "^1", commit}
+}
+
+// getDiff runs git diff and parses the result. args come from diffArgs.
+func getDiff(repo Repo, args []string, paths []string, context int, ignoreWhitespace bool) ([]DiffFile, error) {
+	full := []string{"-c", "core.quotepath=false"}
+	full = append(full, args...)
+	full = append(full, "--no-color", "--no-ext-diff", "-M", "--src-prefix=a/", "--dst-prefix=b/",
+		"-U"+strconv.Itoa(context))
+	if ignoreWhitespace {
+		full = append(full, "-w")
+	}
+	if len(paths) > 0 {
+		full = append(full, "--")
+		full = append(full, paths...)
+	}
+
+	output, err := executeRawGitCommand(repo, full...)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+	return parseUnifiedDiff(output), nil
+}
+
+// executeRawGitCommand is executeGitCommand without trimming, for output where whitespace matters
+func executeRawGitCommand(repo Repo, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo.Path
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return stderr.String(), err
+	}
+	return string(output), nil
+}
+
+// parseUnifiedDiff parses `git diff` output into files, hunks and lines
+func parseUnifiedDiff(output string) []DiffFile {
+	files := []DiffFile{}
+	var file *DiffFile
+	var hunk *DiffHunk
+	oldLine, newLine := 0, 0
+
+	lines := strings.Split(output, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "diff --git ") {
+			files = append(files, DiffFile{Status: "modified", Hunks: []DiffHunk{}})
+			file = &files[len(files)-1]
+			hunk = nil
+			file.header = []string{line}
+			file.OldPath, file.NewPath = splitDiffGitLine(strings.TrimPrefix(line, "diff --git "))
+			continue
+		}
+		if file == nil {
+			continue
+		}
+
+		if hunk == nil || !isHunkBodyLine(line) {
+			if m := hunkHeaderPattern.FindStringSubmatch(line); m != nil {
+				file.Hunks = append(file.Hunks, DiffHunk{
+					Header:   line,
+					OldStart: atoiDefault(m[1], 0),
+					OldLines: atoiDefault(m[2], 1),
+					NewStart: atoiDefault(m[3], 0),
+					NewLines: atoiDefault(m[4], 1),
+					Section:  m[5],
+					Lines:    []DiffLine{},
+				})
+				hunk = &file.Hunks[len(file.Hunks)-1]
+				oldLine, newLine = hunk.OldStart, hunk.NewStart
+				continue
+			}
+		}
+
+		if hunk == nil {
+			// Extended header lines
+			file.header = append(file.header, line)
+			switch {
+			case strings.HasPrefix(line, "new file mode "):
+				file.Status = "added"
+				file.NewMode = strings.TrimPrefix(line, "new file mode ")
+			case strings.HasPrefix(line, "deleted file mode "):
+				file.Status = "deleted"
+				file.OldMode = strings.TrimPrefix(line, "deleted file mode ")
+			case strings.HasPrefix(line, "old mode "):
+				file.OldMode = strings.TrimPrefix(line, "old mode ")
+			case strings.HasPrefix(line, "new mode "):
+				file.NewMode = strings.TrimPrefix(line, "new mode ")
+			case strings.HasPrefix(line, "similarity index "):
+				file.Similarity = atoiDefault(strings.TrimSuffix(strings.TrimPrefix(line, "similarity index "), "%"), 0)
+			case strings.HasPrefix(line, "rename from "):
+				file.Status = "renamed"
+				file.OldPath = unquoteDiffPath(strings.TrimPrefix(line, "rename from "))
+			case strings.HasPrefix(line, "rename to "):
+				file.NewPath = unquoteDiffPath(strings.TrimPrefix(line, "rename to "))
+			case strings.HasPrefix(line, "copy from "):
+				file.Status = "copied"
+				file.OldPath = unquoteDiffPath(strings.TrimPrefix(line, "copy from "))
+			case strings.HasPrefix(line, "copy to "):
+				file.NewPath = unquoteDiffPath(strings.TrimPrefix(line, "copy to "))
+			case strings.HasPrefix(line, "--- "):
+				if path := strings.TrimPrefix(line, "--- "); path != "/dev/null" {
+					file.OldPath = strings.TrimPrefix(unquoteDiffPath(path), "a/")
+				}
+			case strings.HasPrefix(line, "+++ "):
+				if path := strings.TrimPrefix(line, "+++ "); path != "/dev/null" {
+					file.NewPath = strings.TrimPrefix(unquoteDiffPath(path), "b/")
+				}
+			case strings.HasPrefix(line, "Binary files "), line == "GIT binary patch":
+				file.Binary = true
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			hunk.Lines = append(hunk.Lines, DiffLine{Type: "add", Content: line[1:], NewLine: newLine})
+			newLine++
+			file.Additions++
+		case strings.HasPrefix(line, "-"):
+			hunk.Lines = append(hunk.Lines, DiffLine{Type: "delete", Content: line[1:], OldLine: oldLine})
+			oldLine++
+			file.Deletions++
+		case strings.HasPrefix(line, "\\"):
+			if n := len(hunk.Lines); n > 0 {
+				hunk.Lines[n-1].NoNewline = true
+			}
+		default:
+			content := strings.TrimPrefix(line, " ")
+			hunk.Lines = append(hunk.Lines, DiffLine{Type: "context", Content: content, OldLine: oldLine, NewLine: newLine})
+			oldLine++
+			newLine++
+		}
+	}
+
+	for i := range files {
+		for j := range files[i].Hunks {
+			addWordSegments(files[i].Hunks[j].Lines)
+		}
+	}
+	return files
+}
+
+func isHunkBodyLine(line string) bool {
+	return line == "" || line[0] == ' ' || line[0] == '+' || line[0] == '-' || line[0] == '\\'
+}
+
+// splitDiffGitLine extracts paths from "a/<old> b/<new>". It is only a fallback
+// (binary files, mode changes); ---/+++ and rename headers take precedence.
+func splitDiffGitLine(rest string) (string, string) {
+	if strings.HasPrefix(rest, "\"") {
+		// Quoted paths: "a/x y" "b/x y"
+		if end := strings.Index(rest[1:], "\" "); end >= 0 {
+			oldPath := unquoteDiffPath(rest[:end+2])
+			newPath := unquoteDiffPath(rest[end+3:])
+			return strings.TrimPrefix(oldPath, "a/"), strings.TrimPrefix(newPath, "b/")
+		}
+	}
+	// Unquoted paths are ambiguous when they contain spaces; assume both sides are equal
+	if len(rest)%2 == 1 {
+		half := (len(rest) - 1) / 2
+		if rest[half] == ' ' && strings.TrimPrefix(rest[:half], "a/") == strings.TrimPrefix(rest[half+1:], "b/") {
+			path := strings.TrimPrefix(rest[:half], "a/")
+			return path, path
+		}
+	}
+	if i := strings.Index(rest, " b/"); i >= 0 {
+		return strings.TrimPrefix(rest[:i], "a/"), rest[i+3:]
+	}
+	return rest, rest
+}
+
+// unquoteDiffPath undoes git's C-style quoting of unusual path names
+func unquoteDiffPath(path string) string {
+	path = strings.TrimSuffix(path, "\t")
+	if strings.HasPrefix(path, "\"") {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return unquoted
+		}
+	}
+	return path
+}
+
+func atoiDefault(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// addWordSegments pairs runs of deleted lines with the added lines that follow them
+// and marks the words that differ within each pair
+func addWordSegments(lines []DiffLine) {
+	for i := 0; i < len(lines); {
+		if lines[i].Type != "delete" {
+			i++
+			continue
+		}
+		delStart := i
+		for i < len(lines) && lines[i].Type == "delete" {
+			i++
+		}
+		addStart := i
+		for i < len(lines) && lines[i].Type == "add" {
+			i++
+		}
+		pairs := addStart - delStart
+		if adds := i - addStart; adds < pairs {
+			pairs = adds
+		}
+		for k := 0; k < pairs; k++ {
+			oldSegs, newSegs := wordDiff(lines[delStart+k].Content, lines[addStart+k].Content)
+			lines[delStart+k].Segments = oldSegs
+			lines[addStart+k].Segments = newSegs
+		}
+	}
+}
+
+var wordPattern = regexp.MustCompile(`\w+|\s+|[^\w\s]`)
+
+// maxWordDiffTokens bounds the quadratic LCS; longer lines are marked changed as a whole
+const maxWordDiffTokens = 400
+
+// wordDiff computes the longest common token subsequence of two lines and
+// returns both lines split into changed and unchanged segments
+func wordDiff(oldText, newText string) ([]DiffSegment, []DiffSegment) {
+	a := wordPattern.FindAllString(oldText, -1)
+	b := wordPattern.FindAllString(newText, -1)
+	if len(a) > maxWordDiffTokens || len(b) > maxWordDiffTokens {
+		return []DiffSegment{{Text: oldText, Changed: true}}, []DiffSegment{{Text: newText, Changed: true}}
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var oldSegs, newSegs []DiffSegment
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			oldSegs = appendSegment(oldSegs, a[i], false)
+			newSegs = appendSegment(newSegs, b[j], false)
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			newSegs = appendSegment(newSegs, b[j], true)
+			j++
+		default:
+			oldSegs = appendSegment(oldSegs, a[i], true)
+			i++
+		}
+	}
+	return oldSegs, newSegs
+}
+
+func appendSegment(segs []DiffSegment, text string, changed bool) []DiffSegment {
+	if n := len(segs); n > 0 && segs[n-1].Changed == changed {
+		segs[n-1].Text += text
+		return segs
+	}
+	return append(segs, DiffSegment{Text: text, Changed: changed})
+}
+
+// splitRows lays a hunk out side by side: context lines span both columns and
+// runs of deletions are placed next to the additions that replace them
+func splitRows(lines []DiffLine) []SplitRow {
+	var rows []SplitRow
+	for i := 0; i < len(lines); {
+		if lines[i].Type == "context" {
+			rows = append(rows, SplitRow{Left: i, Right: i})
+			i++
+			continue
+		}
+		var dels, adds []int
+		for i < len(lines) && lines[i].Type == "delete" {
+			dels = append(dels, i)
+			i++
+		}
+		for i < len(lines) && lines[i].Type == "add" {
+			adds = append(adds, i)
+			i++
+		}
+		for k := 0; k < len(dels) || k < len(adds); k++ {
+			row := SplitRow{Left: -1, Right: -1}
+			if k < len(dels) {
+				row.Left = dels[k]
+			}
+			if k < len(adds) {
+				row.Right = adds[k]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	args, err := diffArgs(repo, query.Get("mode"), query.Get("commit"), query.Get("from"), query.Get("to"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	context := 3
+	if contextStr := query.Get("context"); contextStr != "" {
+		context, err = strconv.Atoi(contextStr)
+		if err != nil || context < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Invalid context",
+			})
+			return
+		}
+	}
+
+	files, err := getDiff(repo, args, query["path"], context, query.Get("ignoreWhitespace") == "true")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to get diff: %v", err),
+		})
+		return
+	}
+
+	if query.Get("view") == "split" {
+		for i := range files {
+			for j := range files[i].Hunks {
+				files[i].Hunks[j].Rows = splitRows(files[i].Hunks[j].Lines)
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": files,
+	})
+}
+
+// buildHunkPatch renders a single hunk of file as a patch. When selected is non-empty
+// only those line indices are kept as changes; the rest are neutralised so the patch
+// still applies. reverse must match the direction the patch will be applied in.
+func buildHunkPatch(file DiffFile, hunk DiffHunk, selected map[int]bool, reverse bool) string {
+	var b strings.Builder
+	for _, line := range file.header {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines)
+
+	for i, line := range hunk.Lines {
+		prefix := " "
+		switch line.Type {
+		case "add":
+			prefix = "+"
+			if len(selected) > 0 && !selected[i] {
+				if !reverse {
+					continue // not staged: the line does not exist on the old side
+				}
+				prefix = " "
+			}
+		case "delete":
+			prefix = "-"
+			if len(selected) > 0 && !selected[i] {
+				if reverse {
+					continue
+				}
+				prefix = " "
+			}
+		}
+		b.WriteString(prefix)
+		b.WriteString(line.Content)
+		b.WriteString("\n")
+		if line.NoNewline {
+			b.WriteString("\\ No newline at end of file\n")
+		}
+	}
+	return b.String()
+}
+
+// handleApplyHunk stages, unstages or reverts one hunk (or selected lines of it)
+func handleApplyHunk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path   string `json:"path"`
+		Action string `json:"action"` // stage, unstage, revert
+		Hunk   int    `json:"hunk"`
+		Header string `json:"header"` // optional: the hunk header the client saw
+		Lines  []int  `json:"lines"`  // optional: line indices within the hunk
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if req.Path == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Path is required",
+		})
+		return
+	}
+
+	// stage and revert work on the unstaged diff, unstage on the staged one
+	var mode string
+	var applyArgs []string
+	switch req.Action {
+	case "stage":
+		mode, applyArgs = "worktree", []string{"apply", "--cached"}
+	case "unstage":
+		mode, applyArgs = "staged", []string{"apply", "--cached", "-R"}
+	case "revert":
+		mode, applyArgs = "worktree", []string{"apply", "-R"}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Action must be stage, unstage or revert",
+		})
+		return
+	}
+	reverse := req.Action != "stage"
+
+	args, _ := diffArgs(repo, mode, "", "", "")
+	files, err := getDiff(repo, args, []string{req.Path}, 3, false)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to get diff: %v", err),
+		})
+		return
+	}
+
+	var file *DiffFile
+	for i := range files {
+		if files[i].NewPath == req.Path || files[i].OldPath == req.Path {
+			file = &files[i]
+			break
+		}
+	}
+	if file == nil || req.Hunk < 0 || req.Hunk >= len(file.Hunks) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Hunk not found",
+		})
+		return
+	}
+	if file.Binary {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Binary files cannot be applied by hunk",
+		})
+		return
+	}
+
+	hunk := file.Hunks[req.Hunk]
+	if req.Header != "" && req.Header != hunk.Header {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: "The file changed since the diff was loaded; reload and try again",
+		})
+		return
+	}
+
+	selected := make(map[int]bool)
+	for _, idx := range req.Lines {
+		if idx < 0 || idx >= len(hunk.Lines) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Line index %d is out of range", idx),
+			})
+			return
+		}
+		selected[idx] = true
+	}
+
+	patch := buildHunkPatch(*file, hunk, selected, reverse)
+
+	patchFile, err := os.CreateTemp("", "airgit-hunk-*.patch")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to write patch: %v", err),
+		})
+		return
+	}
+	defer os.Remove(patchFile.Name())
+	_, err = patchFile.WriteString(patch)
+	patchFile.Close()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to write patch: %v", err),
+		})
+		return
+	}
+
+	// --recount lets git fix the hunk line counts after lines were left out
+	applyArgs = append(applyArgs, "--recount", "--whitespace=nowarn", patchFile.Name())
+	logs, _, err := runLoggedGit(repo, nil, applyArgs...)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to apply hunk: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	done := map[string]string{"stage": "staged", "unstage": "unstaged", "revert": "reverted"}
+	logs = append(logs, fmt.Sprintf("✓ Hunk %s!", done[req.Action]))
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsValidRef(t *testing.T) {
+	tests := map[string]bool{
+		"origin":                     true,
+		"upstream/fork":              true,
+		"main":                       true,
+		"v1.0":                       true,
+		"HEAD~2":                     true,
+		"":                           false,
+		"-":                          false,
+		"--upload-pack=touch /tmp/x": false,
+		"-oProxyCommand":             false,
+		"main..evil":                 false,
+		"a b":                        false,
+	}
+	for ref, want := range tests {
+		if got := isValidRef(ref); got != want {
+			t.Errorf("isValidRef(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   DiffFile
+		lines  []DiffLine // lines of the first hunk, without segments
+	}{
+		{
+			name: "modified",
+			output: "diff --git a/main.go b/main.go\n" +
+				"index 1111111..2222222 100644\n" +
+				"--- a/main.go\n" +
+				"+++ b/main.go\n" +
+				"@@ -10,3 +10,3 @@ func main() {\n" +
+				" a\n" +
+				"-b\n" +
+				"+B\n" +
+				" c\n",
+			want: DiffFile{OldPath: "main.go", NewPath: "main.go", Status: "modified", Additions: 1, Deletions: 1},
+			lines: []DiffLine{
+				{Type: "context", Content: "a", OldLine: 10, NewLine: 10},
+				{Type: "delete", Content: "b", OldLine: 11},
+				{Type: "add", Content: "B", NewLine: 11},
+				{Type: "context", Content: "c", OldLine: 12, NewLine: 12},
+			},
+		},
+		{
+			name: "rename with changes",
+			output: "diff --git a/old.txt b/new.txt\n" +
+				"similarity index 80%\n" +
+				"rename from old.txt\n" +
+				"rename to new.txt\n" +
+				"index 1111111..2222222 100644\n" +
+				"--- a/old.txt\n" +
+				"+++ b/new.txt\n" +
+				"@@ -1 +1 @@\n" +
+				"-x\n" +
+				"+y\n",
+			want: DiffFile{OldPath: "old.txt", NewPath: "new.txt", Status: "renamed", Similarity: 80, Additions: 1, Deletions: 1},
+			lines: []DiffLine{
+				{Type: "delete", Content: "x", OldLine: 1},
+				{Type: "add", Content: "y", NewLine: 1},
+			},
+		},
+		{
+			name: "pure rename with spaces",
+			output: "diff --git a/docs/old name.md b/docs/new name.md\n" +
+				"similarity index 100%\n" +
+				"rename from docs/old name.md\n" +
+				"rename to docs/new name.md\n",
+			want: DiffFile{OldPath: "docs/old name.md", NewPath: "docs/new name.md", Status: "renamed", Similarity: 100},
+		},
+		{
+			name: "binary",
+			output: "diff --git a/logo.png b/logo.png\n" +
+				"index 1111111..2222222 100644\n" +
+				"Binary files a/logo.png and b/logo.png differ\n",
+			want: DiffFile{OldPath: "logo.png", NewPath: "logo.png", Status: "modified", Binary: true},
+		},
+		{
+			name: "added binary",
+			output: "diff --git a/data.bin b/data.bin\n" +
+				"new file mode 100644\n" +
+				"index 0000000..2222222\n" +
+				"Binary files /dev/null and b/data.bin differ\n",
+			want: DiffFile{OldPath: "data.bin", NewPath: "data.bin", Status: "added", NewMode: "100644", Binary: true},
+		},
+		{
+			name: "no newline at end of file",
+			output: "diff --git a/a.txt b/a.txt\n" +
+				"index 1111111..2222222 100644\n" +
+				"--- a/a.txt\n" +
+				"+++ b/a.txt\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" one\n" +
+				"-two\n" +
+				"\\ No newline at end of file\n" +
+				"+two\n",
+			want: DiffFile{OldPath: "a.txt", NewPath: "a.txt", Status: "modified", Additions: 1, Deletions: 1},
+			lines: []DiffLine{
+				{Type: "context", Content: "one", OldLine: 1, NewLine: 1},
+				{Type: "delete", Content: "two", OldLine: 2, NoNewline: true},
+				{Type: "add", Content: "two", NewLine: 2},
+			},
+		},
+		{
+			name: "deleted",
+			output: "diff --git a/gone.txt b/gone.txt\n" +
+				"deleted file mode 100755\n" +
+				"index 1111111..0000000\n" +
+				"--- a/gone.txt\n" +
+				"+++ /dev/null\n" +
+				"@@ -1 +0,0 @@\n" +
+				"-bye\n",
+			want: DiffFile{OldPath: "gone.txt", NewPath: "gone.txt", Status: "deleted", OldMode: "100755", Deletions: 1},
+			lines: []DiffLine{
+				{Type: "delete", Content: "bye", OldLine: 1},
+			},
+		},
+		{
+			name: "quoted path",
+			output: "diff --git \"a/caf\\303\\251.txt\" \"b/caf\\303\\251.txt\"\n" +
+				"old mode 100644\n" +
+				"new mode 100755\n",
+			want: DiffFile{OldPath: "café.txt", NewPath: "café.txt", Status: "modified", OldMode: "100644", NewMode: "100755"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := parseUnifiedDiff(tt.output)
+			if len(files) != 1 {
+				t.Fatalf("parseUnifiedDiff returned %d files, want 1", len(files))
+			}
+			got := files[0]
+			if len(tt.lines) == 0 && len(got.Hunks) != 0 {
+				t.Errorf("got %d hunks, want none", len(got.Hunks))
+			}
+			if len(tt.lines) > 0 {
+				if len(got.Hunks) != 1 {
+					t.Fatalf("got %d hunks, want 1", len(got.Hunks))
+				}
+				lines := got.Hunks[0].Lines
+				for i := range lines {
+					lines[i].Segments = nil
+				}
+				if !reflect.DeepEqual(lines, tt.lines) {
+					t.Errorf("lines = %+v, want %+v", lines, tt.lines)
+				}
+			}
+			got.Hunks, got.header = nil, nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("file = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnifiedDiffKeepsHeader(t *testing.T) {
+	output := "diff --git a/a.txt b/a.txt\n" +
+		"index 1111111..2222222 100644\n" +
+		"--- a/a.txt\n" +
+		"+++ b/a.txt\n" +
+		"@@ -1 +1 @@\n" +
+		"--- old\n" +
+		"+++ new\n" +
+		"diff --git a/b.txt b/b.txt\n" +
+		"index 3333333..4444444 100644\n" +
+		"--- a/b.txt\n" +
+		"+++ b/b.txt\n" +
+		"@@ -1 +1 @@\n" +
+		"-b\n" +
+		"+B\n"
+
+	files := parseUnifiedDiff(output)
+	if len(files) != 2 {
+		t.Fatalf("parseUnifiedDiff returned %d files, want 2", len(files))
+	}
+	// Removed and added lines that look like file headers stay hunk lines
+	if lines := files[0].Hunks[0].Lines; len(lines) != 2 || lines[0].Content != "-- old" || lines[1].Content != "++ new" {
+		t.Errorf("first file lines = %+v", lines)
+	}
+	if len(files[0].header) != 4 || files[0].NewPath != "a.txt" {
+		t.Errorf("first file header = %q, path %q", files[0].header, files[0].NewPath)
+	}
+	if files[1].NewPath != "b.txt" || files[1].Additions != 1 || files[1].Deletions != 1 {
+		t.Errorf("second file = %+v", files[1])
+	}
+}
+
+func TestWordDiff(t *testing.T) {
+	tests := []struct {
+		old, new         string
+		wantOld, wantNew []DiffSegment
+	}{
+		{
+			old:     "return a + b",
+			new:     "return a - b",
+			wantOld: []DiffSegment{{Text: "return a ", Changed: false}, {Text: "+", Changed: true}, {Text: " b", Changed: false}},
+			wantNew: []DiffSegment{{Text: "return a ", Changed: false}, {Text: "-", Changed: true}, {Text: " b", Changed: false}},
+		},
+		{
+			old:     "same",
+			new:     "same",
+			wantOld: []DiffSegment{{Text: "same", Changed: false}},
+			wantNew: []DiffSegment{{Text: "same", Changed: false}},
+		},
+		{
+			old:     "",
+			new:     "added",
+			wantOld: nil,
+			wantNew: []DiffSegment{{Text: "added", Changed: true}},
+		},
+		{
+			old:     strings.Repeat("a ", maxWordDiffTokens),
+			new:     "b",
+			wantOld: []DiffSegment{{Text: strings.Repeat("a ", maxWordDiffTokens), Changed: true}},
+			wantNew: []DiffSegment{{Text: "b", Changed: true}},
+		},
+	}
+	for _, tt := range tests {
+		gotOld, gotNew := wordDiff(tt.old, tt.new)
+		if !reflect.DeepEqual(gotOld, tt.wantOld) || !reflect.DeepEqual(gotNew, tt.wantNew) {
+			t.Errorf("wordDiff(%.20q, %.20q) = %+v, %+v; want %+v, %+v", tt.old, tt.new, gotOld, gotNew, tt.wantOld, tt.wantNew)
+		}
+	}
+}
+
+func TestSplitRows(t *testing.T) {
+	lines := []DiffLine{
+		{Type: "context"},
+		{Type: "delete"},
+		{Type: "delete"},
+		{Type: "add"},
+		{Type: "context"},
+		{Type: "add"},
+		{Type: "add"},
+	}
+	want := []SplitRow{
+		{Left: 0, Right: 0},
+		{Left: 1, Right: 3},
+		{Left: 2, Right: -1},
+		{Left: 4, Right: 4},
+		{Left: -1, Right: 5},
+		{Left: -1, Right: 6},
+	}
+	if got := splitRows(lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitRows = %+v, want %+v", got, want)
+	}
+}
+
+func TestCommitDiffRootCommit(t *testing.T) {
+	repoPath := filepath.Join(t.TempDir(), "repo")
+	if err := os.MkdirAll(repoPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "init", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(repoPath, "a.txt"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "add", "a.txt")
+	runGit(t, repoPath, "commit", "-q", "-m", "root")
+
+	repo := Repo{Path: repoPath}
+	args, err := diffArgs(repo, "commit", "", "", "")
+	if err == nil {
+		t.Fatalf("diffArgs without a commit = %v, want an error", args)
+	}
+	args, err = diffArgs(repo, "commit", "HEAD", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := getDiff(repo, args, nil, 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("root commit diff has %d files, want 1: %+v", len(files), files)
+	}
+	file := files[0]
+	if file.Status != "added" || file.NewPath != "a.txt" || file.Additions != 2 || len(file.Hunks) != 1 {
+		t.Errorf("root commit file = %+v", file)
+	}
+}
+
+func TestHandleApplyHunkLines(t *testing.T) {
+	// The hunk for "a b c" -> "a B c d" is: context a, delete b, add B, context c, add d
+	const original, modified = "a\nb\nc\n", "a\nB\nc\nd\n"
+
+	tests := []struct {
+		action       string
+		lines        string
+		stageFirst   bool // stage the whole change before applying
+		wantIndex    string
+		wantWorktree string
+	}{
+		{action: "stage", lines: "[4]", wantIndex: "a\nb\nc\nd\n", wantWorktree: modified},
+		{action: "stage", lines: "[1,2]", wantIndex: "a\nB\nc\n", wantWorktree: modified},
+		{action: "stage", lines: "[2]", wantIndex: "a\nb\nB\nc\n", wantWorktree: modified},
+		{action: "unstage", lines: "[4]", stageFirst: true, wantIndex: "a\nB\nc\n", wantWorktree: modified},
+		{action: "unstage", lines: "[1,2]", stageFirst: true, wantIndex: "a\nb\nc\nd\n", wantWorktree: modified},
+		{action: "revert", lines: "[4]", wantIndex: original, wantWorktree: "a\nB\nc\n"},
+		{action: "revert", lines: "[1]", wantIndex: original, wantWorktree: "a\nb\nB\nc\nd\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action+tt.lines, func(t *testing.T) {
+			base := useTestBase(t)
+			repoPath := filepath.Join(base, "web")
+			initTestRepo(t, repoPath)
+			file := filepath.Join(repoPath, "file.txt")
+			if err := os.WriteFile(file, []byte(original), 0644); err != nil {
+				t.Fatal(err)
+			}
+			runGit(t, repoPath, "add", "file.txt")
+			runGit(t, repoPath, "commit", "-q", "-m", "base")
+			if err := os.WriteFile(file, []byte(modified), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.stageFirst {
+				runGit(t, repoPath, "add", "file.txt")
+			}
+
+			body := `{"path":"file.txt","action":"` + tt.action + `","hunk":0,"lines":` + tt.lines + `}`
+			r := httptest.NewRequest("POST", "/api/diff/apply?repoPath=web", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handleApplyHunk(w, r)
+			if w.Code != 200 {
+				t.Fatalf("apply returned %d: %s", w.Code, w.Body.String())
+			}
+
+			index, err := executeRawGitCommand(Repo{Path: repoPath}, "show", ":file.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index != tt.wantIndex {
+				t.Errorf("index = %q, want %q", index, tt.wantIndex)
+			}
+			worktree, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(worktree) != tt.wantWorktree {
+				t.Errorf("worktree = %q, want %q", worktree, tt.wantWorktree)
+			}
+		})
+	}
+}
+
+func TestHandleApplyHunkNoNewline(t *testing.T) {
+	base := useTestBase(t)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	file := filepath.Join(repoPath, "file.txt")
+	if err := os.WriteFile(file, []byte("one\ntwo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "add", "file.txt")
+	runGit(t, repoPath, "commit", "-q", "-m", "base")
+	if err := os.WriteFile(file, []byte("one\ntwo\nthree"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/diff/apply?repoPath=web", strings.NewReader(`{"path":"file.txt","action":"stage","hunk":0}`))
+	w := httptest.NewRecorder()
+	handleApplyHunk(w, r)
+	if w.Code != 200 {
+		t.Fatalf("apply returned %d: %s", w.Code, w.Body.String())
+	}
+	if index, _ := executeRawGitCommand(Repo{Path: repoPath}, "show", ":file.txt"); index != "one\ntwo\nthree" {
+		t.Errorf("index = %q, want the whole file without a final newline", index)
+	}
+}
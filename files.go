@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxBlobSize is the largest file /api/blob returns inline; larger files must use /api/raw
+const maxBlobSize = 1 << 20
+
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // file, dir, symlink, submodule
+	Mode string `json:"mode"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size,omitempty"`
+}
+
+type BlobInfo struct {
+	Path        string `json:"path"`
+	Ref         string `json:"ref"`
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size"`
+	Binary      bool   `json:"binary"`
+	Truncated   bool   `json:"truncated"` // content omitted because the file exceeds maxBlobSize
+	ContentType string `json:"contentType"`
+	Language    string `json:"language,omitempty"`
+	Encoding    string `json:"encoding,omitempty"` // utf-8 or base64
+	Content     string `json:"content,omitempty"`
+}
+
+// resolveRepoFilePath validates a path inside repo the same way resolveAndValidateRepoPath
+// validates repositories against the base path. It returns the path relative to the
+// repository root with forward slashes ("" for the root itself).
+func resolveRepoFilePath(repo Repo, filePath string) (string, bool) {
+	if strings.ContainsRune(filePath, 0) {
+		return "", false
+	}
+
+	// Leading slashes are relative to the repository root
+	resolved, err := filepath.Abs(filepath.Join(repo.Path, filepath.FromSlash(filePath)))
+	if err != nil || !isWithinBase(resolved, repo.Path) {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(repo.Path, resolved)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		return "", true
+	}
+	// No git directory may be reached, nested repositories' and case variants
+	// included: their config and hooks run code whenever git runs there
+	for _, component := range strings.Split(rel, "/") {
+		if strings.EqualFold(component, ".git") {
+			return "", false
+		}
+	}
+	return rel, true
+}
+
+// fileRequest reads the ref and path query parameters shared by the file endpoints,
+// writing a 400 response if either is invalid
+func fileRequest(w http.ResponseWriter, r *http.Request, repo Repo) (ref, filePath string, ok bool) {
+	ref = r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if !isValidRef(ref) || strings.Contains(ref, ":") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid ref",
+		})
+		return "", "", false
+	}
+
+	filePath, ok = resolveRepoFilePath(repo, r.URL.Query().Get("path"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid path",
+		})
+		return "", "", false
+	}
+	return ref, filePath, true
+}
+
+// resolveBlob returns the hash and size of the blob at ref:filePath
+func resolveBlob(repo Repo, ref, filePath string) (string, int64, error) {
+	hash, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", ref+":"+filePath)
+	if err != nil {
+		return "", 0, fmt.Errorf("file not found")
+	}
+	if objType, _ := executeGitCommand(repo, "cat-file", "-t", hash); objType != "blob" {
+		return "", 0, fmt.Errorf("not a file")
+	}
+	sizeStr, err := executeGitCommand(repo, "cat-file", "-s", hash)
+	if err != nil {
+		return "", 0, err
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	return hash, size, err
+}
+
+// parseLsTree parses `git ls-tree -z -l` output; dir is prefixed to each name
+func parseLsTree(output, dir string) []TreeEntry {
+	entries := []TreeEntry{}
+	for _, record := range strings.Split(output, "\x00") {
+		meta, name, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) < 4 {
+			continue
+		}
+
+		entry := TreeEntry{
+			Name: name,
+			Path: path.Join(dir, name),
+			Mode: fields[0],
+			Hash: fields[2],
+		}
+		switch {
+		case fields[1] == "tree":
+			entry.Type = "dir"
+		case fields[1] == "commit":
+			entry.Type = "submodule"
+		case fields[0] == "120000":
+			entry.Type = "symlink"
+		default:
+			entry.Type = "file"
+		}
+		entry.Size, _ = strconv.ParseInt(fields[3], 10, 64)
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if (entries[i].Type == "dir") != (entries[j].Type == "dir") {
+			return entries[i].Type == "dir"
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+func handleTree(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+	ref, dir, ok := fileRequest(w, r, repo)
+	if !ok {
+		return
+	}
+
+	treeish := ref + "^{tree}"
+	if dir != "" {
+		treeish = ref + ":" + dir
+	}
+	if objType, _ := executeGitCommand(repo, "cat-file", "-t", treeish); objType != "tree" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Directory not found",
+		})
+		return
+	}
+
+	output, err := executeRawGitCommand(repo, "ls-tree", "-z", "-l", treeish)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list directory: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ref":     ref,
+		"path":    dir,
+		"entries": parseLsTree(output, dir),
+	})
+}
+
+func handleBlob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+	ref, filePath, ok := fileRequest(w, r, repo)
+	if !ok {
+		return
+	}
+
+	hash, size, err := resolveBlob(repo, ref, filePath)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read %s: %v", filePath, err),
+		})
+		return
+	}
+
+	blob := BlobInfo{
+		Path:     filePath,
+		Ref:      ref,
+		Hash:     hash,
+		Size:     size,
+		Language: languageForPath(filePath),
+	}
+
+	// Read a prefix for detection even when the content itself is not returned
+	readSize := size
+	if readSize > maxBlobSize {
+		readSize = 8000
+		blob.Truncated = true
+	}
+	content, err := readBlob(repo, hash, readSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read %s: %v", filePath, err),
+		})
+		return
+	}
+
+	blob.Binary = isBinaryContent(content)
+	blob.ContentType = detectContentType(filePath, content, blob.Binary)
+	if blob.Binary {
+		blob.Language = ""
+	}
+	if !blob.Truncated {
+		if blob.Binary {
+			blob.Encoding = "base64"
+			blob.Content = base64.StdEncoding.EncodeToString(content)
+		} else {
+			blob.Encoding = "utf-8"
+			blob.Content = string(content)
+		}
+	}
+
+	json.NewEncoder(w).Encode(blob)
+}
+
+// readBlob reads up to limit bytes of a blob
+func readBlob(repo Repo, hash string, limit int64) ([]byte, error) {
+	cmd := exec.Command("git", "cat-file", "blob", hash)
+	cmd.Dir = repo.Path
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	content, readErr := io.ReadAll(io.LimitReader(stdout, limit))
+	// Stop git early if we only wanted a prefix
+	cmd.Process.Kill()
+	cmd.Wait()
+	return content, readErr
+}
+
+// isBinaryContent uses git's heuristic: a NUL byte in the first 8000 bytes.
+// Text that is not valid UTF-8 is treated as binary too, since it cannot be sent as JSON text.
+func isBinaryContent(content []byte) bool {
+	prefix := content
+	if len(prefix) > 8000 {
+		prefix = prefix[:8000]
+	}
+	if bytes.IndexByte(prefix, 0) >= 0 {
+		return true
+	}
+	// Content read as a prefix may end in the middle of a character
+	for n := len(content); n >= 0 && n > len(content)-utf8.UTFMax; n-- {
+		if utf8.Valid(content[:n]) {
+			return false
+		}
+	}
+	return true
+}
+
+func detectContentType(filePath string, content []byte, binary bool) string {
+	if contentType := mime.TypeByExtension(path.Ext(filePath)); contentType != "" {
+		return contentType
+	}
+	if !binary {
+		return "text/plain; charset=utf-8"
+	}
+	return http.DetectContentType(content)
+}
+
+// languageExtensions maps file extensions to the language hint used for syntax highlighting
+var languageExtensions = map[string]string{
+	".go": "go", ".js": "javascript", ".mjs": "javascript", ".cjs": "javascript", ".jsx": "jsx",
+	".ts": "typescript", ".tsx": "tsx", ".py": "python", ".rb": "ruby", ".rs": "rust",
+	".java": "java", ".kt": "kotlin", ".swift": "swift", ".c": "c", ".h": "c",
+	".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp", ".cs": "csharp", ".php": "php",
+	".pl": "perl", ".pm": "perl", ".lua": "lua", ".sh": "bash", ".bash": "bash", ".zsh": "bash",
+	".ps1": "powershell", ".sql": "sql", ".html": "html", ".htm": "html", ".css": "css",
+	".scss": "scss", ".vue": "vue", ".svelte": "svelte", ".json": "json", ".yaml": "yaml",
+	".yml": "yaml", ".toml": "toml", ".xml": "xml", ".svg": "xml", ".md": "markdown",
+	".ini": "ini", ".proto": "protobuf", ".tf": "hcl", ".dart": "dart", ".ex": "elixir",
+	".exs": "elixir", ".erl": "erlang", ".hs": "haskell", ".scala": "scala", ".r": "r",
+}
+
+var languageFilenames = map[string]string{
+	"Dockerfile": "dockerfile", "Makefile": "makefile", "GNUmakefile": "makefile",
+	"go.mod": "go-module", "go.sum": "text", "CMakeLists.txt": "cmake",
+	"Gemfile": "ruby", "Rakefile": "ruby", "Jenkinsfile": "groovy",
+}
+
+func languageForPath(filePath string) string {
+	base := path.Base(filePath)
+	if lang, ok := languageFilenames[base]; ok {
+		return lang
+	}
+	return languageExtensions[strings.ToLower(path.Ext(base))]
+}
+
+// safeRawContentTypes may be served with their own content type; anything else that
+// a browser could render as a document (HTML, SVG, XML) is downgraded to text/plain
+var safeRawContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"image/x-icon":    true,
+	"application/pdf": true,
+	"audio/mpeg":      true,
+	"audio/wave":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+}
+
+func handleRaw(w http.ResponseWriter, r *http.Request) {
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+	ref, filePath, ok := fileRequest(w, r, repo)
+	if !ok {
+		return
+	}
+
+	hash, size, err := resolveBlob(repo, ref, filePath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read %s: %v", filePath, err),
+		})
+		return
+	}
+
+	cmd := exec.Command("git", "cat-file", "blob", hash)
+	cmd.Dir = repo.Path
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read %s: %v", filePath, err),
+		})
+		return
+	}
+	defer cmd.Wait()
+
+	reader := bufio.NewReaderSize(stdout, 8000)
+	head, _ := reader.Peek(8000)
+	contentType := http.DetectContentType(head)
+	if mediaType, _, _ := mime.ParseMediaType(contentType); !safeRawContentTypes[mediaType] {
+		if isBinaryContent(head) {
+			contentType = "application/octet-stream"
+		} else {
+			contentType = "text/plain; charset=utf-8"
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	disposition := "inline"
+	if r.URL.Query().Get("download") == "true" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": path.Base(filePath)}))
+
+	io.Copy(w, reader)
+}
+
+// fileSaveMutex serialises the compare-and-write in handleSaveFile
+var fileSaveMutex sync.Mutex
+
+// resolveWritablePath returns the absolute path for writing rel inside repo. Unlike
+// resolveRepoFilePath it also resolves symlinks in the existing part of the path,
+// so a symlinked directory cannot redirect the write outside the repository.
+func resolveWritablePath(repo Repo, rel string) (string, error) {
+	abs := filepath.Join(repo.Path, filepath.FromSlash(rel))
+
+	existing := abs
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", fmt.Errorf("invalid path")
+		}
+		existing = parent
+	}
+	realExisting, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	realRepo, err := filepath.EvalSymlinks(repo.Path)
+	if err != nil {
+		return "", err
+	}
+	if !isWithinBase(realExisting, realRepo) {
+		return "", fmt.Errorf("path leaves the repository")
+	}
+
+	if info, err := os.Lstat(abs); err == nil {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("symlinks cannot be edited")
+		}
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("not a file")
+		}
+	}
+	return abs, nil
+}
+
+// writeFileAtomic replaces path via a temporary file in the same directory,
+// keeping the existing file's permissions
+func writeFileAtomic(path string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".airgit-save-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// handleSaveFile writes a text file in the working tree. baseHash is the blob hash
+// the client started editing from (empty for a new file); the write is rejected with
+// 409 if the file no longer has that hash. With commit set, only this file is committed.
+func handleSaveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path     string `json:"path"`
+		Content  string `json:"content"`
+		BaseHash string `json:"baseHash"`
+		Branch   string `json:"branch"` // optional: the branch the client expects to be checked out
+		Commit   bool   `json:"commit"`
+		Message  string `json:"message"`
+		Author   string `json:"author"`
+		SignOff  bool   `json:"signOff"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxBlobSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	filePath, ok := resolveRepoFilePath(repo, req.Path)
+	if !ok || filePath == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid path",
+		})
+		return
+	}
+	if len(req.Content) > maxBlobSize || isBinaryContent([]byte(req.Content)) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Only text files up to 1 MiB can be edited",
+		})
+		return
+	}
+	if req.Commit && strings.TrimSpace(req.Message) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Commit message is required",
+		})
+		return
+	}
+	if req.Author != "" && !authorPattern.MatchString(req.Author) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Author must be in the form \"Name <email>\"",
+		})
+		return
+	}
+
+	absPath, err := resolveWritablePath(repo, filePath)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Cannot write %s: %v", filePath, err),
+		})
+		return
+	}
+
+	fileSaveMutex.Lock()
+	defer fileSaveMutex.Unlock()
+
+	if req.Branch != "" {
+		if branch, _ := gitBackend.CurrentBranch(repo); branch != req.Branch {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("%s is checked out, not %s", branch, req.Branch),
+			})
+			return
+		}
+	}
+
+	currentHash := ""
+	if current, err := os.ReadFile(absPath); err == nil {
+		if isBinaryContent(current) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Only text files can be edited",
+			})
+			return
+		}
+		currentHash, err = executeGitCommand(repo, "hash-object", "--", filePath)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to hash %s: %v", filePath, err),
+			})
+			return
+		}
+	}
+	if currentHash != req.BaseHash {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":       fmt.Sprintf("%s changed since it was loaded; reload it and reapply your edits", filePath),
+			"currentHash": currentHash,
+		})
+		return
+	}
+
+	if err := writeFileAtomic(absPath, []byte(req.Content)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to write %s: %v", filePath, err),
+		})
+		return
+	}
+	newHash, _ := executeGitCommand(repo, "hash-object", "--", filePath)
+	logs := []string{fmt.Sprintf("✓ Saved %s", filePath)}
+
+	result := map[string]interface{}{
+		"path": filePath,
+		"hash": newHash,
+	}
+	if req.Commit {
+		// --only commits just this file, leaving anything else that is staged alone
+		logs, _, err = runLoggedGit(repo, logs, "add", "--", filePath)
+		if err == nil {
+			args := []string{"commit", "-m", req.Message, "--only"}
+			if req.Author != "" {
+				args = append(args, "--author="+req.Author)
+			}
+			if req.SignOff {
+				args = append(args, "--signoff")
+			}
+			args = append(args, "--", filePath)
+			logs, _, err = runLoggedGit(repo, logs, args...)
+		}
+		if err != nil {
+			// The file is saved either way; report the failed commit
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": fmt.Sprintf("Saved, but git commit failed: %v", err),
+				"path":  filePath,
+				"hash":  newHash,
+				"log":   logs,
+			})
+			return
+		}
+
+		hash, _ := executeGitCommand(repo, "rev-parse", "HEAD")
+		branch, _ := gitBackend.CurrentBranch(repo)
+		result["commit"] = hash
+		result["branch"] = branch
+		logs = append(logs, "✓ Commit created!")
+	}
+
+	result["log"] = logs
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveRepoFilePath(t *testing.T) {
+	repo := Repo{Path: "/srv/repos/web"}
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{"/", "", true},
+		{"src/app.go", "src/app.go", true},
+		{"/src/app.go", "src/app.go", true},
+		{"src/../README.md", "README.md", true},
+		{".github/workflows/ci.yml", ".github/workflows/ci.yml", true},
+		{"docs/.gitignore", "docs/.gitignore", true},
+		{"../other/file", "", false},
+		{"src/../../other", "", false},
+		{"a\x00b", "", false},
+		{".git", "", false},
+		{".git/config", "", false},
+		{".GIT/config", "", false},
+		{"sub/.git/config", "", false},
+		{"sub/.git/hooks/post-checkout", "", false},
+		{"vendor/lib/.Git", "", false},
+		{"sub/x/../.git/config", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := resolveRepoFilePath(repo, tt.path)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("resolveRepoFilePath(%q) = %q, %v; want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseLsTree(t *testing.T) {
+	output := "100644 blob aaa     12\tzeta.txt\x00" +
+		"040000 tree bbb      -\tsrc\x00" +
+		"160000 commit ccc      -\tlib\x00" +
+		"120000 blob ddd      7\tlink\x00"
+	entries := parseLsTree(output, "dir")
+
+	want := []TreeEntry{
+		{Name: "src", Path: "dir/src", Type: "dir", Mode: "040000", Hash: "bbb"},
+		{Name: "lib", Path: "dir/lib", Type: "submodule", Mode: "160000", Hash: "ccc"},
+		{Name: "link", Path: "dir/link", Type: "symlink", Mode: "120000", Hash: "ddd", Size: 7},
+		{Name: "zeta.txt", Path: "dir/zeta.txt", Type: "file", Mode: "100644", Hash: "aaa", Size: 12},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("parseLsTree returned %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestHandleBlob(t *testing.T) {
+	base := useTestBase(t)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	if err := os.WriteFile(filepath.Join(repoPath, "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repoPath, "logo.bin"), []byte{0x89, 0, 1, 2}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "add", ".")
+	runGit(t, repoPath, "commit", "-q", "-m", "files")
+
+	tests := []struct {
+		query    string
+		code     int
+		encoding string
+		content  string
+	}{
+		{"path=main.go", 200, "utf-8", "package main\n"},
+		{"path=logo.bin", 200, "base64", "iQABAg=="},
+		{"path=main.go&ref=HEAD~1", 404, "", ""},
+		{"path=missing.go", 404, "", ""},
+		{"path=../other/main.go", 400, "", ""},
+		{"path=.git/config", 400, "", ""},
+		{"path=main.go&ref=--output=x", 400, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/blob?repoPath=web&"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleBlob(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d (%s), want %d", tt.query, w.Code, w.Body.String(), tt.code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+		var blob BlobInfo
+		if err := json.NewDecoder(w.Body).Decode(&blob); err != nil {
+			t.Fatal(err)
+		}
+		if blob.Encoding != tt.encoding || blob.Content != tt.content {
+			t.Errorf("%s: got %s %q, want %s %q", tt.query, blob.Encoding, blob.Content, tt.encoding, tt.content)
+		}
+	}
+}
+
+func TestHandleSaveFile(t *testing.T) {
+	base := useTestBase(t)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	repo := Repo{Path: repoPath}
+	// A nested repository whose git directory must stay out of reach
+	initTestRepo(t, filepath.Join(repoPath, "sub"))
+
+	save := func(body string) (int, map[string]interface{}) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/api/file/save?repoPath=web", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handleSaveFile(w, r)
+		var response map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, response
+	}
+
+	for _, path := range []string{"../escape.txt", ".git/config", "sub/.git/config", "sub/.GIT/hooks/pre-commit", ""} {
+		if code, _ := save(`{"path":"` + path + `","content":"x"}`); code != 400 {
+			t.Errorf("saving %q returned %d, want 400", path, code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(base, "escape.txt")); err == nil {
+		t.Errorf("a file was written outside the repository")
+	}
+
+	// A new file has no base hash
+	code, response := save(`{"path":"notes/todo.txt","content":"one\n"}`)
+	if code != 200 {
+		t.Fatalf("creating a file returned %d: %v", code, response)
+	}
+	hash, _ := response["hash"].(string)
+	if content, _ := os.ReadFile(filepath.Join(repoPath, "notes", "todo.txt")); string(content) != "one\n" || hash == "" {
+		t.Fatalf("created %q with hash %q", content, hash)
+	}
+
+	// Someone else saves in between
+	if code, _ := save(`{"path":"notes/todo.txt","content":"two\n","baseHash":"` + hash + `"}`); code != 200 {
+		t.Fatalf("saving returned %d", code)
+	}
+	code, response = save(`{"path":"notes/todo.txt","content":"stale\n","baseHash":"` + hash + `"}`)
+	if code != 409 || response["currentHash"] == hash || response["currentHash"] == "" {
+		t.Errorf("saving with a stale base hash returned %d: %v, want 409 with the current hash", code, response)
+	}
+	if content, _ := os.ReadFile(filepath.Join(repoPath, "notes", "todo.txt")); string(content) != "two\n" {
+		t.Errorf("stale save overwrote the file with %q", content)
+	}
+
+	// Commit on save commits only the saved file
+	if err := os.WriteFile(filepath.Join(repoPath, "staged.txt"), []byte("staged\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "add", "staged.txt")
+	current, _ := executeGitCommand(repo, "hash-object", "--", "notes/todo.txt")
+	code, response = save(`{"path":"notes/todo.txt","content":"three\n","baseHash":"` + current + `","commit":true,"message":"Update todo"}`)
+	if code != 200 {
+		t.Fatalf("commit on save returned %d: %v", code, response)
+	}
+	if subject, _ := executeGitCommand(repo, "log", "-1", "--format=%s"); subject != "Update todo" {
+		t.Errorf("last commit is %q, want Update todo", subject)
+	}
+	if files, _ := executeGitCommand(repo, "show", "--name-only", "--format=", "HEAD"); files != "notes/todo.txt" {
+		t.Errorf("commit contains %q, want only notes/todo.txt", files)
+	}
+	if staged, _ := executeGitCommand(repo, "diff", "--cached", "--name-only"); staged != "staged.txt" {
+		t.Errorf("staged after commit = %q, want staged.txt left alone", staged)
+	}
+
+	if code, _ := save(`{"path":"notes/todo.txt","content":"four\n","baseHash":"x","commit":true}`); code != 400 {
+		t.Errorf("commit on save without a message returned %d, want 400", code)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitBackend performs the git operations behind AirGit's core handlers.
+// The exec backend shells out to the git binary and supports everything;
+// the native backend reads repositories in-process and is read-only.
+type GitBackend interface {
+	CurrentBranch(repo Repo) (string, error)
+	AheadBehind(repo Repo, branch string) (int, int)
+	Branches(repo Repo) ([]string, error)
+	Log(repo Repo, opts LogOptions) ([]CommitInfo, error)
+	Tags(repo Repo) ([]string, error)
+	Remotes(repo Repo) ([]RemoteInfo, error)
+	Worktrees(repo Repo) ([]WorktreeInfo, error)
+	Push(repo Repo, remote, branch string, opts PushOptions) (string, error)
+	Pull(repo Repo, remote, branch, mode string) (string, error)
+	Fetch(repo Repo, remote string, opts FetchOptions) (string, error)
+}
+
+type PushOptions struct {
+	// ForceWithLease overwrites the remote branch only if it still points at
+	// Expected; an empty Expected requires that the branch does not exist yet
+	ForceWithLease bool
+	Expected       string
+}
+
+type FetchOptions struct {
+	All   bool // fetch every remote instead of just one
+	Prune bool // remove remote-tracking refs that no longer exist on the remote
+	Tags  bool // fetch all tags, not only those reachable from fetched branches
+}
+
+// LogOptions selects and filters the commits returned by GitBackend.Log.
+// Commits are returned newest first in date order, children before parents.
+type LogOptions struct {
+	Limit        int
+	Skip         int
+	Rev          string // start from this revision instead of HEAD
+	All          bool   // start from all refs
+	Author       string // regular expression matched against "Name <email>"
+	Grep         string // regular expression matched against the message
+	Since        time.Time
+	Until        time.Time
+	Paths        []string
+	Pickaxe      string // -S: commits changing the number of occurrences of the string
+	PickaxeRegex string // -G: commits whose diff contains lines matching the regex
+	Follow       bool   // continue the history of a single path across renames
+}
+
+type WorktreeInfo struct {
+	Path     string `json:"path"`
+	Head     string `json:"head,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Bare     bool   `json:"bare,omitempty"`
+	Detached bool   `json:"detached,omitempty"`
+	Locked   bool   `json:"locked,omitempty"`
+	Prunable bool   `json:"prunable,omitempty"`
+
+	LockReason string `json:"lockReason,omitempty"`
+}
+
+// errReadOnlyBackend is returned by backends that cannot modify repositories
+var errReadOnlyBackend = errors.New("operation requires the exec git backend (start AirGit with --git-backend=exec)")
+
+var gitBackend GitBackend = execBackend{}
+
+// newGitBackend returns the backend registered under name
+func newGitBackend(name string) (GitBackend, error) {
+	switch name {
+	case "", "exec":
+		return execBackend{}, nil
+	case "native":
+		return nativeBackend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown git backend %q (expected \"exec\" or \"native\")", name)
+	}
+}
+
+// execBackend runs the git binary in the repository directory
+type execBackend struct{}
+
+func (execBackend) CurrentBranch(repo Repo) (string, error) {
+	branch, err := executeGitCommand(repo, "branch", "--show-current")
+	if err != nil || strings.TrimSpace(branch) == "" {
+		// Fallback: try alternative method (also covers detached HEAD)
+		branch, err = executeGitCommand(repo, "rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(branch), nil
+}
+
+func (execBackend) AheadBehind(repo Repo, branch string) (int, int) {
+	return getAheadBehind(repo, branch)
+}
+
+func (execBackend) Branches(repo Repo) ([]string, error) {
+	output, err := executeGitCommand(repo, "branch", "-a")
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	branchMap := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		line = strings.TrimPrefix(line, "* ")
+		line = strings.TrimPrefix(line, "+ ") // checked out in another worktree
+		line = strings.TrimPrefix(line, "remotes/")
+		if branchMap[line] {
+			continue
+		}
+		branchMap[line] = true
+		branches = append(branches, line)
+	}
+	return branches, nil
+}
+
+func (execBackend) Log(repo Repo, opts LogOptions) ([]CommitInfo, error) {
+	// --parents rewrites %P to the nearest shown ancestors when filtering by path
+	args := []string{"log", "--date-order", "--parents", "--format=" + commitLogFormat, "-n", strconv.Itoa(opts.Limit)}
+	if opts.Skip > 0 {
+		args = append(args, "--skip="+strconv.Itoa(opts.Skip))
+	}
+	if opts.Author != "" || opts.Grep != "" || opts.PickaxeRegex != "" {
+		args = append(args, "--extended-regexp")
+	}
+	if opts.Author != "" {
+		args = append(args, "--author="+opts.Author)
+	}
+	if opts.Grep != "" {
+		args = append(args, "--grep="+opts.Grep)
+	}
+	if !opts.Since.IsZero() {
+		args = append(args, "--since="+opts.Since.Format(time.RFC3339))
+	}
+	if !opts.Until.IsZero() {
+		args = append(args, "--until="+opts.Until.Format(time.RFC3339))
+	}
+	if opts.Pickaxe != "" {
+		args = append(args, "-S"+opts.Pickaxe)
+	}
+	if opts.PickaxeRegex != "" {
+		args = append(args, "-G"+opts.PickaxeRegex)
+	}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.All {
+		args = append(args, "--all")
+	} else if opts.Rev != "" {
+		args = append(args, opts.Rev)
+	}
+	args = append(args, "--")
+	args = append(args, opts.Paths...)
+
+	output, err := executeGitCommand(repo, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, output)
+	}
+	return parseCommits(output), nil
+}
+
+func (execBackend) Tags(repo Repo) ([]string, error) {
+	output, err := executeGitCommand(repo, "tag", "-l")
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			tags = append(tags, line)
+		}
+	}
+	return tags, nil
+}
+
+func (execBackend) Remotes(repo Repo) ([]RemoteInfo, error) {
+	output, err := executeGitCommand(repo, "remote", "-v")
+	if err != nil {
+		return nil, err
+	}
+
+	var remotes []RemoteInfo
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) < 2 || seen[parts[0]] {
+			continue
+		}
+		seen[parts[0]] = true
+		remotes = append(remotes, RemoteInfo{
+			Name: parts[0],
+			URL:  parts[1],
+		})
+	}
+	return remotes, nil
+}
+
+func (execBackend) Worktrees(repo Repo) ([]WorktreeInfo, error) {
+	output, err := executeGitCommand(repo, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	return parseWorktreeList(output), nil
+}
+
+func (execBackend) Push(repo Repo, remote, branch string, opts PushOptions) (string, error) {
+	return executeGitCommand(repo, pushArgs(remote, branch, opts)...)
+}
+
+func (execBackend) Pull(repo Repo, remote, branch, mode string) (string, error) {
+	return executeGitCommand(repo, pullArgs(remote, branch, mode)...)
+}
+
+func (execBackend) Fetch(repo Repo, remote string, opts FetchOptions) (string, error) {
+	return executeGitCommand(repo, fetchArgs(remote, opts)...)
+}
+
+func pushArgs(remote, branch string, opts PushOptions) []string {
+	args := []string{"push"}
+	if opts.ForceWithLease {
+		args = append(args, "--force-with-lease="+branch+":"+opts.Expected)
+	}
+	return append(args, remote, branch)
+}
+
+// pullArgs builds the git pull command for a pull mode (see RepoSettings)
+func pullArgs(remote, branch, mode string) []string {
+	args := []string{"pull"}
+	switch mode {
+	case "merge":
+		args = append(args, "--no-rebase")
+	case "rebase":
+		args = append(args, "--rebase")
+	case "ff-only":
+		args = append(args, "--ff-only")
+	}
+	return append(args, remote, branch)
+}
+
+func fetchArgs(remote string, opts FetchOptions) []string {
+	args := []string{"fetch"}
+	if opts.Prune {
+		args = append(args, "--prune")
+	}
+	if opts.Tags {
+		args = append(args, "--tags")
+	}
+	if opts.All {
+		return append(args, "--all")
+	}
+	return append(args, remote)
+}
+
+// parseWorktreeList parses the output of `git worktree list --porcelain`
+func parseWorktreeList(output string) []WorktreeInfo {
+	var worktrees []WorktreeInfo
+	var current *WorktreeInfo
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "worktree":
+			worktrees = append(worktrees, WorktreeInfo{Path: value})
+			current = &worktrees[len(worktrees)-1]
+		case "HEAD":
+			if current != nil {
+				current.Head = value
+			}
+		case "branch":
+			if current != nil {
+				current.Branch = strings.TrimPrefix(value, "refs/heads/")
+			}
+		case "bare":
+			if current != nil {
+				current.Bare = true
+			}
+		case "detached":
+			if current != nil {
+				current.Detached = true
+			}
+		case "locked":
+			if current != nil {
+				current.Locked = true
+				current.LockReason = value
+			}
+		case "prunable":
+			if current != nil {
+				current.Prunable = true
+			}
+		}
+	}
+	return worktrees
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// nativeBackend reads repositories in-process with go-git so AirGit can browse
+// repositories on hosts without a git binary. Mutating operations are not supported.
+type nativeBackend struct{}
+
+func openNativeRepo(repo Repo) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(repo.Path, &git.PlainOpenOptions{
+		EnableDotGitCommonDir: true, // worktrees keep refs and objects in the main repository
+	})
+}
+
+func (nativeBackend) CurrentBranch(repo Repo) (string, error) {
+	r, err := openNativeRepo(repo)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+	if head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		// Unborn branches have a symbolic HEAD but no target yet
+		return head.Target().Short(), nil
+	}
+	return "HEAD", nil
+}
+
+func (nativeBackend) AheadBehind(repo Repo, branch string) (int, int) {
+	r, err := openNativeRepo(repo)
+	if err != nil {
+		return 0, 0
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return 0, 0
+	}
+	branchCfg, ok := cfg.Branches[branch]
+	if !ok || branchCfg.Remote == "" || branchCfg.Merge == "" {
+		// No tracking branch configured
+		return 0, 0
+	}
+
+	local, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return 0, 0
+	}
+	upstreamName := plumbing.NewRemoteReferenceName(branchCfg.Remote, branchCfg.Merge.Short())
+	if branchCfg.Remote == "." {
+		upstreamName = branchCfg.Merge
+	}
+	upstream, err := r.Reference(upstreamName, true)
+	if err != nil {
+		return 0, 0
+	}
+
+	localSet, err := ancestorSet(r, local.Hash())
+	if err != nil {
+		return 0, 0
+	}
+	upstreamSet, err := ancestorSet(r, upstream.Hash())
+	if err != nil {
+		return 0, 0
+	}
+
+	var ahead, behind int
+	for hash := range localSet {
+		if !upstreamSet[hash] {
+			ahead++
+		}
+	}
+	for hash := range upstreamSet {
+		if !localSet[hash] {
+			behind++
+		}
+	}
+	return ahead, behind
+}
+
+// ancestorSet returns the hashes of from and all commits reachable from it
+func ancestorSet(r *git.Repository, from plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	commit, err := r.CommitObject(from)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(commit, nil, nil).ForEach(func(c *object.Commit) error {
+		set[c.Hash] = true
+		return nil
+	})
+	return set, err
+}
+
+func (nativeBackend) Branches(repo Repo) ([]string, error) {
+	r, err := openNativeRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	var local, remote []string
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		switch {
+		case ref.Name().IsBranch():
+			local = append(local, ref.Name().Short())
+		case ref.Name().IsRemote():
+			if ref.Type() == plumbing.SymbolicReference {
+				// Match `git branch -a` output for origin/HEAD
+				remote = append(remote, ref.Name().Short()+" -> "+ref.Target().Short())
+			} else {
+				remote = append(remote, ref.Name().Short())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(local)
+	sort.Strings(remote)
+	return append(local, remote...), nil
+}
+
+func (nativeBackend) Log(repo Repo, opts LogOptions) ([]CommitInfo, error) {
+	if opts.Pickaxe != "" || opts.PickaxeRegex != "" {
+		return nil, fmt.Errorf("content search: %w", errReadOnlyBackend)
+	}
+	if opts.Follow {
+		return nil, fmt.Errorf("following renames: %w", errReadOnlyBackend)
+	}
+
+	var author, grep *regexp.Regexp
+	var err error
+	if opts.Author != "" {
+		if author, err = regexp.Compile(opts.Author); err != nil {
+			return nil, fmt.Errorf("invalid author pattern: %v", err)
+		}
+	}
+	if opts.Grep != "" {
+		if grep, err = regexp.Compile(opts.Grep); err != nil {
+			return nil, fmt.Errorf("invalid grep pattern: %v", err)
+		}
+	}
+
+	r, err := openNativeRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	tips, err := nativeLogTips(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk newest-first by committer date, like git log --date-order
+	var queue []*object.Commit
+	seen := make(map[plumbing.Hash]bool)
+	push := func(c *object.Commit) {
+		if seen[c.Hash] {
+			return
+		}
+		seen[c.Hash] = true
+		i := sort.Search(len(queue), func(i int) bool {
+			return queue[i].Committer.When.Before(c.Committer.When)
+		})
+		queue = append(queue, nil)
+		copy(queue[i+1:], queue[i:])
+		queue[i] = c
+	}
+	for _, tip := range tips {
+		if c, err := r.CommitObject(tip); err == nil {
+			push(c)
+		}
+	}
+
+	var commits []CommitInfo
+	skipped := 0
+	for len(queue) > 0 && len(commits) < opts.Limit {
+		c := queue[0]
+		queue = queue[1:]
+		if !opts.Since.IsZero() && c.Committer.When.Before(opts.Since) {
+			// Everything left in the queue is older
+			break
+		}
+		err := c.Parents().ForEach(func(p *object.Commit) error {
+			push(p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !opts.Until.IsZero() && c.Committer.When.After(opts.Until) {
+			continue
+		}
+		if author != nil && !author.MatchString(c.Author.Name+" <"+c.Author.Email+">") {
+			continue
+		}
+		if grep != nil && !grep.MatchString(c.Message) {
+			continue
+		}
+		if len(opts.Paths) > 0 && !nativeCommitTouches(c, opts.Paths) {
+			continue
+		}
+		if skipped < opts.Skip {
+			skipped++
+			continue
+		}
+
+		// Like git's %s, the subject is the first paragraph joined into one line
+		subject, body, _ := strings.Cut(strings.TrimSpace(c.Message), "\n\n")
+		subject = strings.ReplaceAll(subject, "\n", " ")
+		parents := make([]string, 0, len(c.ParentHashes))
+		for _, p := range c.ParentHashes {
+			parents = append(parents, p.String())
+		}
+		commits = append(commits, CommitInfo{
+			Hash:    c.Hash.String(),
+			Parents: parents,
+			Author:  c.Author.Name,
+			Date:    c.Author.When.Format("2006-01-02 15:04:05 -0700"),
+			Message: strings.TrimSpace(subject),
+			Body:    strings.TrimSpace(body),
+		})
+	}
+	return commits, nil
+}
+
+// nativeLogTips resolves the commits a log walk starts from
+func nativeLogTips(r *git.Repository, opts LogOptions) ([]plumbing.Hash, error) {
+	if !opts.All {
+		rev := opts.Rev
+		if rev == "" {
+			rev = "HEAD"
+		}
+		hash, err := r.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, err
+		}
+		return []plumbing.Hash{*hash}, nil
+	}
+
+	refs, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+	var tips []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || ref.Name().IsNote() {
+			return nil
+		}
+		hash := ref.Hash()
+		// Peel annotated tags
+		if tag, err := r.TagObject(hash); err == nil {
+			if c, err := tag.Commit(); err == nil {
+				hash = c.Hash
+			}
+		}
+		tips = append(tips, hash)
+		return nil
+	})
+	if head, err := r.Head(); err == nil {
+		tips = append(tips, head.Hash())
+	}
+	return tips, err
+}
+
+// nativeCommitTouches reports whether c changes any of paths. Like git's default
+// history simplification, a merge only counts if it differs from every parent.
+func nativeCommitTouches(c *object.Commit, paths []string) bool {
+	tree, err := c.Tree()
+	if err != nil {
+		return false
+	}
+	if c.NumParents() == 0 {
+		return treeChangesPaths(nil, tree, paths)
+	}
+
+	touches := true
+	err = c.Parents().ForEach(func(parent *object.Commit) error {
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+		if !treeChangesPaths(parentTree, tree, paths) {
+			touches = false
+			return storer.ErrStop
+		}
+		return nil
+	})
+	return err == nil && touches
+}
+
+func treeChangesPaths(from, to *object.Tree, paths []string) bool {
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return false
+	}
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			for _, path := range paths {
+				path = strings.TrimSuffix(path, "/")
+				if name == path || strings.HasPrefix(name, path+"/") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (nativeBackend) Tags(repo Repo) ([]string, error) {
+	r, err := openNativeRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tags = append(tags, ref.Name().Short())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (nativeBackend) Remotes(repo Repo) ([]RemoteInfo, error) {
+	r, err := openNativeRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := r.Remotes()
+	if err != nil {
+		return nil, err
+	}
+
+	var remotes []RemoteInfo
+	for _, remote := range list {
+		cfg := remote.Config()
+		if len(cfg.URLs) == 0 {
+			continue
+		}
+		remotes = append(remotes, RemoteInfo{
+			Name: cfg.Name,
+			URL:  cfg.URLs[0],
+		})
+	}
+	return remotes, nil
+}
+
+// Worktrees reads linked worktrees from <common git dir>/worktrees/*, since go-git
+// does not expose them
+func (nativeBackend) Worktrees(repo Repo) ([]WorktreeInfo, error) {
+	mainPath := getMainRepoPath(repo.Path)
+	mainRepo := Repo{Path: mainPath}
+
+	mainInfo, err := nativeWorktreeHead(mainRepo, filepath.Join(mainPath, ".git"))
+	if err != nil {
+		return nil, err
+	}
+	mainInfo.Path = mainPath
+	worktrees := []WorktreeInfo{mainInfo}
+
+	adminDir := filepath.Join(mainPath, ".git", "worktrees")
+	entries, err := os.ReadDir(adminDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return worktrees, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(adminDir, entry.Name())
+		gitdirFile, err := os.ReadFile(filepath.Join(dir, "gitdir"))
+		if err != nil {
+			continue
+		}
+		path := filepath.Dir(strings.TrimSpace(string(gitdirFile)))
+
+		info, err := nativeWorktreeHead(mainRepo, dir)
+		if err != nil {
+			continue
+		}
+		info.Path = path
+		if reason, err := os.ReadFile(filepath.Join(dir, "locked")); err == nil {
+			info.Locked = true
+			info.LockReason = strings.TrimSpace(string(reason))
+		}
+		if _, err := os.Stat(path); err != nil {
+			info.Prunable = true
+		}
+		worktrees = append(worktrees, info)
+	}
+	return worktrees, nil
+}
+
+// nativeWorktreeHead resolves the HEAD file inside a worktree's git directory
+func nativeWorktreeHead(mainRepo Repo, gitDir string) (WorktreeInfo, error) {
+	var info WorktreeInfo
+
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return info, err
+	}
+	head := strings.TrimSpace(string(data))
+
+	if target, ok := strings.CutPrefix(head, "ref: "); ok {
+		info.Branch = strings.TrimPrefix(target, "refs/heads/")
+		r, err := openNativeRepo(mainRepo)
+		if err != nil {
+			return info, err
+		}
+		if ref, err := r.Reference(plumbing.ReferenceName(target), true); err == nil {
+			info.Head = ref.Hash().String()
+		}
+	} else {
+		info.Head = head
+		info.Detached = true
+	}
+	return info, nil
+}
+
+func (nativeBackend) Push(repo Repo, remote, branch string, opts PushOptions) (string, error) {
+	return "", errReadOnlyBackend
+}
+
+func (nativeBackend) Pull(repo Repo, remote, branch, mode string) (string, error) {
+	return "", errReadOnlyBackend
+}
+
+func (nativeBackend) Fetch(repo Repo, remote string, opts FetchOptions) (string, error) {
+	return "", errReadOnlyBackend
+}
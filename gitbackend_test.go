@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// fakeBackend answers the calls handlers make without running git; calls it
+// does not implement panic through the nil embedded interface
+type fakeBackend struct {
+	GitBackend
+	branch     string
+	pushOutput []string // returned by successive pushes; a push with output fails
+	pushes     []string
+	pulls      []string
+}
+
+func (f *fakeBackend) CurrentBranch(repo Repo) (string, error) {
+	return f.branch, nil
+}
+
+func (f *fakeBackend) AheadBehind(repo Repo, branch string) (int, int) {
+	return 0, 0
+}
+
+func (f *fakeBackend) Push(repo Repo, remote, branch string, opts PushOptions) (string, error) {
+	f.pushes = append(f.pushes, remote+" "+branch)
+	if len(f.pushOutput) == 0 {
+		return "", nil
+	}
+	output := f.pushOutput[0]
+	f.pushOutput = f.pushOutput[1:]
+	if output == "" {
+		return "", nil
+	}
+	return output, errors.New("exit status 1")
+}
+
+func (f *fakeBackend) Pull(repo Repo, remote, branch, mode string) (string, error) {
+	f.pulls = append(f.pulls, remote+" "+branch)
+	return "", nil
+}
+
+// useFakeBackend replaces the git backend for the duration of the test
+func useFakeBackend(t *testing.T, backend GitBackend) {
+	t.Helper()
+	previous := gitBackend
+	gitBackend = backend
+	t.Cleanup(func() { gitBackend = previous })
+}
+
+func TestHandlePush(t *testing.T) {
+	base := useTestBase(t)
+	web := filepath.Join(base, "web")
+	initTestRepo(t, web)
+	setSelectedRepo(Repo{Path: web})
+	config.ProtectedBranches = []string{"main"}
+
+	rejected := " ! [rejected]        feature -> feature (fetch first)"
+	tests := []struct {
+		name, target, remote, branch string
+		pushOutput                   []string
+		noPullOnReject               bool
+		status                       int
+		pushes, pulls                int
+	}{
+		{"pushes", "/api/push", "origin", "feature", nil, false, http.StatusOK, 1, 0},
+		{"pulls and retries when rejected", "/api/push?remote=upstream", "upstream", "feature", []string{rejected, ""}, false, http.StatusOK, 2, 1},
+		{"reports rejections without pullOnReject", "/api/push", "origin", "feature", []string{rejected}, true, http.StatusConflict, 1, 0},
+		{"fails when the retry fails", "/api/push", "origin", "feature", []string{rejected, "fatal: unable to access"}, false, http.StatusInternalServerError, 2, 1},
+		{"refuses protected branches", "/api/push", "origin", "main", nil, false, http.StatusForbidden, 0, 0},
+		{"refuses remotes that look like options", "/api/push?remote=--upload-pack=touch", "--upload-pack=touch", "feature", nil, false, http.StatusBadRequest, 0, 0},
+		{"requires POST", "/api/push", "origin", "feature", nil, false, http.StatusMethodNotAllowed, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{branch: tt.branch, pushOutput: tt.pushOutput}
+			useFakeBackend(t, backend)
+			pullOnReject := "true"
+			if tt.noPullOnReject {
+				pullOnReject = "false"
+			}
+			runGit(t, web, "config", "airgit.pullOnReject", pullOnReject)
+
+			method := "POST"
+			if tt.status == http.StatusMethodNotAllowed {
+				method = "GET"
+			}
+			w := httptest.NewRecorder()
+			handlePush(w, httptest.NewRequest(method, tt.target, nil))
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if len(backend.pushes) != tt.pushes || len(backend.pulls) != tt.pulls {
+				t.Errorf("pushes = %q, pulls = %q, want %d and %d", backend.pushes, backend.pulls, tt.pushes, tt.pulls)
+			}
+			for _, push := range append(backend.pushes, backend.pulls...) {
+				if push != tt.remote+" "+tt.branch {
+					t.Errorf("pushed or pulled %q, want %q", push, tt.remote+" "+tt.branch)
+				}
+			}
+			if tt.status == http.StatusOK {
+				var resp Response
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Branch != tt.branch {
+					t.Errorf("response = %s, want branch %s", w.Body, tt.branch)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePushForceWithLease(t *testing.T) {
+	base := useTestBase(t)
+	web := filepath.Join(base, "web")
+	initTestRepo(t, web)
+	setSelectedRepo(Repo{Path: web})
+	remote := filepath.Join(base, "remote.git")
+	runGit(t, base, "init", "-q", "--bare", remote)
+	runGit(t, web, "remote", "add", "origin", remote)
+	runGit(t, web, "checkout", "-q", "-b", "feature")
+	runGit(t, web, "commit", "-q", "--allow-empty", "-m", "one")
+	runGit(t, web, "push", "-q", "-u", "origin", "feature")
+	// Someone else works on the same branch in their own clone
+	other := filepath.Join(base, "other")
+	runGit(t, base, "clone", "-q", "-b", "feature", remote, other)
+
+	revParse := func(dir, ref string) string {
+		t.Helper()
+		hash, _ := executeGitCommand(Repo{Path: dir}, "rev-parse", ref)
+		return hash
+	}
+	forcePush := func() (int, Response) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handlePush(w, httptest.NewRequest("POST", "/api/push?force=true", nil))
+		var resp Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+	logsLease := func(resp Response, lease string) bool {
+		for _, line := range resp.Log {
+			if line == "$ git push --force-with-lease=feature:"+lease+" origin feature" {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Rewriting history the remote-tracking branch already knows about
+	tracked := revParse(web, "origin/feature")
+	runGit(t, web, "commit", "-q", "--amend", "--allow-empty", "-m", "one, amended")
+	code, resp := forcePush()
+	if code != http.StatusOK || !logsLease(resp, tracked) {
+		t.Fatalf("force push returned %d: %+v, want a lease on %s", code, resp, tracked)
+	}
+	if revParse(remote, "feature") != revParse(web, "HEAD") {
+		t.Errorf("remote feature was not replaced")
+	}
+
+	// The other clone pushes a commit we have not fetched: the lease is stale
+	runGit(t, other, "fetch", "-q")
+	runGit(t, other, "reset", "-q", "--hard", "origin/feature")
+	runGit(t, other, "commit", "-q", "--allow-empty", "-m", "theirs")
+	runGit(t, other, "push", "-q", "origin", "feature")
+	theirs := revParse(other, "HEAD")
+	runGit(t, web, "commit", "-q", "--amend", "--allow-empty", "-m", "one, amended again")
+	code, resp = forcePush()
+	if code != http.StatusConflict || !logsLease(resp, revParse(web, "origin/feature")) {
+		t.Errorf("force push with a stale lease returned %d: %+v, want 409", code, resp)
+	}
+	if revParse(remote, "feature") != theirs {
+		t.Errorf("stale force push overwrote the other clone's commit")
+	}
+
+	// Without a remote-tracking branch the lease expects the branch not to exist
+	runGit(t, web, "update-ref", "-d", "refs/remotes/origin/feature")
+	code, resp = forcePush()
+	if code != http.StatusConflict || !logsLease(resp, "") {
+		t.Errorf("force push without a remote-tracking branch returned %d: %+v, want 409", code, resp)
+	}
+	if revParse(remote, "feature") != theirs {
+		t.Errorf("force push without a remote-tracking branch overwrote the remote")
+	}
+	runGit(t, remote, "update-ref", "-d", "refs/heads/feature")
+	if code, resp = forcePush(); code != http.StatusOK {
+		t.Errorf("force push of a branch missing on the remote returned %d: %+v", code, resp)
+	}
+	if revParse(remote, "feature") != revParse(web, "HEAD") {
+		t.Errorf("force push did not create the remote branch")
+	}
+}
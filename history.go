@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CommitPerson struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+type CommitTrailer struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type CommitSignature struct {
+	Status   string `json:"status"` // good, good-untrusted, bad, expired-signature, expired-key, revoked-key, unverifiable
+	Verified bool   `json:"verified"`
+	Format   string `json:"format,omitempty"` // openpgp, ssh, x509
+	Signer   string `json:"signer,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+type CommitFileStat struct {
+	Path      string `json:"path"`
+	OldPath   string `json:"oldPath,omitempty"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+type CommitDetail struct {
+	Hash      string           `json:"hash"`
+	Parents   []string         `json:"parents"`
+	Author    CommitPerson     `json:"author"`
+	Committer CommitPerson     `json:"committer"`
+	Subject   string           `json:"subject"`
+	Body      string           `json:"body,omitempty"`
+	Message   string           `json:"message"`
+	Trailers  []CommitTrailer  `json:"trailers,omitempty"`
+	Signature *CommitSignature `json:"signature,omitempty"` // nil for unsigned commits
+	Notes     string           `json:"notes,omitempty"`
+	Files     []CommitFileStat `json:"files"`
+	Additions int              `json:"additions"`
+	Deletions int              `json:"deletions"`
+}
+
+// commitDetailFormat lists the fields read by getCommitDetail, separated by \x1f.
+// The raw message comes last since it is the only field that may span lines freely.
+const commitDetailFormat = "%H%x1f%P%x1f%an%x1f%ae%x1f%aI%x1f%cn%x1f%ce%x1f%cI%x1f%G?%x1f%GS%x1f%GK%x1f%(trailers:only,unfold)%x1f%N%x1f%B"
+
+var signatureStatuses = map[string]string{
+	"G": "good",
+	"U": "good-untrusted",
+	"B": "bad",
+	"X": "expired-signature",
+	"Y": "expired-key",
+	"R": "revoked-key",
+	"E": "unverifiable",
+}
+
+func getCommitDetail(repo Repo, rev string) (*CommitDetail, error) {
+	// stdout only: a warning on stderr would end up in the hash field
+	output, err := executeRawGitCommand(repo, "show", "-s", "--format="+commitDetailFormat, rev, "--")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+
+	fields := strings.SplitN(output, "\x1f", 14)
+	if len(fields) < 14 {
+		return nil, fmt.Errorf("unexpected git show output")
+	}
+
+	message := strings.TrimSpace(fields[13])
+	subject, body, _ := strings.Cut(message, "\n\n")
+	detail := &CommitDetail{
+		Hash:      fields[0],
+		Parents:   strings.Fields(fields[1]),
+		Author:    CommitPerson{Name: fields[2], Email: fields[3], Date: fields[4]},
+		Committer: CommitPerson{Name: fields[5], Email: fields[6], Date: fields[7]},
+		Subject:   strings.ReplaceAll(subject, "\n", " "),
+		Body:      strings.TrimSpace(body),
+		Message:   message,
+		Trailers:  parseTrailers(fields[11]),
+		Notes:     strings.TrimSpace(fields[12]),
+	}
+
+	if status := strings.TrimSpace(fields[8]); status != "N" && status != "" {
+		detail.Signature = &CommitSignature{
+			Status:   signatureStatuses[status],
+			Verified: status == "G",
+			Format:   commitSignatureFormat(repo, detail.Hash),
+			Signer:   fields[9],
+			Key:      fields[10],
+		}
+	}
+
+	files, err := getCommitNumstat(repo, detail.Hash)
+	if err != nil {
+		return nil, err
+	}
+	detail.Files = files
+	for _, f := range files {
+		detail.Additions += f.Additions
+		detail.Deletions += f.Deletions
+	}
+	return detail, nil
+}
+
+// parseTrailers parses "Key: value" lines produced by %(trailers:only,unfold)
+func parseTrailers(output string) []CommitTrailer {
+	var trailers []CommitTrailer
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		trailers = append(trailers, CommitTrailer{
+			Key:   strings.TrimSpace(key),
+			Value: strings.TrimSpace(value),
+		})
+	}
+	return trailers
+}
+
+// commitSignatureFormat inspects the raw gpgsig header, which %G? does not report
+func commitSignatureFormat(repo Repo, hash string) string {
+	raw, err := executeGitCommand(repo, "cat-file", "commit", hash)
+	if err != nil {
+		return ""
+	}
+	header, _, _ := strings.Cut(raw, "\n\n")
+	switch {
+	case strings.Contains(header, "-----BEGIN SSH SIGNATURE-----"):
+		return "ssh"
+	case strings.Contains(header, "-----BEGIN SIGNED MESSAGE-----"):
+		return "x509"
+	case strings.Contains(header, "-----BEGIN PGP SIGNATURE-----"):
+		return "openpgp"
+	}
+	return ""
+}
+
+// getCommitNumstat returns per-file line counts against the first parent
+func getCommitNumstat(repo Repo, hash string) ([]CommitFileStat, error) {
+	args := append(commitDiffArgs(repo, hash), "--numstat", "-z", "-M", "--no-ext-diff")
+	output, err := executeRawGitCommand(repo, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+	return parseNumstat(output), nil
+}
+
+// parseNumstat parses `git diff --numstat -z`. Renamed entries have an empty
+// path after the counts, followed by the old and new paths as separate fields.
+func parseNumstat(output string) []CommitFileStat {
+	files := []CommitFileStat{}
+	fields := strings.Split(output, "\x00")
+
+	for i := 0; i < len(fields); i++ {
+		parts := strings.SplitN(fields[i], "\t", 3)
+		if len(parts) < 3 {
+			continue
+		}
+
+		stat := CommitFileStat{Path: parts[2]}
+		if parts[0] == "-" && parts[1] == "-" {
+			stat.Binary = true
+		} else {
+			stat.Additions, _ = strconv.Atoi(parts[0])
+			stat.Deletions, _ = strconv.Atoi(parts[1])
+		}
+		if stat.Path == "" && i+2 < len(fields) {
+			stat.OldPath = fields[i+1]
+			stat.Path = fields[i+2]
+			i += 2
+		}
+		files = append(files, stat)
+	}
+	return files
+}
+
+// handleCommitDetail serves GET /api/commit/{sha}
+func handleCommitDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	rev := strings.TrimPrefix(r.URL.Path, "/api/commit/")
+	if !isValidRef(rev) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid commit",
+		})
+		return
+	}
+
+	hash, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Commit not found",
+		})
+		return
+	}
+
+	detail, err := getCommitDetail(repo, hash)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to get commit: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"commit": detail,
+	})
+}
+
+// GraphRow places a commit in the branch graph. Edges connect lanes of this
+// row to lanes of the next row, so a UI can draw each row independently.
+type GraphRow struct {
+	Column int         `json:"column"`
+	Edges  []GraphEdge `json:"edges"`
+}
+
+type GraphEdge struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// historyCursor is handed to clients base64-encoded. Last is the hash of the
+// final commit on the previous page; it is re-read to detect rewritten history.
+type historyCursor struct {
+	Skip  int      `json:"skip"`
+	Last  string   `json:"last"`
+	Lanes []string `json:"lanes,omitempty"`
+}
+
+func encodeHistoryCursor(c historyCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeHistoryCursor(s string) (historyCursor, error) {
+	var c historyCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if c.Skip < 1 || !commitHashPattern.MatchString(c.Last) {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	// Cursors come from clients; every graph row copies and scans the lanes
+	if len(c.Lanes) > maxHistoryLimit {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	for _, lane := range c.Lanes {
+		if lane != "" && !commitHashPattern.MatchString(lane) {
+			return c, fmt.Errorf("invalid cursor")
+		}
+	}
+	return c, nil
+}
+
+// commitHashPattern matches full SHA-1 and SHA-256 object names
+var commitHashPattern = regexp.MustCompile(`^(?:[0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// maxHistoryLimit bounds the commits one history page can ask for
+const maxHistoryLimit = 500
+
+// parseHistoryDate accepts RFC 3339 timestamps or plain YYYY-MM-DD dates,
+// reporting which it got
+func parseHistoryDate(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	return t, true, err
+}
+
+// layoutGraph assigns each commit a lane, continuing from lanes (the hash each
+// lane is waiting for). next is the first commit of the following page, if any,
+// and is needed to route the last row's edges. It returns the lanes after the
+// last commit so the next page can continue the layout.
+func layoutGraph(commits []CommitInfo, lanes []string, next *CommitInfo) []string {
+	lanes = append([]string(nil), lanes...)
+
+	indexOf := func(hash string) int {
+		for i, h := range lanes {
+			if h == hash {
+				return i
+			}
+		}
+		return -1
+	}
+	freeLane := func() int {
+		if i := indexOf(""); i >= 0 {
+			return i
+		}
+		lanes = append(lanes, "")
+		return len(lanes) - 1
+	}
+
+	rows := commits
+	if next != nil {
+		rows = append(append([]CommitInfo(nil), commits...), *next)
+	}
+	columns := make([]int, len(rows))
+	before := make([][]string, len(rows))
+	after := make([][]string, len(rows))
+	fromNode := make([]map[int]bool, len(rows))
+
+	for i, c := range rows {
+		before[i] = append([]string(nil), lanes...)
+		col := indexOf(c.Hash)
+		if col < 0 {
+			col = freeLane()
+		}
+		// Other children's lanes end at this commit
+		for k := range lanes {
+			if lanes[k] == c.Hash {
+				lanes[k] = ""
+			}
+		}
+
+		fromNode[i] = make(map[int]bool)
+		for j, parent := range c.Parents {
+			lane := indexOf(parent)
+			if lane < 0 {
+				if j == 0 {
+					lane = col
+				} else {
+					lane = freeLane()
+				}
+				lanes[lane] = parent
+			}
+			fromNode[i][lane] = true
+		}
+
+		for len(lanes) > 0 && lanes[len(lanes)-1] == "" {
+			lanes = lanes[:len(lanes)-1]
+		}
+		columns[i] = col
+		after[i] = append([]string(nil), lanes...)
+	}
+
+	for i := range commits {
+		row := &GraphRow{Column: columns[i], Edges: []GraphEdge{}}
+		for k, hash := range after[i] {
+			if hash == "" {
+				continue
+			}
+			to := k
+			if i+1 < len(rows) && rows[i+1].Hash == hash {
+				to = columns[i+1]
+			}
+			// Lanes waiting for another commit pass straight through this row
+			if k < len(before[i]) && before[i][k] != "" && before[i][k] != commits[i].Hash {
+				row.Edges = append(row.Edges, GraphEdge{From: k, To: to})
+			}
+			if fromNode[i][k] {
+				row.Edges = append(row.Edges, GraphEdge{From: columns[i], To: to})
+			}
+		}
+		commits[i].Graph = row
+	}
+
+	if len(commits) == 0 {
+		return lanes
+	}
+	return after[len(commits)-1]
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleListCommitsDates(t *testing.T) {
+	base := useTestBase(t)
+	repo := filepath.Join(base, "web")
+	initTestRepo(t, repo)
+	for _, date := range []time.Time{
+		time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local),
+		time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local),
+		time.Date(2024, 3, 5, 23, 59, 59, 0, time.Local),
+		time.Date(2024, 3, 6, 0, 0, 0, 0, time.Local),
+	} {
+		t.Setenv("GIT_COMMITTER_DATE", date.Format(time.RFC3339))
+		runGit(t, repo, "commit", "-q", "--allow-empty", "-m", date.Format(time.RFC3339))
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		count int
+	}{
+		{"since=2024-03-05&until=2024-03-05", 200, 2},
+		{"until=2024-03-04", 200, 1},
+		{"since=2024-03-06", 200, 1},
+		{"until=" + time.Date(2024, 3, 5, 12, 0, 0, 0, time.Local).Format(time.RFC3339), 200, 2},
+		{"until=yesterday", 400, 0},
+		{"limit=500&until=2024-03-31", 200, 4},
+		{"limit=501", 400, 0},
+		{"limit=0", 400, 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/commits?repoPath=web&"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleListCommits(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d (%s), want %d", tt.query, w.Code, w.Body.String(), tt.code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+		var response struct {
+			Commits []CommitInfo `json:"commits"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Commits) != tt.count {
+			t.Errorf("%s: got %d commits, want %d", tt.query, len(response.Commits), tt.count)
+		}
+	}
+}
+
+func TestGetCommitDetailIgnoresWarnings(t *testing.T) {
+	repoPath := filepath.Join(useTestBase(t), "web")
+	initTestRepo(t, repoPath)
+	if err := os.WriteFile(filepath.Join(repoPath, "a.txt"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "add", "a.txt")
+	runGit(t, repoPath, "commit", "-q", "-m", "Add a\n\nWith a body.\n\nSigned-off-by: test <test@example.com>")
+	repo := Repo{Path: repoPath}
+	head, _ := executeGitCommand(repo, "rev-parse", "HEAD")
+	useNoisyGit(t)
+
+	detail, err := getCommitDetail(repo, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Hash != head || detail.Subject != "Add a" || detail.Body != "With a body.\n\nSigned-off-by: test <test@example.com>" {
+		t.Errorf("detail = %+v", detail)
+	}
+	if len(detail.Trailers) != 1 || detail.Trailers[0].Key != "Signed-off-by" {
+		t.Errorf("trailers = %+v", detail.Trailers)
+	}
+	if len(detail.Files) != 1 || detail.Files[0].Path != "a.txt" || detail.Additions != 2 {
+		t.Errorf("files = %+v, additions %d", detail.Files, detail.Additions)
+	}
+}
+
+func TestDecodeHistoryCursor(t *testing.T) {
+	hash := strings.Repeat("a1", 20)
+	tooMany := make([]string, maxHistoryLimit+1)
+	tests := []struct {
+		name   string
+		cursor historyCursor
+		ok     bool
+	}{
+		{"valid", historyCursor{Skip: 20, Last: hash, Lanes: []string{hash, "", hash}}, true},
+		{"no lanes", historyCursor{Skip: 20, Last: hash}, true},
+		{"sha-256", historyCursor{Skip: 20, Last: strings.Repeat("b", 64)}, true},
+		{"no skip", historyCursor{Last: hash}, false},
+		{"short last", historyCursor{Skip: 20, Last: "a1b2c3"}, false},
+		{"ref as last", historyCursor{Skip: 20, Last: "main"}, false},
+		{"lane not a hash", historyCursor{Skip: 20, Last: hash, Lanes: []string{"--all"}}, false},
+		{"too many lanes", historyCursor{Skip: 20, Last: hash, Lanes: tooMany}, false},
+	}
+	for _, tt := range tests {
+		_, err := decodeHistoryCursor(encodeHistoryCursor(tt.cursor))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: decodeHistoryCursor error = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+	if _, err := decodeHistoryCursor("not base64!"); err == nil {
+		t.Error("decodeHistoryCursor accepted invalid base64")
+	}
+}
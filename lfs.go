@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LFSFile is a tracked file stored in Git LFS
+type LFSFile struct {
+	Path    string   `json:"path"`
+	Pointer bool     `json:"pointer"`          // the working tree holds the pointer file, not the content
+	Status  string   `json:"status,omitempty"` // status code from `git lfs status`
+	Lock    *LFSLock `json:"lock,omitempty"`
+}
+
+type LFSLock struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Owner    string `json:"owner"`
+	LockedAt string `json:"lockedAt"`
+}
+
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+var errLFSNotInstalled = errors.New("the repository uses Git LFS, but git-lfs is not installed on the server")
+
+var (
+	lfsInstalledOnce sync.Once
+	lfsInstalled     bool
+)
+
+// isLFSInstalled reports whether the git-lfs extension is available on the server
+func isLFSInstalled() bool {
+	lfsInstalledOnce.Do(func() {
+		lfsInstalled = exec.Command("git", "lfs", "version").Run() == nil
+	})
+	return lfsInstalled
+}
+
+// getLFSPatterns returns the patterns that .gitattributes files route through the
+// LFS filter, relative to the repository root. This works without git-lfs installed.
+func getLFSPatterns(repo Repo) []string {
+	output, err := executeGitCommand(repo, "ls-files", "-z", "--", ".gitattributes", ":(glob)**/.gitattributes")
+	if err != nil {
+		return nil
+	}
+
+	var patterns []string
+	for _, file := range strings.Split(output, "\x00") {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(repo.Path, filepath.FromSlash(file)))
+		if err != nil {
+			continue
+		}
+		dir := path.Dir(file)
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for _, attr := range fields[1:] {
+				if attr == "filter=lfs" {
+					pattern := fields[0]
+					if dir != "." {
+						// Patterns without a slash match at any depth below their directory
+						if !strings.Contains(strings.TrimPrefix(pattern, "/"), "/") && !strings.HasPrefix(pattern, "/") {
+							pattern = "**/" + pattern
+						}
+						pattern = dir + "/" + strings.TrimPrefix(pattern, "/")
+					}
+					patterns = append(patterns, pattern)
+					break
+				}
+			}
+		}
+	}
+	return patterns
+}
+
+// usesLFS reports whether pull and push should run git-lfs for repo
+func usesLFS(repo Repo) bool {
+	return getRepoSettings(repo).LFS && len(getLFSPatterns(repo)) > 0
+}
+
+func isLFSPointer(filePath string) bool {
+	info, err := os.Stat(filePath)
+	// Pointer files are always smaller than 1024 bytes
+	if err != nil || !info.Mode().IsRegular() || info.Size() >= 1024 {
+		return false
+	}
+	data, err := os.ReadFile(filePath)
+	return err == nil && bytes.HasPrefix(data, []byte(lfsPointerPrefix))
+}
+
+// getLFSFiles lists the tracked files the LFS filter applies to
+func getLFSFiles(repo Repo) ([]LFSFile, error) {
+	output, err := executeGitCommand(repo, "ls-files", "-z", "--", ":(attr:filter=lfs)")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []LFSFile{}
+	for _, file := range strings.Split(output, "\x00") {
+		if file == "" {
+			continue
+		}
+		files = append(files, LFSFile{
+			Path:    file,
+			Pointer: isLFSPointer(filepath.Join(repo.Path, filepath.FromSlash(file))),
+		})
+	}
+	return files, nil
+}
+
+// getLFSStatus returns the `git lfs status` code of each changed LFS file
+func getLFSStatus(repo Repo) (map[string]string, error) {
+	output, err := executeRawGitCommand(repo, "lfs", "status", "--json")
+	if err != nil {
+		return nil, lfsError(output, err)
+	}
+	var status struct {
+		Files map[string]struct {
+			Status string `json:"status"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(output), &status); err != nil {
+		return nil, err
+	}
+
+	codes := make(map[string]string)
+	for file, entry := range status.Files {
+		codes[file] = entry.Status
+	}
+	return codes, nil
+}
+
+func getLFSLocks(repo Repo) ([]LFSLock, error) {
+	output, err := executeRawGitCommand(repo, "lfs", "locks", "--json")
+	if err != nil {
+		return nil, lfsError(output, err)
+	}
+	var entries []struct {
+		ID    string `json:"id"`
+		Path  string `json:"path"`
+		Owner struct {
+			Name string `json:"name"`
+		} `json:"owner"`
+		LockedAt string `json:"locked_at"`
+	}
+	if err := json.Unmarshal([]byte(output), &entries); err != nil {
+		return nil, err
+	}
+
+	locks := []LFSLock{}
+	for _, entry := range entries {
+		locks = append(locks, LFSLock{ID: entry.ID, Path: entry.Path, Owner: entry.Owner.Name, LockedAt: entry.LockedAt})
+	}
+	return locks, nil
+}
+
+// lfsError turns the output of a failed git or git-lfs command into an error
+// naming the LFS problem, or wraps err if the output does not mention LFS
+func lfsError(output string, err error) error {
+	// The first is printed by the hooks `git lfs install` sets up
+	if strings.Contains(output, "'git-lfs' was not found") || strings.Contains(output, "'lfs' is not a git command") {
+		return errLFSNotInstalled
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "LFS") || strings.HasPrefix(line, "batch response:") {
+			return fmt.Errorf("Git LFS: %s", line)
+		}
+	}
+	return err
+}
+
+// lfsErrorStatus is the HTTP status for an error from runLFS: the server's
+// fault if git-lfs is missing, otherwise the LFS server's
+func lfsErrorStatus(err error) int {
+	if errors.Is(err, errLFSNotInstalled) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadGateway
+}
+
+// runLFS runs git lfs with args for a pull or push, logging like runLoggedGit
+func runLFS(repo Repo, logs []string, args ...string) ([]string, error) {
+	if !isLFSInstalled() {
+		return logs, errLFSNotInstalled
+	}
+	logs, output, err := runLoggedGit(repo, logs, append([]string{"lfs"}, args...)...)
+	if err != nil {
+		return logs, lfsError(output, err)
+	}
+	return logs, nil
+}
+
+// handleLFSStatus serves GET /api/lfs/status
+func handleLFSStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	files, err := getLFSFiles(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list LFS files: %v", err),
+		})
+		return
+	}
+
+	response := map[string]interface{}{
+		"installed": isLFSInstalled(),
+		"enabled":   getRepoSettings(repo).LFS,
+		"patterns":  getLFSPatterns(repo),
+		"files":     files,
+	}
+
+	// Status and locks need git-lfs; locks also need a reachable LFS server
+	if isLFSInstalled() && len(files) > 0 {
+		var warnings []string
+		if status, err := getLFSStatus(repo); err == nil {
+			for i := range files {
+				files[i].Status = status[files[i].Path]
+			}
+		} else {
+			warnings = append(warnings, err.Error())
+		}
+		if locks, err := getLFSLocks(repo); err == nil {
+			for i := range locks {
+				for j := range files {
+					if files[j].Path == locks[i].Path {
+						files[j].Lock = &locks[i]
+					}
+				}
+			}
+		} else {
+			warnings = append(warnings, err.Error())
+		}
+		if warnings != nil {
+			response["warnings"] = warnings
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleLFSLocks serves GET /api/lfs/locks
+func handleLFSLocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok || !requireLFS(w) {
+		return
+	}
+
+	locks, err := getLFSLocks(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list locks: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locks": locks,
+	})
+}
+
+func requireLFS(w http.ResponseWriter) bool {
+	if !isLFSInstalled() {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(Response{
+			Error: errLFSNotInstalled.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// handleLFSLock serves POST /api/lfs/lock and /api/lfs/unlock. Locks are held
+// on the LFS server, so other users see them and cannot push changes to the file.
+func handleLFSLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok || !requireLFS(w) {
+		return
+	}
+
+	var req struct {
+		Path  string `json:"path"`
+		Force bool   `json:"force"` // unlock: release a lock held by someone else
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	filePath, valid := resolveRepoFilePath(repo, req.Path)
+	if !valid || filePath == "" || strings.HasPrefix(filePath, "-") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid path",
+		})
+		return
+	}
+
+	unlock := strings.HasSuffix(r.URL.Path, "/unlock")
+	args := []string{"lfs", "lock", filePath}
+	if unlock {
+		args = []string{"lfs", "unlock", filePath}
+		if req.Force {
+			args = []string{"lfs", "unlock", "--force", filePath}
+		}
+	}
+
+	logs, output, err := runLoggedGit(repo, nil, args...)
+	if err != nil {
+		// Typically the file is already locked, or locked by someone else
+		status := http.StatusConflict
+		if strings.Contains(output, "batch response") || strings.Contains(output, "API error") {
+			status = http.StatusBadGateway
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: lfsError(output, errors.New(strings.TrimSpace(output))).Error(),
+			Log:   logs,
+		})
+		return
+	}
+
+	message := "✓ Locked " + filePath
+	if unlock {
+		message = "✓ Unlocked " + filePath
+	}
+	json.NewEncoder(w).Encode(Response{
+		Log: append(logs, message),
+	})
+}
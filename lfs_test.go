@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetLFSPatterns(t *testing.T) {
+	repoPath := filepath.Join(useTestBase(t), "web")
+	initTestRepo(t, repoPath)
+	files := map[string]string{
+		".gitattributes": "# *.zip filter=lfs\n" +
+			"*.psd filter=lfs diff=lfs merge=lfs -text\n" +
+			"*.txt text eol=lf\n" +
+			"/big.bin\tfilter=lfs\n" +
+			"*.iso -filter\n",
+		"assets/.gitattributes": "*.png filter=lfs diff=lfs merge=lfs -text\n" +
+			"/raw/*.wav filter=lfs\n" +
+			"models/*.bin filter=lfs\n" +
+			"*.svg filter=svgo\n",
+		// Not tracked, so not used
+		"drafts/.gitattributes": "*.pdf filter=lfs\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, repoPath, "add", ".gitattributes", "assets/.gitattributes")
+	runGit(t, repoPath, "commit", "-q", "-m", "attributes")
+
+	want := "*.psd /big.bin assets/**/*.png assets/raw/*.wav assets/models/*.bin"
+	if got := strings.Join(getLFSPatterns(Repo{Path: repoPath}), " "); got != want {
+		t.Errorf("getLFSPatterns = %q, want %q", got, want)
+	}
+
+	if patterns := getLFSPatterns(Repo{Path: filepath.Join(repoPath, "..", "missing")}); len(patterns) != 0 {
+		t.Errorf("patterns for a missing repository = %q", patterns)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,6 +32,27 @@ type Config struct {
 	ListenPort string
 	TLSCert    string
 	TLSKey     string
+	GitBackend string
+	// ProtectedBranches are glob patterns protected in every repository, in addition
+	// to each repository's own airgit.protectedBranch entries
+	ProtectedBranches []string
+	// AuthFile holds the user accounts; AuthDisabled turns off authentication
+	// entirely, for servers only reachable by trusted users
+	AuthFile     string
+	AuthDisabled bool
+	// AllowedOrigins may make state-changing requests besides AirGit's own
+	// origin, e.g. when it is reached through a reverse proxy under another name
+	AllowedOrigins []string
+	// CSRFExemptTokens lets requests with an API token skip the CSRF checks
+	CSRFExemptTokens bool
+	// AuditLog records every state-changing request; it is rotated once it
+	// grows past AuditLogMaxSize bytes, keeping AuditLogFiles old logs
+	AuditLog        string
+	AuditLogMaxSize int64
+	AuditLogFiles   int
+	// LockfileCommands lets the lockfile conflict strategy run package managers,
+	// which execute code from the repository (Gemfiles, .yarnrc.yml, plugins)
+	LockfileCommands bool
 }
 
 type Response struct {
@@ -47,10 +70,13 @@ type Response struct {
 }
 
 type CommitInfo struct {
-	Hash    string `json:"hash"`
-	Author  string `json:"author"`
-	Date    string `json:"date"`
-	Message string `json:"message"`
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Date    string    `json:"date"`
+	Message string    `json:"message"`
+	Body    string    `json:"body,omitempty"`
+	Parents []string  `json:"parents,omitempty"`
+	Graph   *GraphRow `json:"graph,omitempty"`
 }
 
 type RemoteInfo struct {
@@ -58,9 +84,26 @@ type RemoteInfo struct {
 	URL  string `json:"url"`
 }
 
+// Repo identifies the git working tree a single request operates on. It is
+// resolved once per request and passed explicitly to every git invocation so
+// concurrent requests against different repositories never interfere.
+type Repo struct {
+	Path string
+}
+
+// Name returns the repository's directory name.
+func (r Repo) Name() string {
+	return filepath.Base(r.Path)
+}
+
 type Repository struct {
 	Name string `json:"name"`
 	Path string `json:"path"`
+
+	// Linked worktrees and submodules within the base path are listed under their main repository
+	Branch     string       `json:"branch,omitempty"`
+	Worktrees  []Repository `json:"worktrees,omitempty"`
+	Submodules []Repository `json:"submodules,omitempty"` // checked-out submodules, with their own submodules
 }
 
 type AgentStatus struct {
@@ -75,6 +118,8 @@ type AgentStatus struct {
 
 var config Config
 var baseRepoPath string
+var selectedRepo Repo // repository used when a request does not name one
+var selectedRepoMutex sync.RWMutex
 var agentStatus map[int]AgentStatus // issueNumber -> status
 var agentStatusMutex sync.Mutex
 var githubAuthProcess *exec.Cmd // Track ongoing GitHub auth process
@@ -97,8 +142,19 @@ func init() {
 		ListenPort: getEnv("AIRGIT_LISTEN_PORT", "8080"),
 		TLSCert:    getEnv("AIRGIT_TLS_CERT", ""),
 		TLSKey:     getEnv("AIRGIT_TLS_KEY", ""),
-	}
+		GitBackend: getEnv("AIRGIT_GIT_BACKEND", "exec"),
+	}
+	config.ProtectedBranches = splitPatterns(getEnv("AIRGIT_PROTECTED_BRANCHES", ""))
+	config.AuthFile = getEnv("AIRGIT_AUTH_FILE", defaultAuthFilePath())
+	config.AuthDisabled = getEnv("AIRGIT_DISABLE_AUTH", "") == "true"
+	config.AllowedOrigins = splitPatterns(getEnv("AIRGIT_ALLOWED_ORIGINS", ""))
+	config.CSRFExemptTokens = getEnv("AIRGIT_CSRF_EXEMPT_TOKENS", "true") != "false"
+	config.AuditLog = getEnv("AIRGIT_AUDIT_LOG", "")
+	config.AuditLogMaxSize = int64(getIntEnv("AIRGIT_AUDIT_LOG_MAX_SIZE", 10)) << 20
+	config.AuditLogFiles = getIntEnv("AIRGIT_AUDIT_LOG_FILES", 5)
+	config.LockfileCommands = getEnv("AIRGIT_LOCKFILE_COMMANDS", "") == "true"
 	baseRepoPath = config.RepoPath
+	selectedRepo = Repo{Path: config.RepoPath}
 	agentStatus = make(map[int]AgentStatus)
 
 	log.Printf("Config: RepoPath=%s", config.RepoPath)
@@ -120,14 +176,29 @@ func getEnv(key, defaultVal string) string {
 	return defaultVal
 }
 
-// resolveAndValidateRepoPath resolves a repository path and validates it's within the base path and is a git repo
-func resolveAndValidateRepoPath(repoPath, basePath string) (string, bool) {
+func getIntEnv(key string, defaultVal int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultVal
+}
+
+// isWithinBase reports whether path is basePath itself or lies beneath it
+func isWithinBase(path, basePath string) bool {
+	basePathAbs, _ := filepath.Abs(basePath)
+	return path == basePathAbs || strings.HasPrefix(path, basePathAbs+string(filepath.Separator))
+}
+
+// resolveAndValidateRepoPath resolves a repository path and validates it's within the base path and is a git repo.
+// Absolute paths outside the base path are treated as relative to it, which is how the frontend sends
+// the URL pathname (e.g. "/projects/my-repo").
+func resolveAndValidateRepoPath(repoPath, basePath string) (Repo, bool) {
 	if repoPath == "" {
-		return "", false
+		return Repo{}, false
 	}
 
 	var resolvedPath string
-	if filepath.IsAbs(repoPath) {
+	if filepath.IsAbs(repoPath) && isWithinBase(filepath.Clean(repoPath), basePath) {
 		resolvedPath = repoPath
 	} else {
 		resolvedPath = filepath.Join(basePath, repoPath)
@@ -136,21 +207,60 @@ func resolveAndValidateRepoPath(repoPath, basePath string) (string, bool) {
 	var err error
 	resolvedPath, err = filepath.Abs(resolvedPath)
 	if err != nil {
-		return "", false
+		return Repo{}, false
 	}
 
-	basePathAbs, _ := filepath.Abs(basePath)
 	// Check if resolved path is within base path
-	if !strings.HasPrefix(resolvedPath, basePathAbs+string(filepath.Separator)) && resolvedPath != basePathAbs {
-		return "", false
+	if !isWithinBase(resolvedPath, basePath) {
+		return Repo{}, false
 	}
 
 	// Check if it's a valid git repository
 	if !isGitRepo(resolvedPath) {
-		return "", false
+		return Repo{}, false
+	}
+
+	return Repo{Path: resolvedPath}, true
+}
+
+// getSelectedRepo returns the repository chosen via /api/load-repo (or the configured default)
+func getSelectedRepo() Repo {
+	selectedRepoMutex.RLock()
+	defer selectedRepoMutex.RUnlock()
+	return selectedRepo
+}
+
+func setSelectedRepo(repo Repo) {
+	selectedRepoMutex.Lock()
+	defer selectedRepoMutex.Unlock()
+	selectedRepo = repo
+}
+
+// requestRepo resolves the repository targeted by a request. The optional repoPath query
+// parameter must name a git repository within baseRepoPath; without it the selected repository is used.
+// Requests through the server get the repository pinRequestRepo resolved when they arrived.
+func requestRepo(r *http.Request) (Repo, bool) {
+	if repo, ok := r.Context().Value(repoContextKey).(Repo); ok {
+		return repo, true
+	}
+	repoPath := r.URL.Query().Get("repoPath")
+	if repoPath == "" || repoPath == "/" {
+		return getSelectedRepo(), true
 	}
+	return resolveAndValidateRepoPath(repoPath, baseRepoPath)
+}
 
-	return resolvedPath, true
+// requireRepo is requestRepo for handlers: it writes a 400 response when the repository is invalid
+func requireRepo(w http.ResponseWriter, r *http.Request) (Repo, bool) {
+	repo, ok := requestRepo(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid repository path",
+		})
+		return Repo{}, false
+	}
+	return repo, true
 }
 
 func main() {
@@ -161,6 +271,15 @@ func main() {
 	var listenPort string
 	var tlsCert string
 	var tlsKey string
+	var backendName string
+	var protectedBranches string
+	var authFile string
+	var disableAuth bool
+	var allowedOrigins string
+	var csrfExemptTokens bool
+	var auditLog string
+	var auditLogMaxSize, auditLogFiles int
+	var lockfileCommands bool
 
 	flag.BoolVar(&showHelp, "help", false, "Show help message")
 	flag.BoolVar(&showHelp, "h", false, "Show help message (shorthand)")
@@ -173,6 +292,16 @@ func main() {
 	flag.StringVar(&listenPort, "p", "", "Server listen port (shorthand, default: 8080)")
 	flag.StringVar(&tlsCert, "tls-cert", "", "Path to TLS certificate file (for HTTPS)")
 	flag.StringVar(&tlsKey, "tls-key", "", "Path to TLS key file (for HTTPS)")
+	flag.StringVar(&backendName, "git-backend", "", "Git backend: exec (git binary) or native (in-process, read-only) (default: exec)")
+	flag.StringVar(&protectedBranches, "protected-branches", "", "Comma-separated branch patterns that cannot be pushed to or deleted from AirGit (e.g. main,release/*)")
+	flag.StringVar(&authFile, "auth-file", "", "Path to the user accounts file (default: $HOME/.config/airgit/auth.json)")
+	flag.BoolVar(&disableAuth, "disable-auth", false, "Disable authentication (anyone who can reach the server has full access)")
+	flag.StringVar(&allowedOrigins, "allowed-origins", "", "Comma-separated origins allowed to make changes besides AirGit's own (e.g. https://git.example.com)")
+	flag.BoolVar(&csrfExemptTokens, "csrf-exempt-tokens", config.CSRFExemptTokens, "Let API token requests skip the CSRF checks")
+	flag.StringVar(&auditLog, "audit-log", "", "Path to the audit log (default: audit.jsonl next to the user accounts file)")
+	flag.IntVar(&auditLogMaxSize, "audit-log-max-size", int(config.AuditLogMaxSize>>20), "Size in MB at which the audit log is rotated")
+	flag.IntVar(&auditLogFiles, "audit-log-files", config.AuditLogFiles, "Number of rotated audit logs to keep")
+	flag.BoolVar(&lockfileCommands, "lockfile-commands", false, "Let the lockfile conflict strategy run package managers, which execute code from the repository")
 
 	flag.Parse()
 
@@ -189,6 +318,8 @@ func main() {
 	// Override config with command-line flags if provided
 	if repoPath != "" {
 		config.RepoPath = repoPath
+		baseRepoPath = repoPath
+		setSelectedRepo(Repo{Path: repoPath})
 	}
 	if listenAddr != "" {
 		config.ListenAddr = listenAddr
@@ -202,21 +333,118 @@ func main() {
 	if tlsKey != "" {
 		config.TLSKey = tlsKey
 	}
+	if backendName != "" {
+		config.GitBackend = backendName
+	}
+	if protectedBranches != "" {
+		config.ProtectedBranches = splitPatterns(protectedBranches)
+	}
+	if authFile != "" {
+		config.AuthFile = authFile
+	}
+	if disableAuth {
+		config.AuthDisabled = true
+	}
+	if allowedOrigins != "" {
+		config.AllowedOrigins = splitPatterns(allowedOrigins)
+	}
+	config.CSRFExemptTokens = csrfExemptTokens
+	if auditLog != "" {
+		config.AuditLog = auditLog
+	}
+	if config.AuditLog == "" {
+		config.AuditLog = filepath.Join(filepath.Dir(config.AuthFile), "audit.jsonl")
+	}
+	if auditLogMaxSize < 1 || auditLogFiles < 0 {
+		log.Fatal("--audit-log-max-size must be at least 1 and --audit-log-files at least 0")
+	}
+	config.AuditLogMaxSize = int64(auditLogMaxSize) << 20
+	config.AuditLogFiles = auditLogFiles
+	if lockfileCommands {
+		config.LockfileCommands = true
+	}
+
+	backend, err := newGitBackend(config.GitBackend)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gitBackend = backend
+	log.Printf("Using %s git backend", config.GitBackend)
+
+	if config.AuthDisabled {
+		log.Printf("WARNING: Authentication is disabled; anyone who can reach %s has full access", net.JoinHostPort(config.ListenAddr, config.ListenPort))
+	} else if err := loadAuth(); err != nil {
+		log.Fatal(err)
+	}
 
 	http.HandleFunc("/manifest.json", serveManifest)
 	http.HandleFunc("/service-worker.js", serveServiceWorker)
 	http.HandleFunc("/icon.png", serveIcon)
+	http.HandleFunc("/api/auth/status", handleAuthStatus)
+	http.HandleFunc("/api/auth/setup", handleSetup)
+	http.HandleFunc("/api/auth/login", handleLogin)
+	http.HandleFunc("/api/auth/logout", handleLogout)
+	http.HandleFunc("/api/auth/password", handleChangePassword)
+	http.HandleFunc("/api/users", handleListUsers)
+	http.HandleFunc("/api/users/", handleUserAction)
+	http.HandleFunc("/api/users/roles", handleSetUserRoles)
+	http.HandleFunc("/api/tokens", handleListTokens)
+	http.HandleFunc("/api/tokens/create", handleCreateToken)
+	http.HandleFunc("/api/tokens/revoke", handleRevokeToken)
 	http.HandleFunc("/api/status", handleStatus)
 	http.HandleFunc("/api/push", handlePush)
 	http.HandleFunc("/api/pull", handlePull)
+	http.HandleFunc("/api/fetch", handleFetch)
 	http.HandleFunc("/api/commits", handleListCommits)
+	http.HandleFunc("/api/changes", handleListChanges)
+	http.HandleFunc("/api/stage", handleStage)
+	http.HandleFunc("/api/unstage", handleUnstage)
+	http.HandleFunc("/api/discard", handleDiscard)
+	http.HandleFunc("/api/commit", handleCommit)
+	http.HandleFunc("/api/commit/", handleCommitDetail)
+	http.HandleFunc("/api/diff", handleDiff)
+	http.HandleFunc("/api/tree", handleTree)
+	http.HandleFunc("/api/blob", handleBlob)
+	http.HandleFunc("/api/raw", handleRaw)
+	http.HandleFunc("/api/file/save", handleSaveFile)
+	http.HandleFunc("/api/blame", handleBlame)
+	http.HandleFunc("/api/diff/apply", handleApplyHunk)
 	http.HandleFunc("/api/repos", handleListRepos)
 	http.HandleFunc("/api/load-repo", handleLoadRepo)
 	http.HandleFunc("/api/branch/create", handleCreateBranch)
+	http.HandleFunc("/api/branch/delete", handleDeleteBranch)
+	http.HandleFunc("/api/branch/rename", handleRenameBranch)
+	http.HandleFunc("/api/branch/upstream", handleSetUpstream)
+	http.HandleFunc("/api/worktrees", handleWorktrees)
+	http.HandleFunc("/api/worktree/add", handleAddWorktree)
+	http.HandleFunc("/api/worktree/", handleWorktreeAction)
+	http.HandleFunc("/api/submodules", handleSubmodules)
+	http.HandleFunc("/api/submodule/", handleSubmoduleAction)
+	http.HandleFunc("/api/lfs/status", handleLFSStatus)
+	http.HandleFunc("/api/lfs/locks", handleLFSLocks)
+	http.HandleFunc("/api/lfs/lock", handleLFSLock)
+	http.HandleFunc("/api/lfs/unlock", handleLFSLock)
 	http.HandleFunc("/api/branches", handleListBranches)
 	http.HandleFunc("/api/checkout", handleCheckoutBranch)
+	http.HandleFunc("/api/stash", handleListStashes)
+	http.HandleFunc("/api/stash/save", handleSaveStash)
+	http.HandleFunc("/api/stash/apply", handleApplyStash)
+	http.HandleFunc("/api/stash/pop", handleApplyStash)
+	http.HandleFunc("/api/stash/drop", handleDropStash)
+	http.HandleFunc("/api/stash/show", handleShowStash)
+	http.HandleFunc("/api/merge", handleMerge)
+	http.HandleFunc("/api/rebase", handleRebase)
+	http.HandleFunc("/api/cherry-pick", handlePick)
+	http.HandleFunc("/api/revert", handlePick)
+	http.HandleFunc("/api/operation", handleOperationState)
+	http.HandleFunc("/api/operation/", handleOperationAction)
+	http.HandleFunc("/api/conflicts", handleListConflicts)
+	http.HandleFunc("/api/conflicts/file", handleConflictFile)
+	http.HandleFunc("/api/conflicts/resolve", handleResolveConflict)
+	http.HandleFunc("/api/conflicts/auto", handleAutoResolveConflicts)
 	http.HandleFunc("/api/repo/create", handleCreateRepo)
 	http.HandleFunc("/api/repo/init", handleInitRepo)
+	http.HandleFunc("/api/repo/settings", handleRepoSettings)
 	http.HandleFunc("/api/remotes", handleListRemotes)
 	http.HandleFunc("/api/remote/add", handleAddRemote)
 	http.HandleFunc("/api/remote/update", handleUpdateRemote)
@@ -224,6 +452,9 @@ func main() {
 	http.HandleFunc("/api/tags", handleListTags)
 	http.HandleFunc("/api/tag/create", handleCreateTag)
 	http.HandleFunc("/api/tag/push", handlePushTag)
+	http.HandleFunc("/api/tag/delete", handleDeleteTag)
+	http.HandleFunc("/api/tag/show", handleShowTag)
+	http.HandleFunc("/api/tags/compare", handleCompareTags)
 	http.HandleFunc("/api/systemd/register", handleSystemdRegister)
 	http.HandleFunc("/api/systemd/status", handleSystemdStatus)
 	http.HandleFunc("/api/systemd/service-status", handleSystemdServiceStatus)
@@ -239,19 +470,21 @@ func main() {
 	http.HandleFunc("/api/agent/process", handleAgentProcess)
 	http.HandleFunc("/api/agent/status", handleAgentStatus)
 	http.HandleFunc("/api/agent/apply-review", handleAgentApplyReview)
+	http.HandleFunc("/api/audit", handleAudit)
 	http.HandleFunc("/", serveRoot)
 
 	addr := net.JoinHostPort(config.ListenAddr, config.ListenPort)
+	handler := pinRequestRepo(recordAudit(requireCSRF(requireAuth(http.DefaultServeMux))))
 
 	// Determine if using TLS
 	if config.TLSCert != "" && config.TLSKey != "" {
 		log.Printf("Starting AirGit on https://%s (with TLS)", addr)
-		if err := http.ListenAndServeTLS(addr, config.TLSCert, config.TLSKey, nil); err != nil {
+		if err := http.ListenAndServeTLS(addr, config.TLSCert, config.TLSKey, handler); err != nil {
 			log.Fatal(err)
 		}
 	} else {
 		log.Printf("Starting AirGit on http://%s", addr)
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		if err := http.ListenAndServe(addr, handler); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -271,6 +504,26 @@ Options:
                             Server listen port (env: AIRGIT_LISTEN_PORT, default: 8080)
   --tls-cert <path>         Path to TLS certificate file (env: AIRGIT_TLS_CERT, for HTTPS)
   --tls-key <path>          Path to TLS key file (env: AIRGIT_TLS_KEY, for HTTPS)
+  --git-backend <name>      Git backend: exec or native (env: AIRGIT_GIT_BACKEND, default: exec)
+                            The native backend needs no git binary but only supports browsing
+  --protected-branches <globs>
+                            Comma-separated branches that cannot be pushed to or deleted
+                            from AirGit, e.g. main,release/* (env: AIRGIT_PROTECTED_BRANCHES)
+  --auth-file <path>        User accounts file (env: AIRGIT_AUTH_FILE,
+                            default: $HOME/.config/airgit/auth.json)
+  --disable-auth            Disable login; anyone who can reach the server has full
+                            access (env: AIRGIT_DISABLE_AUTH=true)
+  --allowed-origins <list>  Comma-separated origins, besides AirGit's own, allowed to make
+                            changes, e.g. behind a reverse proxy (env: AIRGIT_ALLOWED_ORIGINS)
+  --csrf-exempt-tokens      Let API token requests skip the CSRF checks (env:
+                            AIRGIT_CSRF_EXEMPT_TOKENS, default: true)
+  --audit-log <path>        Audit log of every change (env: AIRGIT_AUDIT_LOG,
+                            default: audit.jsonl next to the user accounts file)
+  --audit-log-max-size <MB> Rotate the audit log at this size (env: AIRGIT_AUDIT_LOG_MAX_SIZE,
+                            default: 10)
+  --audit-log-files <n>     Rotated audit logs to keep (env: AIRGIT_AUDIT_LOG_FILES, default: 5)
+  --lockfile-commands       Let the lockfile conflict strategy run package managers, which
+                            execute code from the repository (env: AIRGIT_LOCKFILE_COMMANDS=true)
 
 Examples:
   # Using environment variables
@@ -358,39 +611,24 @@ func serveIcon(w http.ResponseWriter, r *http.Request) {
 func handleStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		if validPath, ok := resolveAndValidateRepoPath(repoPath, originalRepoPath); ok {
-			config.RepoPath = validPath
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
-	branch, err := executeGitCommand("branch", "--show-current")
-	if err != nil || strings.TrimSpace(branch) == "" {
-		// Fallback: try alternative method to get current branch
-		branch, err = executeGitCommand("rev-parse", "--abbrev-ref", "HEAD")
-		if err != nil {
-			json.NewEncoder(w).Encode(Response{
-				Error: fmt.Sprintf("Failed to get branch: %v", err),
-			})
-			return
-		}
+	branch, err := gitBackend.CurrentBranch(repo)
+	if err != nil {
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to get branch: %v", err),
+		})
+		return
 	}
 
-	branch = strings.TrimSpace(branch)
-
 	// Get repository name from the directory name
-	repoName := filepath.Base(config.RepoPath)
+	repoName := repo.Name()
 
 	// Get ahead/behind count
-	ahead, behind := getAheadBehind(branch)
+	ahead, behind := gitBackend.AheadBehind(repo, branch)
 
 	json.NewEncoder(w).Encode(Response{
 		Branch:   branch,
@@ -408,59 +646,72 @@ func handlePush(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
 	remote := r.URL.Query().Get("remote")
 	if remote == "" {
 		remote = "origin"
 	}
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	if !isValidRef(remote) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid remote",
+		})
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
 
 	var logs []string
 
 	// Get current branch
-	branch, err := executeGitCommand("branch", "--show-current")
-	if err != nil || strings.TrimSpace(branch) == "" {
-		// Fallback: try alternative method
-		branch, err = executeGitCommand("rev-parse", "--abbrev-ref", "HEAD")
+	branch, err := gitBackend.CurrentBranch(repo)
+	if err != nil {
+		resp := Response{
+			Error: fmt.Sprintf("Failed to get branch: %v", err),
+			Log:   logs,
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	settings := getRepoSettings(repo)
+	if pattern, protected := settings.protectedBranchPattern(branch); protected {
+		resp := Response{
+			Error: fmt.Sprintf("%s is a protected branch (%s); pushing to it from AirGit is not allowed", branch, pattern),
+		}
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// force=true pushes with a lease on the remote-tracking branch as last fetched, so
+	// commits pushed by someone else since then are never overwritten
+	var opts PushOptions
+	if r.URL.Query().Get("force") == "true" {
+		opts.ForceWithLease = true
+		opts.Expected, _ = executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "refs/remotes/"+remote+"/"+branch)
+	}
+
+	// Upload LFS objects first, so the remote never gets pointers to objects it lacks
+	if usesLFS(repo) {
+		logs, err = runLFS(repo, logs, "push", remote, branch)
 		if err != nil {
 			resp := Response{
-				Error: fmt.Sprintf("Failed to get branch: %v", err),
+				Error: fmt.Sprintf("LFS push failed: %v", err),
 				Log:   logs,
 			}
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(lfsErrorStatus(err))
 			json.NewEncoder(w).Encode(resp)
 			return
 		}
 	}
 
-	branch = strings.TrimSpace(branch)
-
-	// git push [remote] [branch]
-	output, err := executeGitCommand("push", remote, branch)
-	logs = append(logs, fmt.Sprintf("$ git push %s %s", remote, branch))
+	// git push [--force-with-lease=branch:sha] [remote] [branch]
+	output, err := gitBackend.Push(repo, remote, branch, opts)
+	logs = append(logs, "$ git "+strings.Join(pushArgs(remote, branch, opts), " "))
 	if output != "" {
 		logs = append(logs, output)
 	}
@@ -468,14 +719,31 @@ func handlePush(w http.ResponseWriter, r *http.Request) {
 		// Check if it's "everything up-to-date" which is not really an error
 		if strings.Contains(output, "up to date") || strings.Contains(output, "up-to-date") {
 			logs = append(logs, "✓ Everything is already up to date!")
-		} else if strings.Contains(output, "rejected") && strings.Contains(output, "non-fast-forward") {
+		} else if opts.ForceWithLease && strings.Contains(output, "stale info") {
+			resp := Response{
+				Error: "The remote branch changed since it was last fetched; fetch and review it before force-pushing",
+				Log:   logs,
+			}
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(resp)
+			return
+		} else if strings.Contains(output, "rejected") && (strings.Contains(output, "non-fast-forward") || strings.Contains(output, "fetch first")) {
 			// Remote has changes that we don't have - need to pull first
 			logs = append(logs, "⚠ Push rejected: remote has changes")
-			logs = append(logs, "Attempting to pull and merge...")
-			
-			// Try to pull with merge
-			pullOutput, pullErr := executeGitCommand("pull", remote, branch)
-			logs = append(logs, fmt.Sprintf("$ git pull %s %s", remote, branch))
+			if !settings.PullOnReject {
+				resp := Response{
+					Error: "Push rejected because the remote has changes; pull them first",
+					Log:   logs,
+				}
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			logs = append(logs, "Attempting to pull remote changes...")
+
+			// Try to pull with the repository's pull mode
+			pullOutput, pullErr := gitBackend.Pull(repo, remote, branch, settings.PullMode)
+			logs = append(logs, "$ git "+strings.Join(pullArgs(remote, branch, settings.PullMode), " "))
 			if pullOutput != "" {
 				logs = append(logs, pullOutput)
 			}
@@ -483,10 +751,14 @@ func handlePush(w http.ResponseWriter, r *http.Request) {
 			if pullErr != nil {
 				if strings.Contains(pullOutput, "CONFLICT") {
 					// Conflict detected during pull
-					conflictFiles := getConflictFiles()
+					conflictFiles := getConflictFiles(repo)
+					operation := "merge"
+					if state, _ := getOperationState(repo); state.Operation != "" {
+						operation = state.Operation
+					}
 					resp := Response{
 						Error: fmt.Sprintf("Merge conflict detected in %d file(s)", len(conflictFiles)),
-						Log:   append(logs, "Please resolve conflicts manually"),
+						Log:   append(logs, fmt.Sprintf("Please resolve the conflicts, then continue the %s", operation)),
 					}
 					w.WriteHeader(http.StatusConflict)
 					json.NewEncoder(w).Encode(resp)
@@ -504,14 +776,14 @@ func handlePush(w http.ResponseWriter, r *http.Request) {
 			logs = append(logs, "✓ Pull successful, retrying push...")
 			
 			// Retry push after successful pull
-			retryOutput, retryErr := executeGitCommand("push", remote, branch)
+			retryOutput, retryErr := gitBackend.Push(repo, remote, branch, PushOptions{})
 			logs = append(logs, fmt.Sprintf("$ git push %s %s", remote, branch))
 			if retryOutput != "" {
 				logs = append(logs, retryOutput)
 			}
 			if retryErr != nil {
 				resp := Response{
-					Error: fmt.Sprintf("Push failed after pull: %v", retryErr),
+					Error: fmt.Sprintf("Push failed after pull: %v", lfsError(retryOutput, retryErr)),
 					Log:   logs,
 				}
 				w.WriteHeader(http.StatusInternalServerError)
@@ -521,7 +793,7 @@ func handlePush(w http.ResponseWriter, r *http.Request) {
 			logs = append(logs, "✓ Push successful after pull!")
 		} else {
 			resp := Response{
-				Error: fmt.Sprintf("git push failed: %v", err),
+				Error: fmt.Sprintf("git push failed: %v", lfsError(output, err)),
 				Log:   logs,
 			}
 			w.WriteHeader(http.StatusInternalServerError)
@@ -546,75 +818,90 @@ func handlePull(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
 	remote := r.URL.Query().Get("remote")
 	if remote == "" {
 		remote = "origin"
 	}
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	if !isValidRef(remote) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid remote",
+		})
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
 
 	var logs []string
 
 	// Get current branch
-	branch, err := executeGitCommand("branch", "--show-current")
-	if err != nil || strings.TrimSpace(branch) == "" {
-		// Fallback: try alternative method
-		branch, err = executeGitCommand("rev-parse", "--abbrev-ref", "HEAD")
-		if err != nil {
-			resp := Response{
-				Error: fmt.Sprintf("Failed to get branch: %v", err),
-				Log:   logs,
-			}
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(resp)
-			return
+	branch, err := gitBackend.CurrentBranch(repo)
+	if err != nil {
+		resp := Response{
+			Error: fmt.Sprintf("Failed to get branch: %v", err),
+			Log:   logs,
 		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(resp)
+		return
 	}
 
-	branch = strings.TrimSpace(branch)
+	// The mode query parameter overrides the repository's pull mode for this request
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = getRepoSettings(repo).PullMode
+	} else if !pullModes[mode] {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "mode must be merge, rebase or ff-only",
+		})
+		return
+	}
 
-	// git pull [remote] [branch]
-	output, err := executeGitCommand("pull", remote, branch)
-	logs = append(logs, fmt.Sprintf("$ git pull %s %s", remote, branch))
+	// git pull [--no-rebase|--rebase|--ff-only] [remote] [branch]
+	output, err := gitBackend.Pull(repo, remote, branch, mode)
+	logs = append(logs, "$ git "+strings.Join(pullArgs(remote, branch, mode), " "))
 	if output != "" {
 		logs = append(logs, output)
 	}
 	if err != nil {
+		if strings.Contains(output, "Not possible to fast-forward") {
+			resp := Response{
+				Error: "Cannot fast-forward: the local and remote branches have diverged",
+				Log:   logs,
+			}
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		// Check for merge conflicts
 		if strings.Contains(output, "CONFLICT") {
-			conflictFiles := getConflictFiles()
+			conflictFiles := getConflictFiles(repo)
 			logs = append(logs, fmt.Sprintf("⚠ Merge conflict detected in %d file(s)", len(conflictFiles)))
 			logs = append(logs, "Attempting automatic conflict resolution...")
-			
-			// Try to resolve conflicts automatically
-			resolved, resolveErr := autoResolveConflicts(conflictFiles)
+
+			// Try the default conflict strategies (identical, whitespace-only, union for changelogs)
+			resolved, remaining, resolveErr := applyConflictStrategies(repo, conflictFiles, nil)
 			if resolveErr != nil {
 				logs = append(logs, fmt.Sprintf("✗ Automatic resolution failed: %v", resolveErr))
-				logs = append(logs, "Files with conflicts:")
+				remaining = conflictFiles
+			}
+			if len(resolved) > 0 {
+				logs = append(logs, fmt.Sprintf("✓ Auto-resolved %d file(s):", len(resolved)))
 				for _, file := range conflictFiles {
+					if strategy, ok := resolved[file]; ok {
+						logs = append(logs, fmt.Sprintf("  - %s (%s)", file, strategy))
+					}
+				}
+			}
+
+			if len(remaining) > 0 {
+				logs = append(logs, "Files with conflicts:")
+				for _, file := range remaining {
 					logs = append(logs, fmt.Sprintf("  - %s", file))
 				}
 				resp := Response{
@@ -625,43 +912,43 @@ func handlePull(w http.ResponseWriter, r *http.Request) {
 				json.NewEncoder(w).Encode(resp)
 				return
 			}
-			
-			if len(resolved) > 0 {
-				logs = append(logs, fmt.Sprintf("✓ Auto-resolved %d file(s):", len(resolved)))
-				for _, file := range resolved {
-					logs = append(logs, fmt.Sprintf("  - %s", file))
-				}
-				
-				// Commit the resolved changes
-				commitMsg := fmt.Sprintf("Merge %s/%s with auto-resolved conflicts", remote, branch)
-				commitOutput, commitErr := executeGitCommand("commit", "-m", commitMsg)
-				logs = append(logs, "$ git commit -m \""+commitMsg+"\"")
-				if commitOutput != "" {
-					logs = append(logs, commitOutput)
-				}
+
+			var commitErr error
+			if state, _ := getOperationState(repo); state.Operation == "rebase" {
+				// Continue the rebase; it may stop again at a later commit
+				logs, _, commitErr = runOperationGit(repo, logs, "rebase", "--continue")
 				if commitErr != nil {
 					resp := Response{
-						Error: fmt.Sprintf("Failed to commit resolved conflicts: %v", commitErr),
+						Error: "Rebase stopped with conflicts; resolve them, then continue the rebase",
 						Log:   logs,
 					}
-					w.WriteHeader(http.StatusInternalServerError)
+					w.WriteHeader(http.StatusConflict)
 					json.NewEncoder(w).Encode(resp)
 					return
 				}
-				logs = append(logs, "✓ Pull successful with auto-resolved conflicts!")
 			} else {
-				logs = append(logs, "No conflicts could be auto-resolved")
+				// Commit the resolved changes
+				commitMsg := fmt.Sprintf("Merge %s/%s with auto-resolved conflicts", remote, branch)
+				var commitOutput string
+				commitOutput, commitErr = executeGitCommand(repo, "commit", "-m", commitMsg)
+				logs = append(logs, "$ git commit -m \""+commitMsg+"\"")
+				if commitOutput != "" {
+					logs = append(logs, commitOutput)
+				}
+			}
+			if commitErr != nil {
 				resp := Response{
-					Error: "Merge conflict requires manual resolution",
+					Error: fmt.Sprintf("Failed to commit resolved conflicts: %v", commitErr),
 					Log:   logs,
 				}
-				w.WriteHeader(http.StatusConflict)
+				w.WriteHeader(http.StatusInternalServerError)
 				json.NewEncoder(w).Encode(resp)
 				return
 			}
+			logs = append(logs, "✓ Pull successful with auto-resolved conflicts!")
 		} else {
 			resp := Response{
-				Error: fmt.Sprintf("git pull failed: %v", err),
+				Error: fmt.Sprintf("git pull failed: %v", lfsError(output, err)),
 				Log:   logs,
 			}
 			w.WriteHeader(http.StatusInternalServerError)
@@ -672,33 +959,44 @@ func handlePull(w http.ResponseWriter, r *http.Request) {
 		logs = append(logs, "✓ Pull successful!")
 	}
 
-	json.NewEncoder(w).Encode(Response{
-		Branch: branch,
-		Log:    logs,
-	})
-}
-
-func handleListRepos(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	// Replace pointer files with their LFS content
+	if usesLFS(repo) {
+		logs, err = runLFS(repo, logs, "pull", remote)
+		if errors.Is(err, errLFSNotInstalled) {
+			logs = append(logs, fmt.Sprintf("⚠ %v; LFS files are left as pointer files", err))
+		} else if err != nil {
+			resp := Response{
+				Error: fmt.Sprintf("Pulled, but downloading LFS objects failed: %v", err),
+				Log:   logs,
+			}
+			w.WriteHeader(lfsErrorStatus(err))
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
 
-	log.Printf("ListRepos: scanning path=%s", baseRepoPath)
-	repos, err := listRepositories(baseRepoPath)
-	if err != nil {
-		log.Printf("ListRepos error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": fmt.Sprintf("Failed to list repos: %v", err),
-		})
-		return
+	// Check out the submodule commits recorded by the pulled history
+	if recurseSubmodules(repo, r.URL.Query().Get("submodules")) {
+		logs, err = updateSubmodules(repo, logs, nil, false)
+		if err != nil {
+			resp := Response{
+				Error: fmt.Sprintf("Pulled, but updating submodules failed: %v", err),
+				Log:   logs,
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
 	}
 
-	log.Printf("ListRepos: found %d repositories", len(repos))
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"repositories": repos,
+	json.NewEncoder(w).Encode(Response{
+		Branch: branch,
+		Log:    logs,
 	})
 }
 
-func handleSelectRepo(w http.ResponseWriter, r *http.Request) {
+// handleFetch updates remote-tracking refs without touching the working tree
+func handleFetch(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -706,66 +1004,78 @@ func handleSelectRepo(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	var req struct {
-		RelativePath string `json:"relativePath"`
+	query := r.URL.Query()
+	remote := query.Get("remote")
+	if remote == "" {
+		remote = "origin"
 	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if !isValidRef(remote) {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid request body",
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid remote",
 		})
 		return
 	}
 
-	if req.RelativePath == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Relative path is required",
-		})
+	repo, ok := requireRepo(w, r)
+	if !ok {
 		return
 	}
 
-	// Resolve the relative path safely to prevent directory traversal
-	resolvedPath := filepath.Join(baseRepoPath, req.RelativePath)
-	resolvedPath, err := filepath.Abs(resolvedPath)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid path",
-		})
-		return
+	opts := FetchOptions{
+		All:   query.Get("all") == "true",
+		Prune: query.Get("prune") == "true",
+		Tags:  query.Get("tags") == "true",
 	}
 
-	// Check that resolved path is within the base repo path
-	basePath, _ := filepath.Abs(baseRepoPath)
-	if !strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) && resolvedPath != basePath {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Path traversal not allowed",
-		})
+	var logs []string
+	output, err := gitBackend.Fetch(repo, remote, opts)
+	logs = append(logs, "$ git "+strings.Join(fetchArgs(remote, opts), " "))
+	if output != "" {
+		logs = append(logs, output)
+	}
+	if err != nil {
+		resp := Response{
+			Error: fmt.Sprintf("git fetch failed: %v", err),
+			Log:   logs,
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(resp)
 		return
 	}
+	logs = append(logs, "✓ Fetch successful!")
 
-	config.RepoPath = resolvedPath
+	branch, _ := gitBackend.CurrentBranch(repo)
+	ahead, behind := gitBackend.AheadBehind(repo, branch)
+	json.NewEncoder(w).Encode(Response{
+		Branch: branch,
+		Ahead:  ahead,
+		Behind: behind,
+		Log:    logs,
+	})
+}
 
-	// Load status after changing repo
-	branch, err := executeGitCommand("branch", "--show-current")
-	if err != nil || strings.TrimSpace(branch) == "" {
-		// Fallback: try alternative method
-		branch, err = executeGitCommand("rev-parse", "--abbrev-ref", "HEAD")
-		if err != nil {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": fmt.Sprintf("Failed to get branch: %v", err),
-			})
-			return
-		}
+func handleListRepos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	log.Printf("ListRepos: scanning path=%s", baseRepoPath)
+	repos, err := listRepositories(baseRepoPath)
+	if err != nil {
+		log.Printf("ListRepos error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("Failed to list repos: %v", err),
+		})
+		return
 	}
 
-	branch = strings.TrimSpace(branch)
+	if !config.AuthDisabled {
+		repos = filterRepositories(repos, currentUser(r))
+	}
 
+	log.Printf("ListRepos: found %d repositories", len(repos))
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"branch": branch,
+		"repositories": repos,
 	})
 }
 
@@ -777,35 +1087,15 @@ func handleCreateBranch(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
 	var req struct {
 		BranchName string `json:"branchName"`
 		Checkout   bool   `json:"checkout"`
+		StartPoint string `json:"startPoint"` // branch, tag or commit to start from; HEAD if empty
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -823,19 +1113,42 @@ func handleCreateBranch(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if !checkBranchName(repo, req.BranchName) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid branch name",
+		})
+		return
+	}
+	if req.StartPoint != "" {
+		valid := isValidRef(req.StartPoint)
+		if valid {
+			_, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", req.StartPoint+"^{commit}")
+			valid = err == nil
+		}
+		if !valid {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Start point not found: %s", req.StartPoint),
+			})
+			return
+		}
+	}
 
 	var logs []string
 	var args []string
 
 	if req.Checkout {
 		args = []string{"checkout", "-b", req.BranchName}
-		logs = append(logs, fmt.Sprintf("$ git checkout -b %s", req.BranchName))
 	} else {
 		args = []string{"branch", req.BranchName}
-		logs = append(logs, fmt.Sprintf("$ git branch %s", req.BranchName))
 	}
+	if req.StartPoint != "" {
+		args = append(args, req.StartPoint)
+	}
+	logs = append(logs, "$ git "+strings.Join(args, " "))
 
-	output, err := executeGitCommand(args...)
+	output, err := executeGitCommand(repo, args...)
 	if output != "" {
 		logs = append(logs, output)
 	}
@@ -861,9 +1174,17 @@ func handleCreateBranch(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func executeGitCommand(args ...string) (string, error) {
+func executeGitCommand(repo Repo, args ...string) (string, error) {
+	return executeGitCommandEnv(repo, nil, args...)
+}
+
+// executeGitCommandEnv is executeGitCommand with extra environment variables
+func executeGitCommandEnv(repo Repo, env []string, args ...string) (string, error) {
 	cmd := exec.Command("git", args...)
-	cmd.Dir = config.RepoPath
+	cmd.Dir = repo.Path
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	var output bytes.Buffer
 	cmd.Stdout = &output
@@ -885,18 +1206,20 @@ func executeGitCommand(args ...string) (string, error) {
 	return result, nil
 }
 
-func getAheadBehind(branch string) (int, int) {
+func getAheadBehind(repo Repo, branch string) (int, int) {
 	// Try to get the tracking branch
-	trackingBranch, err := executeGitCommand("rev-parse", "--abbrev-ref", branch+"@{u}")
+	trackingBranch, err := executeGitCommand(repo, "rev-parse", "--abbrev-ref", branch+"@{u}")
 	if err != nil || strings.TrimSpace(trackingBranch) == "" {
 		// No tracking branch configured
 		return 0, 0
 	}
 
-	trackingBranch = strings.TrimSpace(trackingBranch)
+	return countAheadBehind(repo, branch, strings.TrimSpace(trackingBranch))
+}
 
-	// Get ahead/behind count
-	output, err := executeGitCommand("rev-list", "--left-right", "--count", branch+"..."+trackingBranch)
+// countAheadBehind counts the commits only on left (ahead) and only on right (behind)
+func countAheadBehind(repo Repo, left, right string) (int, int) {
+	output, err := executeGitCommand(repo, "rev-list", "--left-right", "--count", left+"..."+right)
 	if err != nil {
 		return 0, 0
 	}
@@ -913,8 +1236,8 @@ func getAheadBehind(branch string) (int, int) {
 }
 
 // getConflictFiles returns a list of files with merge conflicts
-func getConflictFiles() []string {
-	output, err := executeGitCommand("diff", "--name-only", "--diff-filter=U")
+func getConflictFiles(repo Repo) []string {
+	output, err := executeGitCommand(repo, "diff", "--name-only", "--diff-filter=U")
 	if err != nil {
 		return []string{}
 	}
@@ -929,99 +1252,6 @@ func getConflictFiles() []string {
 	return files
 }
 
-// autoResolveConflicts attempts to automatically resolve merge conflicts
-// Returns the list of successfully resolved files
-func autoResolveConflicts(conflictFiles []string) ([]string, error) {
-	var resolved []string
-	
-	for _, file := range conflictFiles {
-		filePath := filepath.Join(config.RepoPath, file)
-		
-		// Read the conflicted file
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			continue
-		}
-		
-		// Check if the conflict can be auto-resolved
-		// Simple strategy: if one side is empty or only whitespace, use the other side
-		lines := strings.Split(string(content), "\n")
-		var newContent strings.Builder
-		inConflict := false
-		oursContent := ""
-		theirsContent := ""
-		conflictStart := -1
-		
-		for i, line := range lines {
-			if strings.HasPrefix(line, "<<<<<<<") {
-				inConflict = true
-				conflictStart = i
-				oursContent = ""
-				theirsContent = ""
-			} else if strings.HasPrefix(line, "=======") && inConflict {
-				// Switch from ours to theirs
-			} else if strings.HasPrefix(line, ">>>>>>>") && inConflict {
-				inConflict = false
-				
-				// Try to resolve
-				oursEmpty := strings.TrimSpace(oursContent) == ""
-				theirsEmpty := strings.TrimSpace(theirsContent) == ""
-				
-				if oursEmpty && !theirsEmpty {
-					// Use theirs
-					newContent.WriteString(theirsContent)
-				} else if theirsEmpty && !oursEmpty {
-					// Use ours
-					newContent.WriteString(oursContent)
-				} else if oursContent == theirsContent {
-					// Both sides are the same
-					newContent.WriteString(oursContent)
-				} else {
-					// Cannot auto-resolve, restore conflict markers
-					newContent.WriteString("<<<<<<<\n")
-					newContent.WriteString(oursContent)
-					newContent.WriteString("=======\n")
-					newContent.WriteString(theirsContent)
-					newContent.WriteString(">>>>>>>\n")
-				}
-			} else if inConflict {
-				// Collect content
-				if oursContent != "" || !strings.HasPrefix(line, "=======") {
-					if strings.HasPrefix(strings.Join(lines[conflictStart:i], "\n"), "<<<<<<<") && !strings.Contains(strings.Join(lines[conflictStart:i], "\n"), "=======") {
-						oursContent += line + "\n"
-					} else {
-						theirsContent += line + "\n"
-					}
-				}
-			} else {
-				newContent.WriteString(line + "\n")
-			}
-		}
-		
-		// Check if all conflicts were resolved
-		finalContent := newContent.String()
-		if !strings.Contains(finalContent, "<<<<<<<") && !strings.Contains(finalContent, "=======") && !strings.Contains(finalContent, ">>>>>>>") {
-			// Write the resolved content
-			if err := os.WriteFile(filePath, []byte(finalContent), 0644); err != nil {
-				continue
-			}
-			
-			// Stage the resolved file
-			if _, err := executeGitCommand("add", file); err != nil {
-				continue
-			}
-			
-			resolved = append(resolved, file)
-		}
-	}
-	
-	if len(resolved) == 0 && len(conflictFiles) > 0 {
-		return resolved, fmt.Errorf("no conflicts could be automatically resolved")
-	}
-	
-	return resolved, nil
-}
-
 func listRepositories(basePath string) ([]Repository, error) {
 	var repos []Repository
 
@@ -1058,13 +1288,20 @@ func listRepositories(basePath string) ([]Repository, error) {
 			}
 
 			repos = append(repos, Repository{
-				Name: name,
-				Path: relPath,
+				Name:       name,
+				Path:       relPath,
+				Worktrees:  listWorktreeRepositories(repoPath),
+				Submodules: listSubmoduleRepositories(Repo{Path: repoPath}),
 			})
 
 			return filepath.SkipDir
 		}
 
+		// A .git file marks a linked worktree (or submodule), which is listed with its main repository
+		if info.Name() == ".git" && info.Mode().IsRegular() {
+			return filepath.SkipDir
+		}
+
 		// Skip hidden directories (except we already handled .git)
 		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
 			return filepath.SkipDir
@@ -1085,40 +1322,88 @@ func listRepositories(basePath string) ([]Repository, error) {
 	return repos, nil
 }
 
-func handleListBranches(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	repoPath := r.URL.Query().Get("repoPath")
+// listWorktreeRepositories returns the linked worktrees of the repository at repoPath that can be opened
+func listWorktreeRepositories(repoPath string) []Repository {
+	if _, err := os.Stat(filepath.Join(repoPath, ".git", "worktrees")); err != nil {
+		return nil
+	}
+	worktrees, err := gitBackend.Worktrees(Repo{Path: repoPath})
+	if err != nil || len(worktrees) == 0 {
+		return nil
+	}
 
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
+	var repos []Repository
+	for _, worktree := range worktrees[1:] {
+		relPath, ok := relativeToBase(worktree.Path)
+		if !ok || worktree.Prunable {
+			continue
 		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
+		branch := worktree.Branch
+		if worktree.Detached && len(worktree.Head) >= 7 {
+			branch = worktree.Head[:7]
 		}
+		repos = append(repos, Repository{
+			Name:   filepath.Base(worktree.Path),
+			Path:   relPath,
+			Branch: branch,
+		})
 	}
+	return repos
+}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
+// listSubmoduleRepositories returns the checked-out submodules of repo, recursively
+func listSubmoduleRepositories(repo Repo) []Repository {
+	if !hasSubmodules(repo) {
+		return nil
+	}
+
+	var repos []Repository
+	for path, sub := range readGitmodules(repo) {
+		subRepo := Repo{Path: filepath.Join(repo.Path, path)}
+		relPath, ok := relativeToBase(subRepo.Path)
+		if !ok || !isGitRepo(subRepo.Path) {
+			continue
+		}
+		repos = append(repos, Repository{
+			Name:       sub.Name,
+			Path:       relPath,
+			Submodules: listSubmoduleRepositories(subRepo),
+		})
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Path < repos[j].Path })
+	return repos
+}
+
+func handleListBranches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-	log.Printf("handleListBranches: RepoPath=%s", config.RepoPath)
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	log.Printf("handleListBranches: RepoPath=%s", repo.Path)
+
+	// details=true adds upstream, ahead/behind, merge status and last commit per branch
+	if r.URL.Query().Get("details") == "true" {
+		details, defaultBranch, err := getBranchDetails(repo)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to list branches: %v", err),
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"branches":      details,
+			"defaultBranch": defaultBranch,
+		})
+		return
+	}
 
-	output, err := executeGitCommand("branch", "-a")
+	branches, err := gitBackend.Branches(repo)
 	if err != nil {
-		log.Printf("handleListBranches error: %v, output=%s", err, output)
+		log.Printf("handleListBranches error: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(Response{
 			Error: fmt.Sprintf("Failed to list branches: %v", err),
@@ -1126,22 +1411,6 @@ func handleListBranches(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var branches []string
-	branchMap := make(map[string]bool)
-	for _, line := range strings.Split(output, "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		line = strings.TrimPrefix(line, "* ")
-		line = strings.TrimPrefix(line, "remotes/")
-		if branchMap[line] {
-			continue
-		}
-		branchMap[line] = true
-		branches = append(branches, line)
-	}
-
 	json.NewEncoder(w).Encode(Response{
 		Branches: branches,
 	})
@@ -1155,34 +1424,15 @@ func handleCheckoutBranch(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
 	var req struct {
-		Branch string `json:"branch"`
+		Branch     string `json:"branch"`
+		Stash      bool   `json:"stash"`      // stash local changes, switch, then re-apply them
+		Submodules *bool  `json:"submodules"` // update submodules; defaults to the repository setting
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1200,184 +1450,213 @@ func handleCheckoutBranch(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if !isValidRef(req.Branch) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid branch name",
+		})
+		return
+	}
 
-	output, err := executeGitCommand("checkout", req.Branch)
+	// A remote-tracking branch is checked out through a local branch tracking it
+	// rather than as a detached HEAD
+	checkoutArgs := []string{"checkout", req.Branch}
+	local, create, err := trackingBranchFor(repo, req.Branch)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusConflict)
 		json.NewEncoder(w).Encode(Response{
-			Error: fmt.Sprintf("Failed to checkout branch: %v", err),
-			Log:   []string{output},
+			Error: err.Error(),
 		})
 		return
 	}
+	if create {
+		checkoutArgs = []string{"checkout", "-b", local, "--track", req.Branch}
+	} else if local != "" {
+		checkoutArgs = []string{"checkout", local}
+	}
 
-	branch, _ := executeGitCommand("branch", "--show-current")
-	branch = strings.TrimSpace(branch)
-
-	ahead, behind := getAheadBehind(branch)
-
-	json.NewEncoder(w).Encode(Response{
-		Branch: branch,
-		Ahead:  ahead,
-		Behind: behind,
-		Log:    []string{fmt.Sprintf("Switched to branch: %s", branch)},
-	})
-}
-
-func handleLoadRepo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Method == http.MethodPost {
-		// POST: select a repository from the request body
-		var req struct {
-			RelativePath string `json:"relativePath"`
-		}
-
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Invalid request body",
+	var logs []string
+	// The stash commit made for the switch; changes only inside submodules
+	// look dirty but leave nothing to stash
+	stashed := ""
+	if req.Stash {
+		dirty, err := isWorkingTreeDirty(repo)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to check working tree: %v", err),
 			})
 			return
 		}
-
-		if req.RelativePath == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Relative path is required",
-			})
-			return
+		if dirty {
+			previous := latestStash(repo)
+			var err error
+			logs, _, err = runLoggedGit(repo, logs, "stash", "push", "--include-untracked", "-m", "AirGit: switching to "+req.Branch)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(Response{
+					Error: fmt.Sprintf("Failed to stash local changes: %v", err),
+					Log:   logs,
+				})
+				return
+			}
+			if latest := latestStash(repo); latest != previous {
+				stashed = latest
+			}
 		}
+	}
 
-		// Resolve the relative path safely to prevent directory traversal
-		resolvedPath := filepath.Join(baseRepoPath, req.RelativePath)
-		resolvedPath, err := filepath.Abs(resolvedPath)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Invalid path",
-			})
-			return
+	output, err := executeGitCommand(repo, checkoutArgs...)
+	if err != nil {
+		logs = append(logs, output)
+		if ref, ok := findStash(repo, stashed); stashed != "" && ok {
+			// Put the changes back where they came from
+			logs, _, _ = runLoggedGit(repo, logs, "stash", "pop", "--index", ref)
 		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to checkout branch: %v", err),
+			Log:   logs,
+		})
+		return
+	}
 
-		// Check that resolved path is within the base repo path
-		basePath, _ := filepath.Abs(baseRepoPath)
-		if !strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) && resolvedPath != basePath {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Path traversal not allowed",
-			})
-			return
-		}
+	branch, _ := executeGitCommand(repo, "branch", "--show-current")
+	branch = strings.TrimSpace(branch)
 
-		config.RepoPath = resolvedPath
-	} else {
-		// GET: load repository and branch from query parameters
-		relativePath := r.URL.Query().Get("p")
-		branch := r.URL.Query().Get("b")
-
-		// If relativePath is provided, update repo path safely
-		if relativePath != "" {
-			// Resolve the relative path safely to prevent directory traversal
-			resolvedPath := filepath.Join(baseRepoPath, relativePath)
-			resolvedPath, err := filepath.Abs(resolvedPath)
-			if err == nil {
-				// Check that resolved path is within the base repo path
-				basePath, _ := filepath.Abs(baseRepoPath)
-				if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-					config.RepoPath = resolvedPath
-				}
+	ahead, behind := gitBackend.AheadBehind(repo, branch)
+	if create {
+		logs = append(logs, fmt.Sprintf("Created branch %s tracking %s", branch, req.Branch))
+	}
+	logs = append(logs, fmt.Sprintf("Switched to branch: %s", branch))
+
+	if stashed != "" {
+		ref, ok := findStash(repo, stashed)
+		var popOutput string
+		if ok {
+			logs, popOutput, err = runLoggedGit(repo, logs, "stash", "pop", "--index", ref)
+			if err != nil && strings.Contains(popOutput, "--index") {
+				// The staged changes no longer apply cleanly as staged; restore them unstaged
+				logs, popOutput, err = runLoggedGit(repo, logs, "stash", "pop", ref)
 			}
+		} else {
+			err = fmt.Errorf("stash %s no longer exists", stashed)
 		}
-
-		// If branch is provided, checkout that branch
-		if branch != "" {
-			_, _ = executeGitCommand("checkout", branch)
+		if err != nil {
+			message := fmt.Sprintf("Switched to %s, but re-applying local changes failed: %v", branch, err)
+			if strings.Contains(popOutput, "CONFLICT") {
+				message = fmt.Sprintf("Switched to %s, but local changes conflict with it; resolve the conflicts (the stash was kept)", branch)
+			}
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{
+				Branch: branch,
+				Ahead:  ahead,
+				Behind: behind,
+				Error:  message,
+				Log:    logs,
+			})
+			return
 		}
+		logs = append(logs, "✓ Local changes re-applied")
 	}
 
-	// Get current branch
-	currentBranch, err := executeGitCommand("branch", "--show-current")
-	if err != nil || strings.TrimSpace(currentBranch) == "" {
-		currentBranch, err = executeGitCommand("rev-parse", "--abbrev-ref", "HEAD")
+	override := ""
+	if req.Submodules != nil {
+		override = fmt.Sprint(*req.Submodules)
+	}
+	if recurseSubmodules(repo, override) {
+		logs, err = updateSubmodules(repo, logs, nil, false)
 		if err != nil {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": fmt.Sprintf("Failed to get branch: %v", err),
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Branch: branch,
+				Ahead:  ahead,
+				Behind: behind,
+				Error:  fmt.Sprintf("Switched to %s, but updating submodules failed: %v", branch, err),
+				Log:    logs,
 			})
 			return
 		}
 	}
 
-	currentBranch = strings.TrimSpace(currentBranch)
-
-	// Get repository name from the directory name
-	repoName := filepath.Base(config.RepoPath)
-
-	// Get ahead/behind count
-	ahead, behind := getAheadBehind(currentBranch)
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"branch":   currentBranch,
-		"repoName": repoName,
-		"ahead":    ahead,
-		"behind":   behind,
+	json.NewEncoder(w).Encode(Response{
+		Branch: branch,
+		Ahead:  ahead,
+		Behind: behind,
+		Log:    logs,
 	})
 }
 
-func handleInit(w http.ResponseWriter, r *http.Request) {
+// handleLoadRepo selects the repository used by requests without repoPath,
+// optionally checking out a branch in it. It only accepts POST since it changes state.
+func handleLoadRepo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
-	relativePath := r.URL.Query().Get("relativePath")
-	branch := r.URL.Query().Get("branch")
-
-	// If relativePath is provided, update repo path safely
-	if relativePath != "" {
-		// Resolve the relative path safely to prevent directory traversal
-		resolvedPath := filepath.Join(baseRepoPath, relativePath)
-		resolvedPath, err := filepath.Abs(resolvedPath)
-		if err == nil {
-			// Check that resolved path is within the base repo path
-			basePath, _ := filepath.Abs(baseRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	// The repository the request was authorized for, resolved safely from
+	// relativePath to prevent directory traversal
+	repo, repoOK := authorizedRepo(r)
+
+	var req struct {
+		RelativePath string `json:"relativePath"` // the selected repository if empty
+		Branch       string `json:"branch"`       // branch to check out, if any
 	}
 
-	// Get current branch
-	currentBranch, err := executeGitCommand("branch", "--show-current")
-	if err != nil || strings.TrimSpace(currentBranch) == "" {
-		currentBranch, err = executeGitCommand("rev-parse", "--abbrev-ref", "HEAD")
-		if err != nil {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": fmt.Sprintf("Failed to get branch: %v", err),
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	if !repoOK {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Invalid repository path",
+		})
+		return
+	}
+	if req.RelativePath != "" && req.RelativePath != "/" {
+		setSelectedRepo(repo)
+	}
+
+	// If branch is provided, checkout that branch
+	if req.Branch != "" {
+		if !isValidRef(req.Branch) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Invalid branch name",
 			})
 			return
 		}
+		_, _ = executeGitCommand(repo, "checkout", req.Branch)
+	}
+
+	// Get current branch
+	currentBranch, err := gitBackend.CurrentBranch(repo)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("Failed to get branch: %v", err),
+		})
+		return
 	}
 
-	currentBranch = strings.TrimSpace(currentBranch)
-
-	// If branch is provided and different from current, checkout that branch
-	if branch != "" && branch != currentBranch {
-		output, err := executeGitCommand("checkout", branch)
-		if err != nil {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error":  fmt.Sprintf("Failed to checkout branch '%s': %v. Output: %s", branch, err, output),
-				"branch": currentBranch,
-			})
-			return
-		}
-		currentBranch = branch
-	}
+	// Get repository name from the directory name
+	repoName := repo.Name()
 
 	// Get ahead/behind count
-	ahead, behind := getAheadBehind(currentBranch)
+	ahead, behind := gitBackend.AheadBehind(repo, currentBranch)
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"branch": currentBranch,
-		"ahead":  ahead,
-		"behind": behind,
+		"branch":   currentBranch,
+		"repoName": repoName,
+		"ahead":    ahead,
+		"behind":   behind,
 	})
 }
 
@@ -1467,17 +1746,13 @@ func handleCreateRepo(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Change to the new directory and initialize git repository
-	originalRepoPath := config.RepoPath
-	config.RepoPath = resolvedPath
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
+	// Initialize git repository in the new directory
+	repo := Repo{Path: resolvedPath}
 
 	var logs []string
 
 	// Initialize git repository
-	output, err := executeGitCommand("init")
+	output, err := executeGitCommand(repo, "init")
 	logs = append(logs, "$ git init")
 	if output != "" {
 		logs = append(logs, output)
@@ -1568,17 +1843,13 @@ func handleInitRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Change to the directory and initialize git repository
-	originalRepoPath := config.RepoPath
-	config.RepoPath = resolvedPath
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
+	// Initialize git repository in the directory
+	repo := Repo{Path: resolvedPath}
 
 	var logs []string
 
 	// Initialize git repository
-	output, err := executeGitCommand("init")
+	output, err := executeGitCommand(repo, "init")
 	logs = append(logs, "$ git init")
 	if output != "" {
 		logs = append(logs, output)
@@ -1604,33 +1875,12 @@ func handleInitRepo(w http.ResponseWriter, r *http.Request) {
 func handleListRemotes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
-	output, err := executeGitCommand("remote", "-v")
+	remotes, err := gitBackend.Remotes(repo)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(Response{
@@ -1639,32 +1889,6 @@ func handleListRemotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var remotes []RemoteInfo
-	remoteMap := make(map[string]string)
-
-	for _, line := range strings.Split(output, "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			name := parts[0]
-			url := parts[1]
-			if remoteMap[name] == "" {
-				remoteMap[name] = url
-			}
-		}
-	}
-
-	for name, url := range remoteMap {
-		remotes = append(remotes, RemoteInfo{
-			Name: name,
-			URL:  url,
-		})
-	}
-
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"remotes": remotes,
 	})
@@ -1678,32 +1902,11 @@ func handleAddRemote(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
 	var req struct {
 		Name string `json:"name"`
 		URL  string `json:"url"`
@@ -1725,7 +1928,7 @@ func handleAddRemote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := executeGitCommand("remote", "add", req.Name, req.URL)
+	_, err := executeGitCommand(repo, "remote", "add", req.Name, req.URL)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(Response{
@@ -1748,32 +1951,11 @@ func handleUpdateRemote(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
 	var req struct {
 		Name string `json:"name"`
 		URL  string `json:"url"`
@@ -1795,7 +1977,7 @@ func handleUpdateRemote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := executeGitCommand("remote", "set-url", req.Name, req.URL)
+	_, err := executeGitCommand(repo, "remote", "set-url", req.Name, req.URL)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(Response{
@@ -1818,32 +2000,11 @@ func handleRemoveRemote(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
 	var req struct {
 		Name string `json:"name"`
 	}
@@ -1864,7 +2025,7 @@ func handleRemoveRemote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := executeGitCommand("remote", "remove", req.Name)
+	_, err := executeGitCommand(repo, "remote", "remove", req.Name)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(Response{
@@ -2199,39 +2360,98 @@ func handleSystemdRebuildRestart(w http.ResponseWriter, r *http.Request) {
 func handleListCommits(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-	limit := r.URL.Query().Get("limit")
-	if limit == "" {
-		limit = "20"
+	query := r.URL.Query()
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxHistoryLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit),
+			})
+			return
+		}
 	}
 
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	opts := LogOptions{
+		Rev:          query.Get("ref"),
+		All:          query.Get("all") == "true",
+		Author:       query.Get("author"),
+		Grep:         query.Get("grep"),
+		Paths:        query["path"],
+		Pickaxe:      query.Get("S"),
+		PickaxeRegex: query.Get("G"),
+		Follow:       query.Get("follow") == "true",
+	}
+	for i, p := range opts.Paths {
+		resolved, ok := resolveRepoFilePath(repo, p)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Invalid path",
+			})
+			return
 		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
+		opts.Paths[i] = resolved
+	}
+	if opts.Follow && len(opts.Paths) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "follow requires exactly one path",
+		})
+		return
+	}
+	if opts.Rev != "" && !isValidRef(opts.Rev) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid ref",
+		})
+		return
+	}
+	for param, target := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		if value := query.Get(param); value != "" {
+			t, date, err := parseHistoryDate(value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Response{
+					Error: fmt.Sprintf("Invalid %s date (use YYYY-MM-DD or RFC 3339)", param),
+				})
+				return
 			}
+			if date && param == "until" {
+				// Up to the end of that day; git compares whole seconds and includes the bound
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			*target = t
 		}
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
+	// Fetch one commit past the page to know whether there is more and to route
+	// the graph edges of the last row. A cursor re-reads the previous page's
+	// last commit to make sure history has not moved underneath the client.
+	var cursor historyCursor
+	opts.Limit = limit + 1
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = decodeHistoryCursor(cursorStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Invalid cursor",
+			})
+			return
+		}
+		opts.Skip = cursor.Skip - 1
+		opts.Limit = limit + 2
+	}
 
-	// Get commits using git log
-	format := "%H%n%an%n%ai%n%s%n---END---"
-	output, err := executeGitCommand("log", "-"+limit, "--format="+format)
+	commits, err := gitBackend.Log(repo, opts)
 	if err != nil {
 		json.NewEncoder(w).Encode(Response{
 			Error: fmt.Sprintf("Failed to get commits: %v", err),
@@ -2239,16 +2459,53 @@ func handleListCommits(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	commits := parseCommits(output)
+	if cursor.Last != "" {
+		if len(commits) == 0 || commits[0].Hash != cursor.Last {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{
+				Error: "History changed since the previous page was loaded; reload from the start",
+			})
+			return
+		}
+		commits = commits[1:]
+	}
+
+	var next *CommitInfo
+	if len(commits) > limit {
+		next = &commits[limit]
+		commits = commits[:limit]
+	}
 
-	json.NewEncoder(w).Encode(Response{
-		Commits: commits,
+	var lanes []string
+	if query.Get("graph") == "true" {
+		lanes = layoutGraph(commits, cursor.Lanes, next)
+	}
+
+	nextCursor := ""
+	if next != nil && len(commits) > 0 {
+		nextCursor = encodeHistoryCursor(historyCursor{
+			Skip:  cursor.Skip + len(commits),
+			Last:  commits[len(commits)-1].Hash,
+			Lanes: lanes,
+		})
+	}
+
+	if commits == nil {
+		commits = []CommitInfo{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"commits":    commits,
+		"nextCursor": nextCursor,
 	})
 }
 
+// commitLogFormat separates fields with \x1f and records with \x1e so that
+// multi-line commit bodies survive parsing
+const commitLogFormat = "%H%x1f%P%x1f%an%x1f%ai%x1f%s%x1f%b%x1e"
+
 func parseCommits(output string) []CommitInfo {
 	var commits []CommitInfo
-	entries := strings.Split(output, "---END---\n")
+	entries := strings.Split(output, "\x1e")
 
 	for _, entry := range entries {
 		entry = strings.TrimSpace(entry)
@@ -2256,16 +2513,20 @@ func parseCommits(output string) []CommitInfo {
 			continue
 		}
 
-		lines := strings.Split(entry, "\n")
-		if len(lines) < 4 {
+		fields := strings.Split(entry, "\x1f")
+		if len(fields) < 5 {
 			continue
 		}
 
 		commit := CommitInfo{
-			Hash:    lines[0],
-			Author:  lines[1],
-			Date:    lines[2],
-			Message: lines[3],
+			Hash:    fields[0],
+			Parents: strings.Fields(fields[1]),
+			Author:  fields[2],
+			Date:    fields[3],
+			Message: fields[4],
+		}
+		if len(fields) > 5 {
+			commit.Body = strings.TrimSpace(fields[5])
 		}
 		commits = append(commits, commit)
 	}
@@ -2276,33 +2537,28 @@ func parseCommits(output string) []CommitInfo {
 func handleListTags(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
 
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
+	// details=true adds the target commit, tagger and message of each tag, newest first
+	if r.URL.Query().Get("details") == "true" {
+		details, err := getTagDetails(repo)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to list tags: %v", err),
+			})
+			return
 		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tags": details,
+		})
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
-	output, err := executeGitCommand("tag", "-l")
+	tags, err := gitBackend.Tags(repo)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(Response{
@@ -2311,14 +2567,6 @@ func handleListTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var tags []string
-	for _, line := range strings.Split(output, "\n") {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			tags = append(tags, line)
-		}
-	}
-
 	json.NewEncoder(w).Encode(Response{
 		Tags: tags,
 	})
@@ -2332,9 +2580,15 @@ func handleCreateTag(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
 	var req struct {
 		TagName string `json:"tagName"`
 		Message string `json:"message"`
+		Commit  string `json:"commit"` // commit to tag; HEAD if empty
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -2352,6 +2606,27 @@ func handleCreateTag(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if _, err := executeGitCommand(repo, "check-ref-format", "refs/tags/"+req.TagName); !isValidRef(req.TagName) || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid tag name",
+		})
+		return
+	}
+	if req.Commit != "" {
+		valid := isValidRef(req.Commit)
+		if valid {
+			_, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", req.Commit+"^{commit}")
+			valid = err == nil
+		}
+		if !valid {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Commit not found: %s", req.Commit),
+			})
+			return
+		}
+	}
 
 	var logs []string
 	var args []string
@@ -2365,8 +2640,12 @@ func handleCreateTag(w http.ResponseWriter, r *http.Request) {
 		args = []string{"tag", req.TagName}
 		logs = append(logs, fmt.Sprintf("$ git tag %s", req.TagName))
 	}
+	if req.Commit != "" {
+		args = append(args, req.Commit)
+		logs[len(logs)-1] += " " + req.Commit
+	}
 
-	output, err = executeGitCommand(args...)
+	output, err = executeGitCommand(repo, args...)
 	if output != "" {
 		logs = append(logs, output)
 	}
@@ -2396,35 +2675,22 @@ func handlePushTag(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
 	remote := r.URL.Query().Get("remote")
 	if remote == "" {
 		remote = "origin"
 	}
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	if !isValidRef(remote) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid remote",
+		})
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
 
 	var req struct {
 		TagName string `json:"tagName"`
@@ -2444,7 +2710,7 @@ func handlePushTag(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if req.All {
-		output, err = executeGitCommand("push", remote, "--tags")
+		output, err = executeGitCommand(repo, "push", remote, "--tags")
 		logs = append(logs, fmt.Sprintf("$ git push %s --tags", remote))
 	} else {
 		if req.TagName == "" {
@@ -2454,7 +2720,7 @@ func handlePushTag(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
-		output, err = executeGitCommand("push", remote, req.TagName)
+		output, err = executeGitCommand(repo, "push", remote, req.TagName)
 		logs = append(logs, fmt.Sprintf("$ git push %s %s", remote, req.TagName))
 	}
 
@@ -2485,35 +2751,14 @@ func handlePushTag(w http.ResponseWriter, r *http.Request) {
 func handleListGitHubIssues(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
 	// Get GitHub remote URL
-	log.Printf("handleListGitHubIssues: Getting GitHub remote URL from: %s", config.RepoPath)
-	output, err := executeCommand("git", "config", "--get", "remote.origin.url")
+	log.Printf("handleListGitHubIssues: Getting GitHub remote URL from: %s", repo.Path)
+	output, err := executeCommand(repo, "git", "config", "--get", "remote.origin.url")
 	log.Printf("handleListGitHubIssues: git config result: output='%s', err=%v", output, err)
 	if err != nil || strings.TrimSpace(output) == "" {
 		w.WriteHeader(http.StatusNotFound)
@@ -2526,8 +2771,8 @@ func handleListGitHubIssues(w http.ResponseWriter, r *http.Request) {
 	remoteURL := strings.TrimSpace(output)
 
 	// Parse GitHub URL to extract owner/repo
-	owner, repo := parseGitHubURL(remoteURL)
-	if owner == "" || repo == "" {
+	owner, repoName := parseGitHubURL(remoteURL)
+	if owner == "" || repoName == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "Could not parse GitHub repository from remote URL: " + remoteURL,
@@ -2537,7 +2782,7 @@ func handleListGitHubIssues(w http.ResponseWriter, r *http.Request) {
 
 	// Fetch issues using gh CLI
 	cmd := exec.Command("gh", "issue", "list", "--json", "number,title,body,author,assignees", "-L", "50")
-	cmd.Dir = config.RepoPath
+	cmd.Dir = repo.Path
 	cmd.Env = append(os.Environ(), "GITHUB_TOKEN="+os.Getenv("GITHUB_TOKEN"))
 
 	var issuesOutput bytes.Buffer
@@ -2545,7 +2790,7 @@ func handleListGitHubIssues(w http.ResponseWriter, r *http.Request) {
 	cmd.Stdout = &issuesOutput
 	cmd.Stderr = &issuesError
 
-	log.Printf("gh command: cwd=%s, owner=%s, repo=%s", config.RepoPath, owner, repo)
+	log.Printf("gh command: cwd=%s, owner=%s, repo=%s", repo.Path, owner, repoName)
 
 	if err := cmd.Run(); err != nil {
 		errMsg := strings.TrimSpace(issuesError.String())
@@ -2554,7 +2799,7 @@ func handleListGitHubIssues(w http.ResponseWriter, r *http.Request) {
 		// Return error message to UI
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"owner":     owner,
-			"repo":      repo,
+			"repo":      repoName,
 			"remoteUrl": remoteURL,
 			"issues":    []interface{}{},
 			"error":     errMsg,
@@ -2577,7 +2822,7 @@ func handleListGitHubIssues(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"owner":     owner,
-		"repo":      repo,
+		"repo":      repoName,
 		"remoteUrl": remoteURL,
 		"issues":    issues,
 	})
@@ -2594,32 +2839,11 @@ func handleCreateGitHubIssue(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	repoPath := r.URL.Query().Get("repoPath")
-
-	// Use provided repoPath or fall back to config.RepoPath
-	originalRepoPath := config.RepoPath
-	if repoPath != "" {
-		// Resolve and validate the path
-		var resolvedPath string
-		var err error
-		if filepath.IsAbs(repoPath) {
-			resolvedPath = repoPath
-		} else {
-			resolvedPath = filepath.Join(originalRepoPath, repoPath)
-		}
-		resolvedPath, err = filepath.Abs(resolvedPath)
-		if err == nil {
-			basePath, _ := filepath.Abs(originalRepoPath)
-			if strings.HasPrefix(resolvedPath, basePath+string(filepath.Separator)) || resolvedPath == basePath {
-				config.RepoPath = resolvedPath
-			}
-		}
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
 	}
 
-	defer func() {
-		config.RepoPath = originalRepoPath
-	}()
-
 	var req struct {
 		Title  string   `json:"title"`
 		Body   string   `json:"body,omitempty"`
@@ -2643,7 +2867,7 @@ func handleCreateGitHubIssue(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get GitHub remote URL
-	output, err := executeCommand("git", "config", "--get", "remote.origin.url")
+	output, err := executeCommand(repo, "git", "config", "--get", "remote.origin.url")
 	if err != nil || strings.TrimSpace(output) == "" {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -2655,8 +2879,8 @@ func handleCreateGitHubIssue(w http.ResponseWriter, r *http.Request) {
 	remoteURL := strings.TrimSpace(output)
 
 	// Parse GitHub URL to extract owner/repo
-	owner, repo := parseGitHubURL(remoteURL)
-	if owner == "" || repo == "" {
+	owner, repoName := parseGitHubURL(remoteURL)
+	if owner == "" || repoName == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "Could not parse GitHub repository from remote URL: " + remoteURL,
@@ -2677,7 +2901,7 @@ func handleCreateGitHubIssue(w http.ResponseWriter, r *http.Request) {
 
 	// Create issue using gh CLI
 	cmd := exec.Command("gh", args...)
-	cmd.Dir = config.RepoPath
+	cmd.Dir = repo.Path
 	cmd.Env = os.Environ()
 
 	var issueOutput bytes.Buffer
@@ -2685,7 +2909,7 @@ func handleCreateGitHubIssue(w http.ResponseWriter, r *http.Request) {
 	cmd.Stdout = &issueOutput
 	cmd.Stderr = &issueError
 
-	log.Printf("Creating GitHub issue: title=%s, owner=%s, repo=%s", req.Title, owner, repo)
+	log.Printf("Creating GitHub issue: title=%s, owner=%s, repo=%s", req.Title, owner, repoName)
 
 	if err := cmd.Run(); err != nil {
 		errMsg := strings.TrimSpace(issueError.String())
@@ -2745,30 +2969,41 @@ func parseGitHubURL(remoteURL string) (owner, repo string) {
 	return "", ""
 }
 
-// getMainRepoPath returns the main repository path, resolving worktree paths
+// getMainRepoPath returns the main repository of the linked worktree at repoPath,
+// found through the git directory it shares with it, and repoPath itself for
+// anything else, including submodules
 func getMainRepoPath(repoPath string) string {
-	gitDirFile := filepath.Join(repoPath, ".git")
-	
-	// Check if .git is a file (worktree) or directory (main repo)
-	if data, err := os.ReadFile(gitDirFile); err == nil {
-		gitdir := strings.TrimSpace(string(data))
-		if strings.HasPrefix(gitdir, "gitdir: ") {
-			// This is a worktree, extract main repo path
-			gitdir = strings.TrimPrefix(gitdir, "gitdir: ")
-			// gitdir points to .git/worktrees/XXX
-			// Main repo is at the parent of .git
-			mainGitDir := filepath.Dir(filepath.Dir(gitdir))
-			return filepath.Dir(mainGitDir)
-		}
+	data, err := os.ReadFile(filepath.Join(repoPath, ".git"))
+	if err != nil {
+		return repoPath
 	}
-	
-	// Not a worktree, return as-is
-	return repoPath
+	gitdir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return repoPath
+	}
+	if !filepath.IsAbs(gitdir) {
+		gitdir = filepath.Join(repoPath, gitdir)
+	}
+	// Only linked worktrees have a commondir file, usually "../.."
+	commondir, err := os.ReadFile(filepath.Join(gitdir, "commondir"))
+	if err != nil {
+		return repoPath
+	}
+	common := strings.TrimSpace(string(commondir))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gitdir, common)
+	}
+	common = filepath.Clean(common)
+	if filepath.Base(common) == ".git" {
+		return filepath.Dir(common)
+	}
+	// A bare repository
+	return common
 }
 
-func executeCommand(name string, args ...string) (string, error) {
+func executeCommand(repo Repo, name string, args ...string) (string, error) {
 	cmd := exec.Command(name, args...)
-	cmd.Dir = config.RepoPath
+	cmd.Dir = repo.Path
 
 	var output bytes.Buffer
 	cmd.Stdout = &output
@@ -3006,6 +3241,11 @@ func handleAgentTrigger(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
 	var payload struct {
 		IssueNumber int    `json:"issue_number"`
 		IssueTitle  string `json:"issue_title"`
@@ -3021,9 +3261,11 @@ func handleAgentTrigger(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("handleAgentTrigger: Issue #%d - %s", payload.IssueNumber, payload.IssueTitle)
 
+	audit := auditEntryFor(r, "agent/run")
 	go func() {
 		log.Printf("Agent goroutine started for issue #%d", payload.IssueNumber)
-		processAgentIssue(payload.IssueNumber, payload.IssueTitle, payload.IssueBody)
+		processAgentIssue(repo, payload.IssueNumber, payload.IssueTitle, payload.IssueBody)
+		auditAgentRun(audit, payload.IssueNumber)
 	}()
 
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
@@ -3114,7 +3356,7 @@ func extractMeaningfulProgress(line string) (string, bool) {
 	return "", false
 }
 
-func processAgentIssue(issueNumber int, issueTitle, issueBody string) {
+func processAgentIssue(repo Repo, issueNumber int, issueTitle, issueBody string) {
 	log.Printf("processAgentIssue: starting for #%d", issueNumber)
 
 	startTime := time.Now()
@@ -3137,21 +3379,9 @@ func processAgentIssue(issueNumber int, issueTitle, issueBody string) {
 	log.Printf("processAgentIssue: branch=%s, worktreePath=%s", branchName, worktreePath)
 
 	// Get the main repository path (not worktree)
-	repoPath := config.RepoPath
-
-	// Check if current path is a worktree and get the main repo
-	gitDirFile := filepath.Join(repoPath, ".git")
-	if data, err := os.ReadFile(gitDirFile); err == nil {
-		// This is a worktree, extract main repo path
-		gitdir := strings.TrimSpace(string(data))
-		if strings.HasPrefix(gitdir, "gitdir: ") {
-			gitdir = strings.TrimPrefix(gitdir, "gitdir: ")
-			// gitdir points to .git/worktrees/XXX
-			// Main repo is at the parent of .git
-			mainGitDir := filepath.Dir(filepath.Dir(gitdir))
-			repoPath = filepath.Dir(mainGitDir)
-			log.Printf("Detected worktree, using main repo: %s", repoPath)
-		}
+	repoPath := getMainRepoPath(repo.Path)
+	if repoPath != repo.Path {
+		log.Printf("Detected worktree, using main repo: %s", repoPath)
 	}
 
 	log.Printf("Using repository path: %s", repoPath)
@@ -3228,7 +3458,7 @@ func processAgentIssue(issueNumber int, issueTitle, issueBody string) {
 	updateProgress("Determining default branch...")
 	// Get default branch name
 	defaultBranch := "main"
-	if output, err := executeGitCommand("symbolic-ref", "refs/remotes/origin/HEAD"); err == nil {
+	if output, err := executeGitCommand(repo, "symbolic-ref", "refs/remotes/origin/HEAD"); err == nil {
 		parts := strings.Split(strings.TrimSpace(output), "/")
 		if len(parts) > 0 {
 			defaultBranch = parts[len(parts)-1]
@@ -3623,6 +3853,11 @@ func handleAgentProcess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
 	var payload struct {
 		IssueNumber int    `json:"issue_number"`
 		IssueTitle  string `json:"issue_title"`
@@ -3647,9 +3882,11 @@ func handleAgentProcess(w http.ResponseWriter, r *http.Request) {
 	}
 	agentStatusMutex.Unlock()
 
+	audit := auditEntryFor(r, "agent/run")
 	go func() {
 		log.Printf("Agent goroutine started for issue #%d", payload.IssueNumber)
-		processAgentIssue(payload.IssueNumber, payload.IssueTitle, payload.IssueBody)
+		processAgentIssue(repo, payload.IssueNumber, payload.IssueTitle, payload.IssueBody)
+		auditAgentRun(audit, payload.IssueNumber)
 	}()
 
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Agent processing started"})
@@ -3701,27 +3938,32 @@ func handleListGitHubPRs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	remoteURL, err := exec.Command("git", "-C", config.RepoPath, "config", "--get", "remote.origin.url").Output()
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	remoteURL, err := exec.Command("git", "-C", repo.Path, "config", "--get", "remote.origin.url").Output()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Could not detect repository"})
 		return
 	}
 
-	owner, repo := parseGitHubURL(strings.TrimSpace(string(remoteURL)))
-	if owner == "" || repo == "" {
+	owner, repoName := parseGitHubURL(strings.TrimSpace(string(remoteURL)))
+	if owner == "" || repoName == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Not a GitHub repository"})
 		return
 	}
 
-	cmd := exec.Command("gh", "pr", "list", "--json", "number,title,state,author,createdAt,updatedAt,url,headRefName,body", "--repo", fmt.Sprintf("%s/%s", owner, repo))
-	cmd.Dir = config.RepoPath
+	cmd := exec.Command("gh", "pr", "list", "--json", "number,title,state,author,createdAt,updatedAt,url,headRefName,body", "--repo", fmt.Sprintf("%s/%s", owner, repoName))
+	cmd.Dir = repo.Path
 	output, err := cmd.Output()
 	if err != nil {
 		log.Printf("gh pr list error: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Failed to list PRs", "owner": owner, "repo": repo})
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Failed to list PRs", "owner": owner, "repo": repoName})
 		return
 	}
 
@@ -3737,7 +3979,7 @@ func handleListGitHubPRs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"prs":   prs,
 		"owner": owner,
-		"repo":  repo,
+		"repo":  repoName,
 	})
 }
 
@@ -3750,6 +3992,11 @@ func handleGetPRReviews(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
 	prNumberStr := r.URL.Query().Get("pr_number")
 	if prNumberStr == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -3757,23 +4004,23 @@ func handleGetPRReviews(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	remoteURL, err := exec.Command("git", "-C", config.RepoPath, "config", "--get", "remote.origin.url").Output()
+	remoteURL, err := exec.Command("git", "-C", repo.Path, "config", "--get", "remote.origin.url").Output()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Could not detect repository"})
 		return
 	}
 
-	owner, repo := parseGitHubURL(strings.TrimSpace(string(remoteURL)))
-	if owner == "" || repo == "" {
+	owner, repoName := parseGitHubURL(strings.TrimSpace(string(remoteURL)))
+	if owner == "" || repoName == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Not a GitHub repository"})
 		return
 	}
 
 	// Get review comments with file paths using GitHub API
-	cmd := exec.Command("gh", "api", fmt.Sprintf("/repos/%s/%s/pulls/%s/comments", owner, repo, prNumberStr))
-	cmd.Dir = config.RepoPath
+	cmd := exec.Command("gh", "api", fmt.Sprintf("/repos/%s/%s/pulls/%s/comments", owner, repoName, prNumberStr))
+	cmd.Dir = repo.Path
 	output, err := cmd.Output()
 	if err != nil {
 		log.Printf("gh api error: %v", err)
@@ -3805,6 +4052,11 @@ func handleAgentApplyReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
 	var payload struct {
 		IssueNumber int                      `json:"issue_number"`
 		PRNumber    int                      `json:"pr_number"`
@@ -3829,13 +4081,17 @@ func handleAgentApplyReview(w http.ResponseWriter, r *http.Request) {
 	}
 	agentStatusMutex.Unlock()
 
-	go processReviewComments(payload.IssueNumber, payload.PRNumber, payload.Comments)
+	audit := auditEntryFor(r, "agent/review")
+	go func() {
+		processReviewComments(repo, payload.IssueNumber, payload.PRNumber, payload.Comments)
+		auditAgentRun(audit, payload.IssueNumber)
+	}()
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Review processing started"})
 }
 
-func processReviewComments(issueNumber, prNumber int, comments []map[string]interface{}) {
+func processReviewComments(repo Repo, issueNumber, prNumber int, comments []map[string]interface{}) {
 	startTime := time.Now()
 	
 	updateProgress := func(message string) {
@@ -3850,7 +4106,7 @@ func processReviewComments(issueNumber, prNumber int, comments []map[string]inte
 
 	updateProgress("Getting PR information...")
 
-	remoteURL, err := exec.Command("git", "-C", config.RepoPath, "config", "--get", "remote.origin.url").Output()
+	remoteURL, err := exec.Command("git", "-C", repo.Path, "config", "--get", "remote.origin.url").Output()
 	if err != nil {
 		agentStatusMutex.Lock()
 		agentStatus[issueNumber] = AgentStatus{
@@ -3865,11 +4121,11 @@ func processReviewComments(issueNumber, prNumber int, comments []map[string]inte
 		return
 	}
 
-	owner, repo := parseGitHubURL(strings.TrimSpace(string(remoteURL)))
+	owner, repoName := parseGitHubURL(strings.TrimSpace(string(remoteURL)))
 	
 	var cmd *exec.Cmd
 	cmd = exec.Command("gh", "pr", "view", strconv.Itoa(prNumber), "--json", "headRefName,baseRefName")
-	cmd.Dir = config.RepoPath
+	cmd.Dir = repo.Path
 	output, err := cmd.Output()
 	if err != nil {
 		agentStatusMutex.Lock()
@@ -3921,7 +4177,7 @@ func processReviewComments(issueNumber, prNumber int, comments []map[string]inte
 		return
 	}
 
-	repoPath := config.RepoPath
+	repoPath := repo.Path
 	gitdir, _ := exec.Command("git", "-C", repoPath, "rev-parse", "--git-dir").Output()
 	if len(gitdir) > 0 {
 		gitdir := strings.TrimSpace(string(gitdir))
@@ -3939,27 +4195,12 @@ func processReviewComments(issueNumber, prNumber int, comments []map[string]inte
 
 	updateProgress("Checking for existing worktrees...")
 	// Check if branch is already checked out in another worktree
-	listCmd := exec.Command("git", "-C", repoPath, "worktree", "list", "--porcelain")
-	listOutput, _ := listCmd.Output()
-	worktrees := string(listOutput)
-	
-	// Parse worktree list to find if branch is in use
-	lines := strings.Split(worktrees, "\n")
+	worktrees, _ := gitBackend.Worktrees(Repo{Path: repoPath})
 	var conflictingWorktree string
-	for i := 0; i < len(lines); i++ {
-		if strings.HasPrefix(lines[i], "branch ") {
-			currentBranch := strings.TrimPrefix(lines[i], "branch ")
-			currentBranch = strings.TrimPrefix(currentBranch, "refs/heads/")
-			if currentBranch == branchName {
-				// Find the worktree path (should be a few lines before)
-				for j := i - 1; j >= 0 && j > i-5; j-- {
-					if strings.HasPrefix(lines[j], "worktree ") {
-						conflictingWorktree = strings.TrimPrefix(lines[j], "worktree ")
-						break
-					}
-				}
-				break
-			}
+	for _, wt := range worktrees {
+		if wt.Branch == branchName {
+			conflictingWorktree = wt.Path
+			break
 		}
 	}
 	
@@ -4048,7 +4289,7 @@ func processReviewComments(issueNumber, prNumber int, comments []map[string]inte
 			Status:      "completed",
 			Message:     fmt.Sprintf("Requested files already deleted: %s", strings.Join(alreadyDeletedFiles, ", ")),
 			PRNumber:    prNumber,
-			PRURL:       fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repo, prNumber),
+			PRURL:       fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repoName, prNumber),
 			StartTime:   startTime,
 			EndTime:     time.Now(),
 		}
@@ -4239,7 +4480,7 @@ Make the necessary code modifications to address all the feedback.`, prNumber, r
 			Status:      "completed",
 			Message:     "No changes needed - review requests already addressed",
 			PRNumber:    prNumber,
-			PRURL:       fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repo, prNumber),
+			PRURL:       fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repoName, prNumber),
 			StartTime:   startTime,
 			EndTime:     time.Now(),
 		}
@@ -4268,7 +4509,7 @@ Make the necessary code modifications to address all the feedback.`, prNumber, r
 		Status:      "completed",
 		Message:     "Review comments addressed and pushed",
 		PRNumber:    prNumber,
-		PRURL:       fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repo, prNumber),
+		PRURL:       fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repoName, prNumber),
 		StartTime:   startTime,
 		EndTime:     time.Now(),
 	}
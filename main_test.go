@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestRequestRepoConcurrent resolves repositories while others switch the
+// selected one; run with -race
+func TestRequestRepoConcurrent(t *testing.T) {
+	base := useTestBase(t)
+	web, other := filepath.Join(base, "web"), filepath.Join(base, "other")
+	initTestRepo(t, web)
+	initTestRepo(t, other)
+	setSelectedRepo(Repo{Path: web})
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if (i+j)%2 == 0 {
+					setSelectedRepo(Repo{Path: web})
+				} else {
+					setSelectedRepo(Repo{Path: other})
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				w := httptest.NewRecorder()
+				repo, ok := requireRepo(w, httptest.NewRequest("GET", "/api/status", nil))
+				if !ok || (repo.Path != web && repo.Path != other) {
+					errs <- "selected repository resolved to " + repo.Path
+					return
+				}
+				repo, ok = requireRepo(w, httptest.NewRequest("GET", "/api/status?repoPath=other", nil))
+				if !ok || repo.Path != other {
+					errs <- "repoPath=other resolved to " + repo.Path
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				var first, second Repo
+				handler := pinRequestRepo(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					first, _ = requireRepo(w, r)
+					second, _ = requestRepo(r)
+				}))
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/push", nil))
+				if first != second {
+					errs <- "one request resolved both " + first.Path + " and " + second.Path
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestRequireRepoInvalid(t *testing.T) {
+	base := useTestBase(t)
+	initTestRepo(t, filepath.Join(base, "web"))
+
+	for _, target := range []string{
+		"/api/status?repoPath=missing",
+		"/api/status?repoPath=../outside",
+		"/api/status?repoPath=/etc",
+	} {
+		w := httptest.NewRecorder()
+		if repo, ok := requireRepo(w, httptest.NewRequest("GET", target, nil)); ok {
+			t.Errorf("requireRepo(%s) = %s, want an error", target, repo.Path)
+		} else if w.Code != http.StatusBadRequest {
+			t.Errorf("requireRepo(%s) responded %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
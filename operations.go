@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// OperationState describes a merge, rebase, cherry-pick, revert or am that stopped
+// part-way, usually because of conflicts. Operation is empty when nothing is in progress;
+// Conflicts may still be listed then, after a squash merge or stash pop with conflicts.
+type OperationState struct {
+	Operation string   `json:"operation"`
+	Head      string   `json:"head,omitempty"`     // the commit being merged or applied
+	HeadName  string   `json:"headName,omitempty"` // rebase: the branch being rebased
+	Onto      string   `json:"onto,omitempty"`     // rebase: the new base
+	Step      int      `json:"step,omitempty"`     // rebase/am: the commit being applied, 1-based
+	Total     int      `json:"total,omitempty"`
+	Message   string   `json:"message,omitempty"` // the prepared commit message
+	Conflicts []string `json:"conflicts"`
+	Actions   []string `json:"actions"` // which of continue, skip and abort apply
+}
+
+// noEditorEnv stops commands like rebase --continue from waiting on an editor
+var noEditorEnv = []string{"GIT_EDITOR=true", "GIT_SEQUENCE_EDITOR=true"}
+
+// runOperationGit is runLoggedGit without an interactive editor
+func runOperationGit(repo Repo, logs []string, args ...string) ([]string, string, error) {
+	logs = append(logs, "$ git "+strings.Join(args, " "))
+	output, err := executeGitCommandEnv(repo, noEditorEnv, args...)
+	if output != "" {
+		logs = append(logs, output)
+	}
+	return logs, output, err
+}
+
+func readGitDirFile(gitDir, name string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func gitDirExists(gitDir, name string) bool {
+	_, err := os.Stat(filepath.Join(gitDir, name))
+	return err == nil
+}
+
+// getOperationState inspects the marker files git leaves in the (worktree's) git directory
+func getOperationState(repo Repo) (OperationState, error) {
+	state := OperationState{Conflicts: []string{}, Actions: []string{}}
+
+	gitDir, err := executeGitCommand(repo, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return state, fmt.Errorf("%v: %s", err, gitDir)
+	}
+
+	switch {
+	case gitDirExists(gitDir, "rebase-merge"):
+		state.Operation = "rebase"
+		state.HeadName = strings.TrimPrefix(readGitDirFile(gitDir, "rebase-merge/head-name"), "refs/heads/")
+		state.Onto = readGitDirFile(gitDir, "rebase-merge/onto")
+		state.Step, _ = strconv.Atoi(readGitDirFile(gitDir, "rebase-merge/msgnum"))
+		state.Total, _ = strconv.Atoi(readGitDirFile(gitDir, "rebase-merge/end"))
+		state.Head = readGitDirFile(gitDir, "REBASE_HEAD")
+	case gitDirExists(gitDir, "rebase-apply"):
+		state.Operation = "rebase"
+		if gitDirExists(gitDir, "rebase-apply/applying") {
+			state.Operation = "am"
+		}
+		state.HeadName = strings.TrimPrefix(readGitDirFile(gitDir, "rebase-apply/head-name"), "refs/heads/")
+		state.Onto = readGitDirFile(gitDir, "rebase-apply/onto")
+		state.Step, _ = strconv.Atoi(readGitDirFile(gitDir, "rebase-apply/next"))
+		state.Total, _ = strconv.Atoi(readGitDirFile(gitDir, "rebase-apply/last"))
+		state.Head = readGitDirFile(gitDir, "REBASE_HEAD")
+	case gitDirExists(gitDir, "CHERRY_PICK_HEAD"):
+		state.Operation = "cherry-pick"
+		state.Head = readGitDirFile(gitDir, "CHERRY_PICK_HEAD")
+	case gitDirExists(gitDir, "REVERT_HEAD"):
+		state.Operation = "revert"
+		state.Head = readGitDirFile(gitDir, "REVERT_HEAD")
+	case gitDirExists(gitDir, "MERGE_HEAD"):
+		state.Operation = "merge"
+		state.Head = strings.Join(strings.Fields(readGitDirFile(gitDir, "MERGE_HEAD")), " ")
+	default:
+		// A squash merge leaves no MERGE_HEAD, but its conflicts are in the index
+		if conflicts := getConflictFiles(repo); len(conflicts) > 0 {
+			state.Conflicts = conflicts
+		}
+		return state, nil
+	}
+
+	if state.Operation != "rebase" && state.Operation != "am" {
+		state.Message = readGitDirFile(gitDir, "MERGE_MSG")
+	}
+	state.Conflicts = getConflictFiles(repo)
+	if state.Conflicts == nil {
+		state.Conflicts = []string{}
+	}
+	state.Actions = []string{"continue", "abort"}
+	if state.Operation != "merge" {
+		state.Actions = []string{"continue", "skip", "abort"}
+	}
+	return state, nil
+}
+
+// finishOperation writes the response after a merge, rebase, cherry-pick, revert or
+// continue/skip. A stop with conflicts is reported as 409 along with the operation state.
+func finishOperation(w http.ResponseWriter, repo Repo, logs []string, err error, name, success string) {
+	state, stateErr := getOperationState(repo)
+	branch, _ := gitBackend.CurrentBranch(repo)
+	head, _ := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "HEAD")
+
+	result := map[string]interface{}{
+		"branch":    branch,
+		"commit":    head,
+		"operation": state,
+	}
+	if stateErr != nil {
+		result["operation"] = nil
+	}
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := fmt.Sprintf("git %s failed: %v", name, err)
+		if len(state.Conflicts) > 0 {
+			status = http.StatusConflict
+			message = fmt.Sprintf("%s stopped with conflicts in %d file(s); resolve and stage them, then continue or abort", name, len(state.Conflicts))
+			if state.Operation == "" {
+				message = fmt.Sprintf("%s stopped with conflicts in %d file(s); resolve and stage them, then commit", name, len(state.Conflicts))
+			}
+		}
+		result["error"] = message
+		result["log"] = logs
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	result["log"] = append(logs, success)
+	json.NewEncoder(w).Encode(result)
+}
+
+// requireNoOperation writes a 409 if a merge, rebase, cherry-pick or revert is already in progress
+func requireNoOperation(w http.ResponseWriter, repo Repo) bool {
+	state, err := getOperationState(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read repository state: %v", err),
+		})
+		return false
+	}
+	if state.Operation != "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     fmt.Sprintf("A %s is already in progress; continue or abort it first", state.Operation),
+			"operation": state,
+		})
+		return false
+	}
+	return true
+}
+
+func handleOperationState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := getOperationState(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read repository state: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"operation": state,
+	})
+}
+
+// handleOperationAction serves /api/operation/continue, /skip and /abort for
+// whichever operation is in progress
+func handleOperationAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	action := strings.TrimPrefix(r.URL.Path, "/api/operation/")
+	state, err := getOperationState(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read repository state: %v", err),
+		})
+		return
+	}
+	if state.Operation == "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: "No merge, rebase, cherry-pick or revert is in progress",
+		})
+		return
+	}
+
+	allowed := false
+	for _, a := range state.Actions {
+		allowed = allowed || a == action
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Cannot %s a %s", action, state.Operation),
+		})
+		return
+	}
+
+	if action == "continue" && len(state.Conflicts) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     fmt.Sprintf("%d file(s) still have conflicts", len(state.Conflicts)),
+			"operation": state,
+		})
+		return
+	}
+
+	pastTense := map[string]string{"continue": "continued", "skip": "skipped a commit", "abort": "aborted"}
+	logs, _, err := runOperationGit(repo, nil, state.Operation, "--"+action)
+	finishOperation(w, repo, logs, err, state.Operation, fmt.Sprintf("✓ %s %s", state.Operation, pastTense[action]))
+}
+
+func handleMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Branch      string `json:"branch"`
+		FastForward string `json:"fastForward"` // "" (default), "only" or "never"
+		Squash      bool   `json:"squash"`
+		Autostash   bool   `json:"autostash"`
+		Message     string `json:"message"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if !isValidRef(req.Branch) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Branch name is required",
+		})
+		return
+	}
+	if !requireNoOperation(w, repo) {
+		return
+	}
+
+	args := []string{"merge"}
+	switch req.FastForward {
+	case "":
+	case "only":
+		args = append(args, "--ff-only")
+	case "never":
+		args = append(args, "--no-ff")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "fastForward must be \"only\" or \"never\"",
+		})
+		return
+	}
+	if req.Squash {
+		args = append(args, "--squash")
+	}
+	if req.Autostash {
+		args = append(args, "--autostash")
+	}
+	if req.Message != "" {
+		args = append(args, "-m", req.Message)
+	} else {
+		args = append(args, "--no-edit")
+	}
+	args = append(args, req.Branch)
+
+	logs, _, err := runOperationGit(repo, nil, args...)
+	success := "✓ Merged " + req.Branch
+	if req.Squash {
+		success = "✓ Squashed " + req.Branch + "; commit the staged changes to finish"
+	}
+	finishOperation(w, repo, logs, err, "merge", success)
+}
+
+func handleRebase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Onto      string `json:"onto"`
+		Autostash bool   `json:"autostash"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if !isValidRef(req.Onto) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "onto is required",
+		})
+		return
+	}
+	if !requireNoOperation(w, repo) {
+		return
+	}
+
+	args := []string{"rebase"}
+	if req.Autostash {
+		args = append(args, "--autostash")
+	}
+	args = append(args, req.Onto)
+
+	logs, _, err := runOperationGit(repo, nil, args...)
+	finishOperation(w, repo, logs, err, "rebase", "✓ Rebased onto "+req.Onto)
+}
+
+// handlePick serves /api/cherry-pick and /api/revert, which take the same options
+func handlePick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Commits      []string `json:"commits"`
+		Mainline     int      `json:"mainline"` // parent number to diff against when picking a merge commit
+		NoCommit     bool     `json:"noCommit"`
+		RecordOrigin bool     `json:"recordOrigin"` // cherry-pick -x
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if len(req.Commits) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "At least one commit is required",
+		})
+		return
+	}
+	for _, commit := range req.Commits {
+		if !isValidRef(commit) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Invalid commit: %s", commit),
+			})
+			return
+		}
+	}
+	if !requireNoOperation(w, repo) {
+		return
+	}
+
+	name := "cherry-pick"
+	if strings.HasSuffix(r.URL.Path, "/revert") {
+		name = "revert"
+	}
+	args := []string{name}
+	if name == "revert" {
+		args = append(args, "--no-edit")
+	} else if req.RecordOrigin {
+		args = append(args, "-x")
+	}
+	if req.Mainline > 0 {
+		args = append(args, "-m", strconv.Itoa(req.Mainline))
+	}
+	if req.NoCommit {
+		args = append(args, "--no-commit")
+	}
+	args = append(args, req.Commits...)
+
+	logs, _, err := runOperationGit(repo, nil, args...)
+	success := fmt.Sprintf("✓ Cherry-picked %d commit(s)", len(req.Commits))
+	if name == "revert" {
+		success = fmt.Sprintf("✓ Reverted %d commit(s)", len(req.Commits))
+	}
+	finishOperation(w, repo, logs, err, name, success)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSquashMergeConflicts(t *testing.T) {
+	base := useTestBase(t)
+	repo := filepath.Join(base, "web")
+	initTestRepo(t, repo)
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("base\n")
+	runGit(t, repo, "add", "file.txt")
+	runGit(t, repo, "commit", "-q", "-m", "base")
+	runGit(t, repo, "checkout", "-q", "-b", "feature")
+	write("feature\n")
+	runGit(t, repo, "commit", "-q", "-am", "feature")
+	runGit(t, repo, "checkout", "-q", "main")
+	write("main\n")
+	runGit(t, repo, "commit", "-q", "-am", "main")
+
+	r := httptest.NewRequest("POST", "/api/merge?repoPath=web", strings.NewReader(`{"branch":"feature","squash":true}`))
+	w := httptest.NewRecorder()
+	handleMerge(w, r)
+
+	var response struct {
+		Error     string         `json:"error"`
+		Operation OperationState `json:"operation"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 409 {
+		t.Fatalf("squash merge returned %d (%s), want 409", w.Code, response.Error)
+	}
+	if response.Operation.Operation != "" || len(response.Operation.Conflicts) != 1 || response.Operation.Conflicts[0] != "file.txt" {
+		t.Errorf("operation = %+v, want no operation with file.txt conflicted", response.Operation)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Role is what a user may do in a repository. Each role includes the ones below it.
+type Role int
+
+const (
+	roleNone       Role = iota
+	roleViewer          // list and browse
+	roleDeveloper       // commit, push, pull, branches, stashes, ...
+	roleMaintainer      // tags, remotes and repository settings
+	roleAdmin           // with "**", also the server: systemd, users, audit log, GitHub login, creating repositories
+)
+
+var roleNames = []string{"none", "viewer", "developer", "maintainer", "admin"}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(name string) (Role, bool) {
+	for i, roleName := range roleNames {
+		if name == roleName && i > 0 {
+			return Role(i), true
+		}
+	}
+	return roleNone, false
+}
+
+// RoleGrant gives a user a role in the repositories whose path relative to
+// the base path matches Repos. As in path.Match, * does not match a slash;
+// "dir/**" matches everything beneath dir and "**" matches every repository.
+type RoleGrant struct {
+	Repos string `json:"repos"`
+	Role  string `json:"role"`
+}
+
+const allRepos = "**"
+
+// maintainerPaths change a repository's tags, remotes or settings
+var maintainerPaths = map[string]bool{
+	"/api/tag/create":    true,
+	"/api/tag/push":      true,
+	"/api/tag/delete":    true,
+	"/api/remote/add":    true,
+	"/api/remote/update": true,
+	"/api/remote/remove": true,
+	"/api/repo/settings": true,
+}
+
+// requiredRole returns the role a request needs and whether it is needed
+// in the request's repository or, when false, in all of them
+func requiredRole(r *http.Request) (Role, bool) {
+	path := r.URL.Path
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch {
+	// Every signed-in user manages their own account and tokens; the
+	// repository list only shows what the user can see
+	case strings.HasPrefix(path, "/api/auth/"), path == "/api/tokens", strings.HasPrefix(path, "/api/tokens/"),
+		path == "/api/repos", path == "/api/github/auth/status":
+		return roleNone, false
+	// The server itself, its audit log, and the GitHub login the agent works with
+	case strings.HasPrefix(path, "/api/systemd/"), path == "/api/users", strings.HasPrefix(path, "/api/users/"),
+		path == "/api/audit", path == "/api/github/auth/login", path == "/api/repo/create", path == "/api/repo/init":
+		return roleAdmin, false
+	case maintainerPaths[path] && !readOnly:
+		return roleMaintainer, true
+	// Changes the repository that requests without repoPath use, for every user
+	case path == "/api/load-repo":
+		return roleDeveloper, true
+	case readOnly:
+		return roleViewer, true
+	default:
+		return roleDeveloper, true
+	}
+}
+
+// matchRepoPattern reports whether the repository at rel, relative to the base path, matches pattern
+func matchRepoPattern(pattern, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if pattern == allRepos {
+		return true
+	}
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		parts := strings.Split(rel, "/")
+		for i := 1; i <= len(parts); i++ {
+			if ok, _ := path.Match(dir, strings.Join(parts[:i], "/")); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := path.Match(pattern, rel)
+	return ok
+}
+
+// roleIn returns the highest role username has in the repository at rel;
+// callers hold authMutex
+func roleIn(username, rel string) Role {
+	i := findUser(username)
+	if i < 0 {
+		return roleNone
+	}
+	best := roleNone
+	for _, grant := range authData.Users[i].Roles {
+		role, _ := parseRole(grant.Role)
+		if role > best && matchRepoPattern(grant.Repos, rel) {
+			best = role
+		}
+	}
+	return best
+}
+
+// globalRole returns the role username has in all repositories; callers hold authMutex
+func globalRole(username string) Role {
+	i := findUser(username)
+	if i < 0 {
+		return roleNone
+	}
+	best := roleNone
+	for _, grant := range authData.Users[i].Roles {
+		if role, _ := parseRole(grant.Role); role > best && grant.Repos == allRepos {
+			best = role
+		}
+	}
+	return best
+}
+
+// roleRepo returns the path, relative to the base path, whose roles apply to
+// the repository at path: a linked worktree has the roles of its main repository
+func roleRepo(path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseRepoPath, path)
+	}
+	return relativeToBase(getMainRepoPath(path))
+}
+
+// canView reports whether username may see the repository at path
+func canView(username, path string) bool {
+	if config.AuthDisabled {
+		return true
+	}
+	rel, ok := roleRepo(path)
+	if !ok {
+		return false
+	}
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	return roleIn(username, rel) >= roleViewer
+}
+
+type loadRepoRequest struct {
+	RelativePath string `json:"relativePath"`
+	Branch       string `json:"branch"`
+}
+
+// readLoadRepoRequest reads the body of a /api/load-repo request and puts it back for the handler
+func readLoadRepoRequest(r *http.Request) (loadRepoRequest, bool) {
+	var req loadRepoRequest
+	if r.Method != http.MethodPost || r.Body == nil {
+		return req, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return req, err == nil && json.Unmarshal(body, &req) == nil
+}
+
+const repoContextKey contextKey = "repo"
+
+// authorizedRepo resolves the repository r acts on, as its handler will. Once
+// pinRequestRepo has run, that is the repository it resolved.
+func authorizedRepo(r *http.Request) (Repo, bool) {
+	if repo, ok := r.Context().Value(repoContextKey).(Repo); ok {
+		return repo, true
+	}
+	if r.URL.Path != "/api/load-repo" {
+		return requestRepo(r)
+	}
+	req, ok := readLoadRepoRequest(r)
+	if !ok {
+		return Repo{}, false
+	}
+	if req.RelativePath == "" || req.RelativePath == "/" {
+		return getSelectedRepo(), true
+	}
+	return resolveAndValidateRepoPath(req.RelativePath, baseRepoPath)
+}
+
+// pinRequestRepo resolves the repository of each API request once, so that
+// authorization, the audit log and the handler act on the same repository even
+// if /api/load-repo changes the selected one meanwhile
+func pinRequestRepo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			if repo, ok := authorizedRepo(r); ok {
+				r = r.WithContext(context.WithValue(r.Context(), repoContextKey, repo))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize checks that username has the role r needs, returning the error
+// to respond with otherwise
+func authorize(r *http.Request, username string) error {
+	role, scoped := requiredRole(r)
+	if role == roleNone {
+		return nil
+	}
+
+	if !scoped {
+		authMutex.Lock()
+		has := globalRole(username)
+		authMutex.Unlock()
+		if has < role {
+			return fmt.Errorf("This needs the %s role for all repositories", role)
+		}
+		return nil
+	}
+
+	repo, ok := authorizedRepo(r)
+	if !ok {
+		// The handler rejects the invalid repository without touching anything
+		return nil
+	}
+	rel, ok := roleRepo(repo.Path)
+	if !ok {
+		return fmt.Errorf("Repository is outside the base path")
+	}
+	authMutex.Lock()
+	has := roleIn(username, rel)
+	authMutex.Unlock()
+	if has < role {
+		if has == roleNone {
+			return fmt.Errorf("You have no access to %s", filepath.ToSlash(rel))
+		}
+		return fmt.Errorf("Your role in %s is %s; this needs %s", filepath.ToSlash(rel), has, role)
+	}
+	return nil
+}
+
+// validateGrants checks the roles given to a user
+func validateGrants(grants []RoleGrant) error {
+	for _, grant := range grants {
+		if _, ok := parseRole(grant.Role); !ok {
+			return fmt.Errorf("unknown role %q; roles are viewer, developer, maintainer and admin", grant.Role)
+		}
+		if _, err := path.Match(grant.Repos, ""); err != nil || grant.Repos == "" {
+			return fmt.Errorf("invalid repository pattern %q", grant.Repos)
+		}
+	}
+	return nil
+}
+
+// adminCount returns how many users are admins of all repositories; callers hold authMutex
+func adminCount() int {
+	count := 0
+	for _, user := range authData.Users {
+		if globalRole(user.Username) == roleAdmin {
+			count++
+		}
+	}
+	return count
+}
+
+// filterRepositories drops the repositories, worktrees and submodules username cannot view
+func filterRepositories(repos []Repository, username string) []Repository {
+	visible := []Repository{}
+	for _, repo := range repos {
+		repo.Worktrees = filterRepositories(repo.Worktrees, username)
+		repo.Submodules = filterRepositories(repo.Submodules, username)
+		if canView(username, repo.Path) {
+			visible = append(visible, repo)
+		} else {
+			// Visible worktrees and submodules of a hidden repository are listed on their own
+			visible = append(append(visible, repo.Worktrees...), repo.Submodules...)
+		}
+	}
+	return visible
+}
+
+// handleSetUserRoles serves POST /api/users/roles, replacing a user's roles
+func handleSetUserRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Username string      `json:"username"`
+		Roles    []RoleGrant `json:"roles"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+	if err := validateGrants(req.Roles); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	authMutex.Lock()
+	defer authMutex.Unlock()
+
+	i := findUser(req.Username)
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "User not found",
+		})
+		return
+	}
+
+	previous := authData.Users[i].Roles
+	authData.Users[i].Roles = append([]RoleGrant{}, req.Roles...)
+	if adminCount() == 0 {
+		authData.Users[i].Roles = previous
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: "At least one user must remain admin of all repositories (\"**\")",
+		})
+		return
+	}
+	if err := saveAuth(); err != nil {
+		authData.Users[i].Roles = previous
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to save roles: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"username": req.Username,
+		"roles":    authData.Users[i].Roles,
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGit runs git in dir, failing the test on errors
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+}
+
+// initTestRepo creates a repository with one commit at path
+func initTestRepo(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, path, "init", "-q", "-b", "main")
+	// Handlers run git without the identity in runGit's environment
+	runGit(t, path, "config", "user.name", "test")
+	runGit(t, path, "config", "user.email", "test@example.com")
+	runGit(t, path, "commit", "-q", "--allow-empty", "-m", "initial")
+}
+
+// useTestBase points the server at a new base path for the duration of the test
+func useTestBase(t *testing.T) string {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousBase, previousSelected, previousConfig := baseRepoPath, getSelectedRepo(), config
+	baseRepoPath = base
+	setSelectedRepo(Repo{Path: base})
+	t.Cleanup(func() {
+		baseRepoPath, config = previousBase, previousConfig
+		setSelectedRepo(previousSelected)
+	})
+	return base
+}
+
+// useTestUsers replaces the user accounts for the duration of the test
+func useTestUsers(t *testing.T, users ...User) {
+	t.Helper()
+	authMutex.Lock()
+	previous := authData
+	authData = authFile{Users: users}
+	authMutex.Unlock()
+	t.Cleanup(func() {
+		authMutex.Lock()
+		authData = previous
+		authMutex.Unlock()
+	})
+}
+
+func TestMatchRepoPattern(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"**", "anything/at/all", true},
+		{"web", "web", true},
+		{"web", "web2", false},
+		{"team-a/*", "team-a/web", true},
+		{"team-a/*", "team-a/web/sub", false},
+		{"team-a/**", "team-a", true},
+		{"team-a/**", "team-a/web/sub", true},
+		{"team-a/**", "team-b/web", false},
+		{"*/web", "team-a/web", true},
+		{"*/**", "team-a/web", true},
+	}
+	for _, tt := range tests {
+		if got := matchRepoPattern(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchRepoPattern(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method, target, body string
+		role                 Role
+		scoped               bool
+	}{
+		{"GET", "/api/auth/status", "", roleNone, false},
+		{"POST", "/api/tokens/create", "", roleNone, false},
+		{"GET", "/api/repos", "", roleNone, false},
+		{"GET", "/api/status", "", roleViewer, true},
+		{"POST", "/api/push", "", roleDeveloper, true},
+		{"POST", "/api/stash/save", "", roleDeveloper, true},
+		{"GET", "/api/tags", "", roleViewer, true},
+		{"POST", "/api/tag/create", "", roleMaintainer, true},
+		{"POST", "/api/remote/remove", "", roleMaintainer, true},
+		{"GET", "/api/repo/settings", "", roleViewer, true},
+		{"POST", "/api/repo/settings", "", roleMaintainer, true},
+		{"POST", "/api/repo/create", "", roleAdmin, false},
+		{"POST", "/api/systemd/restart", "", roleAdmin, false},
+		{"GET", "/api/users", "", roleAdmin, false},
+		{"GET", "/api/audit", "", roleAdmin, false},
+		{"POST", "/api/load-repo", `{"relativePath":"web"}`, roleDeveloper, true},
+		{"POST", "/api/load-repo", `{"relativePath":"web","branch":"main"}`, roleDeveloper, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		role, scoped := requiredRole(r)
+		if role != tt.role || scoped != tt.scoped {
+			t.Errorf("requiredRole(%s %s %s) = %s, %v, want %s, %v", tt.method, tt.target, tt.body, role, scoped, tt.role, tt.scoped)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	base := useTestBase(t)
+	config.AuthDisabled = false
+	initTestRepo(t, filepath.Join(base, "team-a", "web"))
+	initTestRepo(t, filepath.Join(base, "secret"))
+	runGit(t, filepath.Join(base, "team-a", "web"), "worktree", "add", "-q", "-b", "feature", worktreesDir(filepath.Join(base, "team-a", "web"))+"/feature")
+	// A worktree of secret placed where team-a's roles would apply by path
+	runGit(t, filepath.Join(base, "secret"), "worktree", "add", "-q", "-b", "leak", filepath.Join(base, "team-a", "leak"))
+
+	useTestUsers(t,
+		User{Username: "admin", Roles: []RoleGrant{{Repos: allRepos, Role: "admin"}}},
+		User{Username: "dev", Roles: []RoleGrant{{Repos: "team-a/*", Role: "developer"}}},
+		User{Username: "viewer", Roles: []RoleGrant{{Repos: allRepos, Role: "viewer"}}},
+	)
+
+	tests := []struct {
+		user, method, target string
+		allowed              bool
+	}{
+		{"dev", "POST", "/api/push?repoPath=team-a/web", true},
+		{"dev", "GET", "/api/status?repoPath=team-a/web", true},
+		{"dev", "POST", "/api/tag/create?repoPath=team-a/web", false},
+		{"dev", "GET", "/api/status?repoPath=secret", false},
+		{"dev", "POST", "/api/users/create", false},
+		// Worktrees have the roles of their main repository, wherever they are
+		{"dev", "POST", "/api/push?repoPath=team-a/web.worktrees/feature", true},
+		{"dev", "GET", "/api/status?repoPath=team-a/leak", false},
+		{"viewer", "GET", "/api/status?repoPath=team-a/web.worktrees/feature", true},
+		{"viewer", "POST", "/api/push?repoPath=team-a/web", false},
+		{"admin", "POST", "/api/tag/create?repoPath=secret", true},
+		{"admin", "POST", "/api/users/create", true},
+		{"nobody", "GET", "/api/status?repoPath=team-a/web", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		err := authorize(r, tt.user)
+		if (err == nil) != tt.allowed {
+			t.Errorf("authorize(%s %s) for %s = %v, want allowed=%v", tt.method, tt.target, tt.user, err, tt.allowed)
+		}
+	}
+
+	visible := filterRepositories([]Repository{
+		{Name: "web", Path: "team-a/web", Worktrees: []Repository{{Name: "feature", Path: "team-a/web.worktrees/feature"}}},
+		{Name: "secret", Path: "secret", Worktrees: []Repository{{Name: "leak", Path: "team-a/leak"}}},
+	}, "dev")
+	if len(visible) != 1 || visible[0].Path != "team-a/web" || len(visible[0].Worktrees) != 1 {
+		t.Errorf("filterRepositories for dev = %+v, want team-a/web with its worktree", visible)
+	}
+}
+
+func TestPinRequestRepo(t *testing.T) {
+	base := useTestBase(t)
+	web, other := filepath.Join(base, "web"), filepath.Join(base, "other")
+	initTestRepo(t, web)
+	initTestRepo(t, other)
+	setSelectedRepo(Repo{Path: web})
+
+	var handled Repo
+	handler := pinRequestRepo(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Another user selects a different repository while the request is on its way
+		setSelectedRepo(Repo{Path: other})
+		handled, _ = requestRepo(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/push", nil))
+	if handled.Path != web {
+		t.Errorf("handler got %s, want the repository selected when the request arrived, %s", handled.Path, web)
+	}
+
+	handler = pinRequestRepo(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled, _ = authorizedRepo(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/load-repo", strings.NewReader(`{"relativePath":"web"}`)))
+	if handled.Path != web {
+		t.Errorf("load-repo resolved %s, want %s", handled.Path, web)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// RepoSettings are per-repository options, stored in the repository's own
+// git config under the airgit section so they travel with the repository
+type RepoSettings struct {
+	PullMode     string `json:"pullMode"`     // merge, rebase, ff-only, or "" to follow git's pull.rebase/pull.ff
+	PullOnReject bool   `json:"pullOnReject"` // whether push pulls and retries when the remote has new commits
+
+	// RecurseSubmodules makes pull and checkout update submodules to the recorded commits
+	RecurseSubmodules bool `json:"recurseSubmodules"`
+
+	// LFS makes pull and push transfer Git LFS objects for repositories with LFS-tracked paths
+	LFS bool `json:"lfs"`
+
+	// ProtectedBranches are glob patterns for branches AirGit will not push to,
+	// force-push or delete. They are read-only over the API: they come from
+	// --protected-branches and the repository's airgit.protectedBranch entries.
+	ProtectedBranches []string `json:"protectedBranches"`
+}
+
+var pullModes = map[string]bool{"": true, "merge": true, "rebase": true, "ff-only": true}
+
+func defaultRepoSettings() RepoSettings {
+	return RepoSettings{
+		PullOnReject:      true,
+		LFS:               true,
+		ProtectedBranches: append([]string{}, config.ProtectedBranches...),
+	}
+}
+
+// getRepoSettings reads the airgit.* keys, falling back to the defaults
+func getRepoSettings(repo Repo) RepoSettings {
+	settings := defaultRepoSettings()
+	// Exits with status 1 when no key matches
+	output, err := executeGitCommand(repo, "config", "--local", "--get-regexp", `^airgit\.`)
+	if err != nil {
+		return settings
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch strings.ToLower(key) {
+		case "airgit.pullmode":
+			if pullModes[value] {
+				settings.PullMode = value
+			}
+		case "airgit.pullonreject":
+			settings.PullOnReject = value != "false"
+		case "airgit.recursesubmodules":
+			settings.RecurseSubmodules = value == "true"
+		case "airgit.lfs":
+			settings.LFS = value != "false"
+		case "airgit.protectedbranch":
+			settings.ProtectedBranches = append(settings.ProtectedBranches, splitPatterns(value)...)
+		}
+	}
+	return settings
+}
+
+// splitPatterns splits a comma-separated list of glob patterns
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// protectedBranchPattern returns the pattern protecting branch, if any.
+// As in path.Match, * does not match a slash.
+func (s RepoSettings) protectedBranchPattern(branch string) (string, bool) {
+	for _, pattern := range s.ProtectedBranches {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func setRepoSetting(repo Repo, key, value string) error {
+	args := []string{"config", "--local", key, value}
+	if value == "" {
+		args = []string{"config", "--local", "--unset-all", key}
+	}
+	output, err := executeGitCommand(repo, args...)
+	// --unset-all exits with status 5 when the key is not set, which is fine
+	if err != nil && !(value == "" && output == "") {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// handleRepoSettings serves GET and POST /api/repo/settings. POST only changes the fields it is given;
+// protected branches cannot be changed from here.
+func handleRepoSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req struct {
+			PullMode          *string `json:"pullMode"`
+			PullOnReject      *bool   `json:"pullOnReject"`
+			RecurseSubmodules *bool   `json:"recurseSubmodules"`
+			LFS               *bool   `json:"lfs"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Invalid request body",
+			})
+			return
+		}
+		if req.PullMode != nil && !pullModes[*req.PullMode] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: "pullMode must be merge, rebase or ff-only",
+			})
+			return
+		}
+
+		var err error
+		if req.PullMode != nil {
+			err = setRepoSetting(repo, "airgit.pullMode", *req.PullMode)
+		}
+		if req.PullOnReject != nil && err == nil {
+			err = setRepoSetting(repo, "airgit.pullOnReject", fmt.Sprint(*req.PullOnReject))
+		}
+		if req.RecurseSubmodules != nil && err == nil {
+			err = setRepoSetting(repo, "airgit.recurseSubmodules", fmt.Sprint(*req.RecurseSubmodules))
+		}
+		if req.LFS != nil && err == nil {
+			err = setRepoSetting(repo, "airgit.lfs", fmt.Sprint(*req.LFS))
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to save settings: %v", err),
+			})
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"settings": getRepoSettings(repo),
+	})
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type StashEntry struct {
+	Index   int    `json:"index"`
+	Ref     string `json:"ref"`
+	Hash    string `json:"hash"`
+	Branch  string `json:"branch,omitempty"`
+	Message string `json:"message"`
+	Date    string `json:"date"`
+}
+
+func stashRef(index int) string {
+	return fmt.Sprintf("stash@{%d}", index)
+}
+
+// parseStashList parses `git stash list` in the format used by getStashes
+func parseStashList(output string) []StashEntry {
+	stashes := []StashEntry{}
+	for i, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) < 3 {
+			continue
+		}
+
+		entry := StashEntry{
+			Index:   i,
+			Ref:     stashRef(i),
+			Hash:    fields[0],
+			Message: fields[1],
+			Date:    fields[2],
+		}
+		// Reflog subjects look like "WIP on main: 1a2b3c Subject" without a
+		// message, or "On main: message" with one
+		rest, ok := strings.CutPrefix(fields[1], "WIP on ")
+		if !ok {
+			rest, ok = strings.CutPrefix(fields[1], "On ")
+		}
+		if branch, message, found := strings.Cut(rest, ": "); ok && found {
+			entry.Branch = branch
+			entry.Message = message
+		}
+		stashes = append(stashes, entry)
+	}
+	return stashes
+}
+
+func getStashes(repo Repo) ([]StashEntry, error) {
+	output, err := executeGitCommand(repo, "stash", "list", "--format=%H%x1f%gs%x1f%ci")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, output)
+	}
+	return parseStashList(output), nil
+}
+
+// latestStash returns the commit refs/stash points at, or "" when there are no stashes
+func latestStash(repo Repo) string {
+	output, err := executeGitCommand(repo, "rev-parse", "-q", "--verify", "refs/stash")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// findStash returns the stash@{n} ref of the stash commit hash, which moves
+// down the list as other stashes are pushed
+func findStash(repo Repo, hash string) (string, bool) {
+	stashes, err := getStashes(repo)
+	if err != nil {
+		return "", false
+	}
+	for _, stash := range stashes {
+		if stash.Hash == hash {
+			return stash.Ref, true
+		}
+	}
+	return "", false
+}
+
+// requireStash resolves the stash with the given hash, as listed by /api/stash,
+// writing an error response if there is none. Unlike an index, the hash still
+// names the same stash after others were pushed or dropped.
+func requireStash(w http.ResponseWriter, repo Repo, hash string) (string, bool) {
+	if hash == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Stash hash is required",
+		})
+		return "", false
+	}
+	ref, ok := findStash(repo, hash)
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Stash %s no longer exists; reload the stash list", hash),
+		})
+		return "", false
+	}
+	return ref, true
+}
+
+// isWorkingTreeDirty reports whether there are staged, unstaged or untracked changes
+func isWorkingTreeDirty(repo Repo) (bool, error) {
+	output, err := executeGitCommand(repo, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return output != "", nil
+}
+
+func handleListStashes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	stashes, err := getStashes(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list stashes: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stashes": stashes,
+	})
+}
+
+func handleSaveStash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Message          string   `json:"message"`
+		IncludeUntracked bool     `json:"includeUntracked"`
+		KeepIndex        bool     `json:"keepIndex"`
+		Paths            []string `json:"paths"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	args := []string{"stash", "push"}
+	if req.Message != "" {
+		args = append(args, "-m", req.Message)
+	}
+	if req.IncludeUntracked {
+		args = append(args, "--include-untracked")
+	}
+	if req.KeepIndex {
+		args = append(args, "--keep-index")
+	}
+	if len(req.Paths) > 0 {
+		args = append(args, "--")
+		for _, p := range req.Paths {
+			resolved, ok := resolveRepoFilePath(repo, p)
+			if !ok || resolved == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(Response{
+					Error: fmt.Sprintf("Invalid path: %s", p),
+				})
+				return
+			}
+			args = append(args, resolved)
+		}
+	}
+
+	logs, output, err := runLoggedGit(repo, nil, args...)
+	if err == nil && strings.Contains(output, "No local changes to save") {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{
+			Error: "No local changes to stash",
+			Log:   logs,
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("git stash failed: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	logs = append(logs, "✓ Changes stashed!")
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+// handleApplyStash serves both /api/stash/apply and /api/stash/pop
+func handleApplyStash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Hash         string `json:"hash"`
+		RestoreIndex bool   `json:"restoreIndex"` // also restore what was staged (--index)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+	ref, ok := requireStash(w, repo, req.Hash)
+	if !ok {
+		return
+	}
+
+	action := "apply"
+	if strings.HasSuffix(r.URL.Path, "/pop") {
+		action = "pop"
+	}
+	args := []string{"stash", action}
+	if req.RestoreIndex {
+		args = append(args, "--index")
+	}
+	args = append(args, ref)
+
+	logs, output, err := runLoggedGit(repo, nil, args...)
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := fmt.Sprintf("git stash %s failed: %v", action, err)
+		if strings.Contains(output, "CONFLICT") {
+			status = http.StatusConflict
+			message = "Stash applied with conflicts; resolve them and stage the files"
+			if action == "pop" {
+				message += " (the stash was kept)"
+			}
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: message,
+			Log:   logs,
+		})
+		return
+	}
+
+	if action == "pop" {
+		logs = append(logs, "✓ Stash popped!")
+	} else {
+		logs = append(logs, "✓ Stash applied!")
+	}
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+func handleDropStash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+	ref, ok := requireStash(w, repo, req.Hash)
+	if !ok {
+		return
+	}
+
+	logs, _, err := runLoggedGit(repo, nil, "stash", "drop", ref)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("git stash drop failed: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	logs = append(logs, "✓ Stash dropped!")
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+// handleShowStash returns a stash's changes in the /api/diff format
+func handleShowStash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	// Once it is known to be a stash, the commit itself names it safely
+	hash := r.URL.Query().Get("hash")
+	if _, ok := requireStash(w, repo, hash); !ok {
+		return
+	}
+
+	args := []string{"stash", "show", hash}
+	// Stashes saved with --include-untracked keep untracked files in a third parent
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", hash+"^3"); err == nil {
+		args = append(args, "--include-untracked")
+	}
+
+	files, err := getDiff(repo, args, nil, 3, false)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to show stash: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": files,
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseStashList(t *testing.T) {
+	output := "aaa\x1fWIP on main: 1a2b3c4 Fix the parser\x1f2024-01-02 10:00:00 +0100\n" +
+		"bbb\x1fOn feature/x: AirGit: switching to main\x1f2024-01-01 09:00:00 +0100\n" +
+		"ccc\x1fautostash\x1f2023-12-31 08:00:00 +0100"
+
+	want := []StashEntry{
+		{Index: 0, Ref: "stash@{0}", Hash: "aaa", Branch: "main", Message: "1a2b3c4 Fix the parser", Date: "2024-01-02 10:00:00 +0100"},
+		{Index: 1, Ref: "stash@{1}", Hash: "bbb", Branch: "feature/x", Message: "AirGit: switching to main", Date: "2024-01-01 09:00:00 +0100"},
+		{Index: 2, Ref: "stash@{2}", Hash: "ccc", Message: "autostash", Date: "2023-12-31 08:00:00 +0100"},
+	}
+	got := parseStashList(output)
+	if len(got) != len(want) {
+		t.Fatalf("parseStashList returned %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestStashActionsByHash(t *testing.T) {
+	base := useTestBase(t)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	repo := Repo{Path: repoPath}
+	file := filepath.Join(repoPath, "file.txt")
+	stash := func(content string) string {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, repoPath, "stash", "push", "-q", "--include-untracked", "-m", content)
+		return latestStash(repo)
+	}
+	post := func(action, hash string) int {
+		r := httptest.NewRequest("POST", "/api/stash/"+action+"?repoPath=web", strings.NewReader(`{"hash":"`+hash+`"}`))
+		w := httptest.NewRecorder()
+		if action == "drop" {
+			handleDropStash(w, r)
+		} else {
+			handleApplyStash(w, r)
+		}
+		return w.Code
+	}
+
+	first := stash("first")
+	// Another stash is pushed after the client listed the first one as stash@{0}
+	second := stash("second")
+
+	show := func(hash string) (int, string) {
+		r := httptest.NewRequest("GET", "/api/stash/show?repoPath=web&hash="+hash, nil)
+		w := httptest.NewRecorder()
+		handleShowStash(w, r)
+		return w.Code, w.Body.String()
+	}
+	if code, body := show(first); code != 200 || !strings.Contains(body, `"content":"first"`) {
+		t.Errorf("showing the first stash returned %d: %s", code, body)
+	}
+
+	if code := post("pop", first); code != 200 {
+		t.Fatalf("pop returned %d", code)
+	}
+	if content, _ := os.ReadFile(file); string(content) != "first" {
+		t.Errorf("popped %q, want the first stash", content)
+	}
+	if stashes, _ := getStashes(repo); len(stashes) != 1 || stashes[0].Hash != second {
+		t.Errorf("stashes after pop = %+v, want only the second", stashes)
+	}
+
+	if code, _ := show(first); code != 409 {
+		t.Errorf("showing a popped stash returned %d, want 409", code)
+	}
+	if code := post("drop", first); code != 409 {
+		t.Errorf("dropping a popped stash returned %d, want 409", code)
+	}
+	if code := post("drop", ""); code != 400 {
+		t.Errorf("dropping without a hash returned %d, want 400", code)
+	}
+	if code := post("drop", second); code != 200 {
+		t.Errorf("drop returned %d", code)
+	}
+	if latestStash(repo) != "" {
+		t.Errorf("stash not dropped")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Submodule is a submodule of a repository. Recorded is the commit the
+// superproject's index points at, CheckedOut the commit checked out in the
+// submodule, which is empty while the submodule is not initialized.
+type Submodule struct {
+	Name         string `json:"name"`
+	Path         string `json:"path"`
+	URL          string `json:"url,omitempty"`
+	Branch       string `json:"branch,omitempty"` // branch followed by update --remote
+	Recorded     string `json:"recorded"`
+	CheckedOut   string `json:"checkedOut,omitempty"`
+	OutOfSync    bool   `json:"outOfSync"` // the checked-out commit differs from the recorded one
+	Conflicted   bool   `json:"conflicted,omitempty"`
+	Modified     bool   `json:"modified,omitempty"`  // tracked files have changes
+	Untracked    bool   `json:"untracked,omitempty"` // untracked files are present
+	RelativePath string `json:"relativePath,omitempty"`
+}
+
+func hasSubmodules(repo Repo) bool {
+	_, err := os.Stat(filepath.Join(repo.Path, ".gitmodules"))
+	return err == nil
+}
+
+// readGitmodules returns the name, url and branch of each submodule in .gitmodules, keyed by path
+func readGitmodules(repo Repo) map[string]Submodule {
+	submodules := make(map[string]Submodule)
+	output, err := executeGitCommand(repo, "config", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.(path|url|branch)$`)
+	if err != nil {
+		return submodules
+	}
+
+	byName := make(map[string]*Submodule)
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		key = strings.TrimPrefix(key, "submodule.")
+		dot := strings.LastIndex(key, ".")
+		if dot < 0 {
+			continue
+		}
+		name := key[:dot]
+		if byName[name] == nil {
+			byName[name] = &Submodule{Name: name}
+			names = append(names, name)
+		}
+		switch key[dot+1:] {
+		case "path":
+			byName[name].Path = value
+		case "url":
+			byName[name].URL = value
+		case "branch":
+			byName[name].Branch = value
+		}
+	}
+	for _, name := range names {
+		if sub := byName[name]; sub.Path != "" {
+			submodules[sub.Path] = *sub
+		}
+	}
+	return submodules
+}
+
+// getSubmodules lists the submodules recorded in the index of repo (not recursively)
+func getSubmodules(repo Repo) ([]Submodule, error) {
+	submodules := []Submodule{}
+	if !hasSubmodules(repo) {
+		return submodules, nil
+	}
+
+	// <mode> <object> <stage>\t<path>; submodules are gitlinks with mode 160000
+	output, err := executeGitCommand(repo, "ls-files", "--stage", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	gitmodules := readGitmodules(repo)
+	index := make(map[string]int)
+	for _, entry := range strings.Split(output, "\x00") {
+		info, path, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 || fields[0] != "160000" {
+			continue
+		}
+
+		i, seen := index[path]
+		if !seen {
+			sub, ok := gitmodules[path]
+			if !ok {
+				sub = Submodule{Name: path, Path: path}
+			}
+			submodules = append(submodules, sub)
+			i = len(submodules) - 1
+			index[path] = i
+		}
+		if fields[2] == "0" {
+			submodules[i].Recorded = fields[1]
+		} else {
+			submodules[i].Conflicted = true
+		}
+	}
+
+	for i := range submodules {
+		sub := &submodules[i]
+		subRepo := Repo{Path: filepath.Join(repo.Path, sub.Path)}
+		if !isGitRepo(subRepo.Path) {
+			continue
+		}
+		if head, err := executeGitCommand(subRepo, "rev-parse", "HEAD"); err == nil {
+			sub.CheckedOut = head
+			sub.OutOfSync = head != sub.Recorded
+		}
+		sub.RelativePath, _ = relativeToBase(subRepo.Path)
+	}
+
+	// Local changes inside the submodules show up in the superproject's status
+	if _, changes, err := getChanges(repo); err == nil {
+		for _, change := range changes {
+			if i, ok := index[change.Path]; ok && len(change.Submodule) == 4 {
+				submodules[i].Modified = change.Submodule[2] == 'M'
+				submodules[i].Untracked = change.Submodule[3] == 'U'
+			}
+		}
+	}
+	return submodules, nil
+}
+
+// updateSubmodules checks out the recorded commits of the given submodules
+// (all if none are given), initializing and recursing into them as needed.
+// With remote, they are updated to their remote branch instead.
+func updateSubmodules(repo Repo, logs []string, paths []string, remote bool) ([]string, error) {
+	args := []string{"submodule", "update", "--init", "--recursive"}
+	if remote {
+		args = append(args, "--remote")
+	}
+	if len(paths) > 0 {
+		args = append(append(args, "--"), paths...)
+	}
+	logs, _, err := runLoggedGit(repo, logs, args...)
+	return logs, err
+}
+
+// recurseSubmodules reports whether pull and checkout should update
+// submodules; override is the request's "true" or "false", if given
+func recurseSubmodules(repo Repo, override string) bool {
+	if override != "" {
+		return override == "true"
+	}
+	return getRepoSettings(repo).RecurseSubmodules && hasSubmodules(repo)
+}
+
+// handleSubmodules serves GET /api/submodules
+func handleSubmodules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	submodules, err := getSubmodules(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list submodules: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"submodules": submodules,
+	})
+}
+
+// handleSubmoduleAction serves POST /api/submodule/update and /api/submodule/sync
+func handleSubmoduleAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	action := strings.TrimPrefix(r.URL.Path, "/api/submodule/")
+	if action != "update" && action != "sync" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Paths  []string `json:"paths"`  // submodules to act on; all if empty
+		Remote bool     `json:"remote"` // update: follow the remote branch instead of the recorded commit
+	}
+
+	// The body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	submodules, err := getSubmodules(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list submodules: %v", err),
+		})
+		return
+	}
+	known := make(map[string]bool)
+	for _, sub := range submodules {
+		known[sub.Path] = true
+	}
+	for _, path := range req.Paths {
+		if !known[path] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Not a submodule: %s", path),
+			})
+			return
+		}
+	}
+
+	var logs []string
+	if action == "update" {
+		logs, err = updateSubmodules(repo, logs, req.Paths, req.Remote)
+	} else {
+		// Copies changed URLs from .gitmodules into the submodules' configuration
+		args := []string{"submodule", "sync", "--recursive"}
+		if len(req.Paths) > 0 {
+			args = append(append(args, "--"), req.Paths...)
+		}
+		logs, _, err = runLoggedGit(repo, logs, args...)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to %s submodules: %v", action, err),
+			Log:   logs,
+		})
+		return
+	}
+
+	submodules, _ = getSubmodules(repo)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"submodules": submodules,
+		"log":        append(logs, map[string]string{"update": "✓ Submodules updated!", "sync": "✓ Submodules synced!"}[action]),
+	})
+}
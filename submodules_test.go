@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetSubmodules(t *testing.T) {
+	base := useTestBase(t)
+	lib := filepath.Join(base, "lib")
+	initTestRepo(t, lib)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	runGit(t, repoPath, "-c", "protocol.file.allow=always", "submodule", "add", "-q", "--name", "shared", "-b", "main", lib, "vendor/lib")
+	runGit(t, repoPath, "commit", "-q", "-m", "Add lib")
+	repo := Repo{Path: repoPath}
+	subPath := filepath.Join(repoPath, "vendor", "lib")
+	recorded, _ := executeGitCommand(repo, "rev-parse", "HEAD:vendor/lib")
+
+	get := func() Submodule {
+		t.Helper()
+		submodules, err := getSubmodules(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(submodules) != 1 {
+			t.Fatalf("got %d submodules, want 1: %+v", len(submodules), submodules)
+		}
+		return submodules[0]
+	}
+
+	sub := get()
+	if sub.Name != "shared" || sub.Path != "vendor/lib" || sub.URL != lib || sub.Branch != "main" || sub.RelativePath != "web/vendor/lib" {
+		t.Errorf("submodule = %+v", sub)
+	}
+	if sub.Recorded != recorded || sub.CheckedOut != recorded || sub.OutOfSync || sub.Modified || sub.Untracked {
+		t.Errorf("freshly added submodule = %+v, want %s checked out", sub, recorded)
+	}
+
+	// A new commit in the submodule that the superproject does not record yet
+	runGit(t, subPath, "commit", "-q", "--allow-empty", "-m", "ahead")
+	ahead, _ := executeGitCommand(Repo{Path: subPath}, "rev-parse", "HEAD")
+	if err := os.WriteFile(filepath.Join(subPath, "scratch.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub = get()
+	if sub.Recorded != recorded || sub.CheckedOut != ahead || !sub.OutOfSync || sub.Modified || !sub.Untracked {
+		t.Errorf("submodule ahead of the recorded commit = %+v, want %s checked out", sub, ahead)
+	}
+
+	// Recording the new commit brings them back in sync
+	if err := os.Remove(filepath.Join(subPath, "scratch.txt")); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoPath, "commit", "-q", "-am", "Update lib")
+	sub = get()
+	if sub.Recorded != ahead || sub.CheckedOut != ahead || sub.OutOfSync || sub.Untracked {
+		t.Errorf("submodule after recording = %+v, want %s", sub, ahead)
+	}
+
+	// An uninitialized submodule has nothing checked out
+	runGit(t, repoPath, "submodule", "deinit", "-q", "--force", "vendor/lib")
+	sub = get()
+	if sub.Recorded != ahead || sub.CheckedOut != "" || sub.OutOfSync {
+		t.Errorf("deinitialized submodule = %+v", sub)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TagInfo describes a tag. Lightweight tags only have Name, Target and Date;
+// Object, Tagger, Body and Signed are set for annotated tags.
+type TagInfo struct {
+	Name        string        `json:"name"`
+	Annotated   bool          `json:"annotated"`
+	Target      string        `json:"target"`           // the tagged commit
+	Object      string        `json:"object,omitempty"` // the tag object of an annotated tag
+	Tagger      string        `json:"tagger,omitempty"`
+	TaggerEmail string        `json:"taggerEmail,omitempty"`
+	Date        string        `json:"date"` // tagger date, or the commit date of lightweight tags
+	Subject     string        `json:"subject"`
+	Body        string        `json:"body,omitempty"`
+	Signed      bool          `json:"signed,omitempty"`
+	Signature   *TagSignature `json:"signature,omitempty"` // only from /api/tag/show
+}
+
+// TagSignature is the result of `git verify-tag`
+type TagSignature struct {
+	Status string `json:"status"` // good, bad or unverified (e.g. unknown key or no gpg on the server)
+	Signer string `json:"signer,omitempty"`
+	Output string `json:"output"`
+}
+
+// Records are separated by %1e since tag messages span several lines
+const tagListFormat = "%(refname:short)%1f%(objecttype)%1f%(objectname)%1f%(*objectname)%1f%(taggername)%1f%(taggeremail)%1f%(creatordate:iso-strict)%1f%(contents:subject)%1f%(contents:body)%1f%(contents:signature)%1e"
+
+// getTagDetails lists tags newest first, or only those under the given names
+func getTagDetails(repo Repo, names ...string) ([]TagInfo, error) {
+	args := []string{"for-each-ref", "--sort=-creatordate", "--format=" + tagListFormat}
+	if len(names) == 0 {
+		args = append(args, "refs/tags")
+	}
+	for _, name := range names {
+		args = append(args, "refs/tags/"+name)
+	}
+	output, err := executeGitCommand(repo, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, output)
+	}
+
+	tags := []TagInfo{}
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.Split(strings.TrimPrefix(record, "\n"), "\x1f")
+		if len(fields) < 10 {
+			continue
+		}
+		tag := TagInfo{
+			Name:      fields[0],
+			Annotated: fields[1] == "tag",
+			Target:    fields[2],
+			Date:      fields[6],
+			Subject:   fields[7],
+		}
+		if tag.Annotated {
+			tag.Object, tag.Target = fields[2], fields[3]
+			tag.Tagger = fields[4]
+			tag.TaggerEmail = strings.Trim(fields[5], "<>")
+			tag.Body = strings.TrimSpace(fields[8])
+			tag.Signed = fields[9] != ""
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// verifyTag checks the signature of a signed tag
+func verifyTag(repo Repo, name string) *TagSignature {
+	output, err := executeGitCommand(repo, "verify-tag", "refs/tags/"+name)
+	signature := &TagSignature{Status: "unverified", Output: output}
+	for _, line := range strings.Split(output, "\n") {
+		// gpg: Good signature from "Jane <jane@example.com>" [ultimate]
+		// Good "git" signature for jane@example.com with ED25519 key SHA256:...
+		if _, rest, ok := strings.Cut(line, "Good signature from "); ok {
+			signature.Signer = strings.Trim(strings.SplitN(rest, "\" [", 2)[0], "\"")
+		} else if _, rest, ok := strings.Cut(line, "signature for "); ok && strings.HasPrefix(line, "Good") {
+			signature.Signer = strings.SplitN(rest, " with ", 2)[0]
+		}
+		if strings.Contains(line, "BAD signature") || strings.Contains(line, "Bad signature") {
+			signature.Status = "bad"
+		}
+	}
+	if err == nil && signature.Status != "bad" {
+		signature.Status = "good"
+	}
+	return signature
+}
+
+// getRemoteTags returns the tags on remote with the object each points at
+func getRemoteTags(repo Repo, remote string) (map[string]string, error) {
+	output, err := executeGitCommand(repo, "ls-remote", "--tags", remote)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, output)
+	}
+
+	tags := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		hash, ref, ok := strings.Cut(line, "\t")
+		name, isTag := strings.CutPrefix(ref, "refs/tags/")
+		// Skip the peeled name^{} entries of annotated tags
+		if !ok || !isTag || strings.HasSuffix(name, "^{}") {
+			continue
+		}
+		tags[name] = hash
+	}
+	return tags, nil
+}
+
+// handleShowTag serves GET /api/tag/show?name=v1.0, including signature verification
+func handleShowTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if !isValidRef(name) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid tag name",
+		})
+		return
+	}
+
+	tags, err := getTagDetails(repo, name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to read tag: %v", err),
+		})
+		return
+	}
+	// The name is matched as a prefix, so also lists tags under name/
+	var tag TagInfo
+	for _, t := range tags {
+		if t.Name == name {
+			tag = t
+		}
+	}
+	if tag.Name == "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Tag not found",
+		})
+		return
+	}
+
+	if tag.Signed {
+		tag.Signature = verifyTag(repo, tag.Name)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tag": tag,
+	})
+}
+
+// handleDeleteTag deletes a local tag, or with remote set, the tag on that remote
+func handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TagName string `json:"tagName"`
+		Remote  string `json:"remote"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if !isValidRef(req.TagName) || (req.Remote != "" && !isValidRef(req.Remote)) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid tag name or remote",
+		})
+		return
+	}
+
+	var logs []string
+	var err error
+	if req.Remote == "" {
+		if _, verifyErr := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "refs/tags/"+req.TagName); verifyErr != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Tag not found",
+			})
+			return
+		}
+		logs, _, err = runLoggedGit(repo, logs, "tag", "-d", req.TagName)
+	} else {
+		logs, _, err = runLoggedGit(repo, logs, "push", req.Remote, "--delete", "refs/tags/"+req.TagName)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to delete tag: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+
+	logs = append(logs, fmt.Sprintf("✓ Tag '%s' deleted!", req.TagName))
+	json.NewEncoder(w).Encode(Response{
+		Log: logs,
+	})
+}
+
+// handleCompareTags serves GET /api/tags/compare?remote=origin, listing tags
+// that exist only locally (not pushed), only on the remote, or on both but
+// pointing at different objects
+func handleCompareTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	remote := r.URL.Query().Get("remote")
+	if remote == "" {
+		remote = "origin"
+	}
+	if !isValidRef(remote) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid remote",
+		})
+		return
+	}
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	remoteTags, err := getRemoteTags(repo, remote)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list tags on %s: %v", remote, err),
+		})
+		return
+	}
+	localTags, err := getTagDetails(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list tags: %v", err),
+		})
+		return
+	}
+
+	type tagDifference struct {
+		Name   string `json:"name"`
+		Local  string `json:"local"`
+		Remote string `json:"remote"`
+	}
+	localOnly := []string{}
+	remoteOnly := []string{}
+	differ := []tagDifference{}
+	synced := 0
+	for _, tag := range localTags {
+		local := tag.Target
+		if tag.Annotated {
+			local = tag.Object
+		}
+		remoteHash, found := remoteTags[tag.Name]
+		switch {
+		case !found:
+			localOnly = append(localOnly, tag.Name)
+		case remoteHash != local:
+			differ = append(differ, tagDifference{Name: tag.Name, Local: local, Remote: remoteHash})
+		default:
+			synced++
+		}
+		delete(remoteTags, tag.Name)
+	}
+	for name := range remoteTags {
+		remoteOnly = append(remoteOnly, name)
+	}
+	sort.Strings(remoteOnly)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"remote":     remote,
+		"localOnly":  localOnly,
+		"remoteOnly": remoteOnly,
+		"differ":     differ,
+		"synced":     synced,
+	})
+}
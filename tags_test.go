@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetTagDetails(t *testing.T) {
+	repoPath := filepath.Join(useTestBase(t), "web")
+	initTestRepo(t, repoPath)
+	runGit(t, repoPath, "tag", "v1.0")
+	runGit(t, repoPath, "tag", "-a", "v1.1", "-m", "Release 1.1\n\nAdds search.")
+	repo := Repo{Path: repoPath}
+	head, _ := executeGitCommand(repo, "rev-parse", "HEAD")
+	object, _ := executeGitCommand(repo, "rev-parse", "refs/tags/v1.1")
+
+	tags, err := getTagDetails(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]TagInfo)
+	for _, tag := range tags {
+		byName[tag.Name] = tag
+	}
+	if len(tags) != 2 {
+		t.Fatalf("got %d tags, want 2: %+v", len(tags), tags)
+	}
+
+	lightweight := byName["v1.0"]
+	if lightweight.Annotated || lightweight.Target != head || lightweight.Object != "" || lightweight.Tagger != "" || lightweight.Subject != "initial" || lightweight.Date == "" {
+		t.Errorf("lightweight tag = %+v", lightweight)
+	}
+	annotated := byName["v1.1"]
+	if !annotated.Annotated || annotated.Target != head || annotated.Object != object || annotated.Object == head {
+		t.Errorf("annotated tag = %+v, want target %s and object %s", annotated, head, object)
+	}
+	if annotated.Tagger != "test" || annotated.TaggerEmail != "test@example.com" || annotated.Subject != "Release 1.1" || annotated.Body != "Adds search." || annotated.Signed {
+		t.Errorf("annotated tag = %+v", annotated)
+	}
+}
+
+func TestHandleShowTag(t *testing.T) {
+	repoPath := filepath.Join(useTestBase(t), "web")
+	initTestRepo(t, repoPath)
+	runGit(t, repoPath, "tag", "-a", "release/1.0", "-m", "Release")
+
+	tests := []struct {
+		name string
+		code int
+	}{
+		{"release/1.0", 200},
+		{"v9.9", 404},
+		// Only a prefix of an existing tag
+		{"release", 404},
+		{"--sort=x", 400},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/tag/show?repoPath=web&name="+tt.name, nil)
+		w := httptest.NewRecorder()
+		handleShowTag(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d (%s), want %d", tt.name, w.Code, w.Body.String(), tt.code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+		var response struct {
+			Tag TagInfo `json:"tag"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Tag.Name != tt.name || !response.Tag.Annotated || response.Tag.Signature != nil {
+			t.Errorf("%s: tag = %+v", tt.name, response.Tag)
+		}
+	}
+}
+
+func TestHandleCreateTagAtCommit(t *testing.T) {
+	repoPath := filepath.Join(useTestBase(t), "web")
+	initTestRepo(t, repoPath)
+	repo := Repo{Path: repoPath}
+	first, _ := executeGitCommand(repo, "rev-parse", "HEAD")
+	runGit(t, repoPath, "commit", "-q", "--allow-empty", "-m", "second")
+
+	create := func(body string) int {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/api/tag/create?repoPath=web", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handleCreateTag(w, r)
+		return w.Code
+	}
+	if code := create(`{"tagName":"v1.0","commit":"HEAD~1"}`); code != 200 {
+		t.Fatalf("creating a lightweight tag returned %d", code)
+	}
+	if code := create(`{"tagName":"v1.0-notes","message":"First","commit":"` + first + `"}`); code != 200 {
+		t.Fatalf("creating an annotated tag returned %d", code)
+	}
+	for _, name := range []string{"v1.0", "v1.0-notes"} {
+		if target, _ := executeGitCommand(repo, "rev-parse", name+"^{commit}"); target != first {
+			t.Errorf("%s points at %s, want %s", name, target, first)
+		}
+	}
+
+	if code := create(`{"tagName":"v2.0","commit":"no-such-commit"}`); code != 400 {
+		t.Errorf("tagging a missing commit returned %d, want 400", code)
+	}
+	if code := create(`{"tagName":"v2.0","commit":"--all"}`); code != 400 {
+		t.Errorf("tagging an option returned %d, want 400", code)
+	}
+	if _, err := executeGitCommand(repo, "rev-parse", "--verify", "--quiet", "refs/tags/v2.0"); err == nil {
+		t.Errorf("v2.0 was created")
+	}
+}
+
+func TestHandleCompareTags(t *testing.T) {
+	base := useTestBase(t)
+	repoPath := filepath.Join(base, "web")
+	initTestRepo(t, repoPath)
+	remotePath := filepath.Join(base, "remote.git")
+	runGit(t, base, "init", "-q", "--bare", remotePath)
+	runGit(t, repoPath, "remote", "add", "origin", remotePath)
+
+	runGit(t, repoPath, "tag", "synced-light")
+	runGit(t, repoPath, "tag", "-a", "synced-annotated", "-m", "Synced")
+	runGit(t, repoPath, "tag", "-a", "moved", "-m", "Before")
+	runGit(t, repoPath, "tag", "gone")
+	runGit(t, repoPath, "push", "-q", "origin", "--tags")
+	// Retag locally, drop one tag pushed earlier and add one never pushed
+	runGit(t, repoPath, "tag", "-f", "-a", "moved", "-m", "After")
+	runGit(t, repoPath, "tag", "-d", "gone")
+	runGit(t, repoPath, "tag", "unpushed")
+	repo := Repo{Path: repoPath}
+	moved, _ := executeGitCommand(repo, "rev-parse", "refs/tags/moved")
+	pushedMoved, _ := executeGitCommand(Repo{Path: remotePath}, "rev-parse", "refs/tags/moved")
+
+	r := httptest.NewRequest("GET", "/api/tags/compare?repoPath=web&remote=origin", nil)
+	w := httptest.NewRecorder()
+	handleCompareTags(w, r)
+	if w.Code != 200 {
+		t.Fatalf("compare returned %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		LocalOnly  []string `json:"localOnly"`
+		RemoteOnly []string `json:"remoteOnly"`
+		Differ     []struct {
+			Name   string `json:"name"`
+			Local  string `json:"local"`
+			Remote string `json:"remote"`
+		} `json:"differ"`
+		Synced int `json:"synced"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(response.LocalOnly, " ") != "unpushed" || strings.Join(response.RemoteOnly, " ") != "gone" {
+		t.Errorf("localOnly = %v, remoteOnly = %v", response.LocalOnly, response.RemoteOnly)
+	}
+	if len(response.Differ) != 1 || response.Differ[0].Name != "moved" || response.Differ[0].Local != moved || response.Differ[0].Remote != pushedMoved {
+		t.Errorf("differ = %+v, want moved %s on the remote %s", response.Differ, moved, pushedMoved)
+	}
+	if response.Synced != 2 {
+		t.Errorf("synced = %d, want 2", response.Synced)
+	}
+
+	r = httptest.NewRequest("GET", "/api/tags/compare?repoPath=web&remote=missing", nil)
+	w = httptest.NewRecorder()
+	handleCompareTags(w, r)
+	if w.Code != 502 {
+		t.Errorf("comparing with a missing remote returned %d, want 502", w.Code)
+	}
+}
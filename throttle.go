@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed logins and setup attempts are throttled per client IP and per
+// username: after freeLoginFailures, each failure blocks further attempts for
+// twice as long as the one before, up to maxLoginDelay. Signing in clears them.
+const (
+	freeLoginFailures = 5
+	minLoginDelay     = time.Second
+	maxLoginDelay     = 15 * time.Minute
+	// Failures are forgotten after this long without another one
+	loginFailureMemory = time.Hour
+)
+
+type loginFailures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+var (
+	loginFailureMutex sync.Mutex
+	loginFailureLog   = make(map[string]*loginFailures)
+)
+
+// loginThrottleKeys returns the keys failures of r are counted under. The
+// client IP is the connecting address; X-Forwarded-For is not trusted.
+func loginThrottleKeys(r *http.Request, username string) []string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	keys := []string{"ip:" + ip}
+	if username != "" {
+		keys = append(keys, "user:"+strings.ToLower(username))
+	}
+	return keys
+}
+
+// loginRetryAfter returns how long r has to wait before trying to sign in as username again
+func loginRetryAfter(r *http.Request, username string) time.Duration {
+	loginFailureMutex.Lock()
+	defer loginFailureMutex.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range loginThrottleKeys(r, username) {
+		if f := loginFailureLog[key]; f != nil && f.blockedUntil.After(now) {
+			wait = max(wait, f.blockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed attempt of r to sign in as username
+func recordLoginFailure(r *http.Request, username string) {
+	loginFailureMutex.Lock()
+	defer loginFailureMutex.Unlock()
+
+	now := time.Now()
+	for key, f := range loginFailureLog {
+		if now.Sub(f.last) > loginFailureMemory {
+			delete(loginFailureLog, key)
+		}
+	}
+	for _, key := range loginThrottleKeys(r, username) {
+		f := loginFailureLog[key]
+		if f == nil {
+			f = &loginFailures{}
+			loginFailureLog[key] = f
+		}
+		f.count++
+		f.last = now
+		if f.count > freeLoginFailures {
+			delay := maxLoginDelay
+			if shift := f.count - freeLoginFailures - 1; shift < 20 {
+				delay = min(minLoginDelay<<shift, maxLoginDelay)
+			}
+			f.blockedUntil = now.Add(delay)
+		}
+	}
+}
+
+// clearLoginFailures forgets the failures of r's client IP and username once it signs in
+func clearLoginFailures(r *http.Request, username string) {
+	loginFailureMutex.Lock()
+	defer loginFailureMutex.Unlock()
+	for _, key := range loginThrottleKeys(r, username) {
+		delete(loginFailureLog, key)
+	}
+}
+
+// writeLoginThrottled responds 429 with the time to wait
+func writeLoginThrottled(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(Response{
+		Error: fmt.Sprintf("Too many failed attempts; try again in %d seconds", seconds),
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("right"), bcrypt.MinCost)
+	useTestUsers(t, User{Username: "alice", PasswordHash: string(hash)})
+	t.Cleanup(func() {
+		loginFailureMutex.Lock()
+		loginFailureLog = make(map[string]*loginFailures)
+		loginFailureMutex.Unlock()
+	})
+
+	login := func(remoteAddr, username, password string) int {
+		r := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handleLogin(w, r)
+		return w.Code
+	}
+
+	for i := 0; i <= freeLoginFailures; i++ {
+		if code := login("192.0.2.1:1000", "alice", "wrong"); code != 401 {
+			t.Fatalf("failure %d returned %d, want 401", i+1, code)
+		}
+	}
+	// Blocked now, even with the right password
+	if code := login("192.0.2.1:1001", "alice", "right"); code != 429 {
+		t.Errorf("login after %d failures returned %d, want 429", freeLoginFailures+1, code)
+	}
+	// The username is blocked from other addresses too
+	if code := login("198.51.100.7:1000", "alice", "right"); code != 429 {
+		t.Errorf("login as alice from another address returned %d, want 429", code)
+	}
+	// Other users from other addresses are not
+	if code := login("198.51.100.7:1000", "bob", "wrong"); code != 401 {
+		t.Errorf("login as bob from another address returned %d, want 401", code)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIToken lets scripts call the API with an "Authorization: Bearer" header
+// instead of a session cookie. Only the SHA-256 hash of the token is stored;
+// the token itself is shown once, when it is created.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"` // the user the token acts as
+	Hash       string     `json:"hash,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+const (
+	tokenPrefix          = "airgit_"
+	defaultTokenLifetime = 30 // days
+	maxTokenLifetime     = 365
+	// How often the last-used time is written to the auth file, at most
+	lastUsedPrecision = time.Minute
+)
+
+// tokenScopes are the scopes a token can be given, with what they allow
+var tokenScopes = map[string]string{
+	"repo:read":     "read repositories (GET requests)",
+	"repo:write":    "change repositories: push, pull, commit, checkout, ... (implies repo:read)",
+	"agent:run":     "trigger and monitor the issue agent",
+	"admin:systemd": "manage the systemd service, including rebuild and restart",
+	"admin:users":   "manage users",
+	"admin:audit":   "read the audit log",
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requiredScope returns the scope a token needs for r, or "" for endpoints
+// that only work with a session, like creating tokens
+func requiredScope(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/tokens"), strings.HasPrefix(path, "/api/auth/"):
+		return ""
+	case strings.HasPrefix(path, "/api/agent/"):
+		return "agent:run"
+	case strings.HasPrefix(path, "/api/systemd/"):
+		return "admin:systemd"
+	case path == "/api/users" || strings.HasPrefix(path, "/api/users/"):
+		return "admin:users"
+	case path == "/api/audit":
+		return "admin:audit"
+	// Checks out the branch it is given
+	case path == "/api/load-repo":
+		return "repo:write"
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return "repo:read"
+	default:
+		return "repo:write"
+	}
+}
+
+func (t APIToken) allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || (s == "repo:write" && scope == "repo:read") {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateToken looks up a bearer token and records that it was used
+func authenticateToken(token string) (APIToken, error) {
+	hash := hashToken(token)
+
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	for i := range authData.Tokens {
+		t := &authData.Tokens[i]
+		if t.Hash != hash {
+			continue
+		}
+		now := time.Now().UTC()
+		if now.After(t.ExpiresAt) {
+			return APIToken{}, fmt.Errorf("API token %q expired on %s", t.Name, t.ExpiresAt.Format("2006-01-02"))
+		}
+		if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= lastUsedPrecision {
+			t.LastUsedAt = &now
+			if err := saveAuth(); err != nil {
+				log.Printf("Failed to record API token use: %v", err)
+			}
+		}
+		return *t, nil
+	}
+	return APIToken{}, errors.New("invalid API token")
+}
+
+// userTokens returns the tokens of username without their hashes; callers hold authMutex
+func userTokens(username string) []APIToken {
+	tokens := []APIToken{}
+	for _, t := range authData.Tokens {
+		if t.Username == username {
+			t.Hash = ""
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// deleteUserTokens revokes all tokens of username; callers hold authMutex and save
+func deleteUserTokens(username string) {
+	tokens := authData.Tokens[:0:0]
+	for _, t := range authData.Tokens {
+		if t.Username != username {
+			tokens = append(tokens, t)
+		}
+	}
+	authData.Tokens = tokens
+}
+
+// handleListTokens serves GET /api/tokens, listing the signed-in user's tokens
+func handleListTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	authMutex.Lock()
+	tokens := userTokens(currentUser(r))
+	authMutex.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens": tokens,
+		"scopes": tokenScopes,
+	})
+}
+
+// handleCreateToken serves POST /api/tokens/create. The response holds the
+// only copy of the token.
+func handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	username := currentUser(r)
+	if username == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "API tokens need authentication to be enabled",
+		})
+		return
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expiresInDays"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "A name of up to 100 characters is required",
+		})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "At least one scope is required",
+		})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if tokenScopes[scope] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Unknown scope: %s", scope),
+			})
+			return
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultTokenLifetime
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxTokenLifetime {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("expiresInDays must be between 1 and %d", maxTokenLifetime),
+		})
+		return
+	}
+
+	secret := tokenPrefix + randomToken(32)
+	now := time.Now().UTC()
+	token := APIToken{
+		ID:        randomToken(6),
+		Name:      req.Name,
+		Username:  username,
+		Hash:      hashToken(secret),
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, req.ExpiresInDays),
+	}
+
+	authMutex.Lock()
+	authData.Tokens = append(authData.Tokens, token)
+	err := saveAuth()
+	if err != nil {
+		authData.Tokens = authData.Tokens[:len(authData.Tokens)-1]
+	}
+	authMutex.Unlock()
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to save token: %v", err),
+		})
+		return
+	}
+
+	log.Printf("API token %q (%s) created by %s with scopes %s", token.Name, token.ID, username, strings.Join(token.Scopes, ","))
+	token.Hash = ""
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token": secret,
+		"info":  token,
+	})
+}
+
+// handleRevokeToken serves POST /api/tokens/revoke
+func handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	authMutex.Lock()
+	defer authMutex.Unlock()
+
+	username := currentUser(r)
+	tokens := authData.Tokens
+	for i, t := range tokens {
+		if t.ID != req.ID || t.Username != username {
+			continue
+		}
+		authData.Tokens = append(append([]APIToken{}, tokens[:i]...), tokens[i+1:]...)
+		if err := saveAuth(); err != nil {
+			authData.Tokens = tokens
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to revoke token: %v", err),
+			})
+			return
+		}
+		log.Printf("API token %q (%s) revoked by %s", t.Name, t.ID, username)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(Response{
+		Error: "Token not found",
+	})
+}
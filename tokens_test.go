@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method, path, scope string
+	}{
+		{"GET", "/api/status", "repo:read"},
+		{"HEAD", "/api/branches", "repo:read"},
+		{"POST", "/api/push", "repo:write"},
+		{"POST", "/api/load-repo", "repo:write"},
+		{"POST", "/api/agent/trigger", "agent:run"},
+		{"GET", "/api/systemd/status", "admin:systemd"},
+		{"GET", "/api/users", "admin:users"},
+		{"POST", "/api/users/create", "admin:users"},
+		{"GET", "/api/audit", "admin:audit"},
+		{"POST", "/api/tokens/create", ""},
+		{"POST", "/api/auth/logout", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := requiredScope(r); got != tt.scope {
+			t.Errorf("requiredScope(%s %s) = %q, want %q", tt.method, tt.path, got, tt.scope)
+		}
+	}
+}
+
+func TestAPITokenAllows(t *testing.T) {
+	tests := []struct {
+		scopes  []string
+		scope   string
+		allowed bool
+	}{
+		{[]string{"repo:read"}, "repo:read", true},
+		{[]string{"repo:read"}, "repo:write", false},
+		{[]string{"repo:write"}, "repo:read", true},
+		{[]string{"repo:write"}, "admin:users", false},
+		{[]string{"agent:run", "admin:audit"}, "admin:audit", true},
+		{nil, "repo:read", false},
+	}
+	for _, tt := range tests {
+		if got := (APIToken{Scopes: tt.scopes}).allows(tt.scope); got != tt.allowed {
+			t.Errorf("%v allows %s = %v, want %v", tt.scopes, tt.scope, got, tt.allowed)
+		}
+	}
+}
+
+// createTestToken creates a token for username through the API and returns its secret and ID
+func createTestToken(t *testing.T, username, body string) (string, string) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/tokens/create", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, username))
+	w := httptest.NewRecorder()
+	handleCreateToken(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating token returned %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Token string   `json:"token"`
+		Info  APIToken `json:"info"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(response.Token, tokenPrefix) || response.Info.Hash != "" {
+		t.Fatalf("created token = %q, info %+v", response.Token, response.Info)
+	}
+	return response.Token, response.Info.ID
+}
+
+func TestRequireAuthTokens(t *testing.T) {
+	base := useTestBase(t)
+	config.AuthDisabled = false
+	config.AuthFile = filepath.Join(t.TempDir(), "auth.json")
+	initTestRepo(t, filepath.Join(base, "web"))
+	useTestUsers(t, User{Username: "dev", Roles: []RoleGrant{{Repos: allRepos, Role: "developer"}}})
+
+	readToken, _ := createTestToken(t, "dev", `{"name":"ci","scopes":["repo:read"]}`)
+	writeToken, writeID := createTestToken(t, "dev", `{"name":"deploy","scopes":["repo:write"]}`)
+
+	// Tokens are stored only as hashes
+	authMutex.Lock()
+	for _, token := range authData.Tokens {
+		if token.Hash != hashToken(readToken) && token.Hash != hashToken(writeToken) {
+			t.Errorf("stored hash %q matches neither token", token.Hash)
+		}
+	}
+	authData.Tokens = append(authData.Tokens, APIToken{
+		ID: "old", Name: "old", Username: "dev", Hash: hashToken("airgit_expired"),
+		Scopes: []string{"repo:write"}, ExpiresAt: time.Now().Add(-time.Hour),
+	})
+	authMutex.Unlock()
+
+	var user string
+	handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = currentUser(r)
+	}))
+	request := func(method, target, token string) int {
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		user = ""
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		name, method, target, token string
+		code                        int
+	}{
+		{"read scope reads", "GET", "/api/status?repoPath=web", readToken, http.StatusOK},
+		{"read scope cannot write", "POST", "/api/push?repoPath=web", readToken, http.StatusForbidden},
+		{"write scope writes", "POST", "/api/push?repoPath=web", writeToken, http.StatusOK},
+		{"write scope implies read", "GET", "/api/status?repoPath=web", writeToken, http.StatusOK},
+		{"admin scope missing", "GET", "/api/audit", writeToken, http.StatusForbidden},
+		{"tokens cannot manage tokens", "POST", "/api/tokens/create", writeToken, http.StatusForbidden},
+		{"unknown token", "GET", "/api/status?repoPath=web", "airgit_unknown", http.StatusUnauthorized},
+		{"expired token", "GET", "/api/status?repoPath=web", "airgit_expired", http.StatusUnauthorized},
+		{"no credentials", "GET", "/api/status?repoPath=web", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if code := request(tt.method, tt.target, tt.token); code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, code, tt.code)
+		}
+		if tt.code == http.StatusOK && user != "dev" {
+			t.Errorf("%s: handler ran as %q, want dev", tt.name, user)
+		}
+	}
+
+	// Only the owner can revoke a token
+	revoke := func(username string) int {
+		r := httptest.NewRequest("POST", "/api/tokens/revoke", strings.NewReader(`{"id":"`+writeID+`"}`))
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, username))
+		w := httptest.NewRecorder()
+		handleRevokeToken(w, r)
+		return w.Code
+	}
+	if code := revoke("someone-else"); code != http.StatusNotFound {
+		t.Errorf("revoking another user's token returned %d, want 404", code)
+	}
+	if code := revoke("dev"); code != http.StatusOK {
+		t.Fatalf("revoking token returned %d", code)
+	}
+	if code := request("POST", "/api/push?repoPath=web", writeToken); code != http.StatusUnauthorized {
+		t.Errorf("revoked token got %d, want 401", code)
+	}
+	if code := request("GET", "/api/status?repoPath=web", readToken); code != http.StatusOK {
+		t.Errorf("remaining token got %d after revoking another, want 200", code)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Worktree is a working tree of a repository as listed by the API. Path is the
+// absolute path git reports; RelativePath is the repoPath that opens it, and
+// is empty when the worktree lies outside the base path.
+type Worktree struct {
+	WorktreeInfo
+	RelativePath string `json:"relativePath,omitempty"`
+	Main         bool   `json:"main,omitempty"`    // the repository's main working tree
+	Current      bool   `json:"current,omitempty"` // the worktree the request was made from
+}
+
+// relativeToBase returns path relative to the base repository path, if it lies beneath it
+func relativeToBase(path string) (string, bool) {
+	path, _ = filepath.Abs(path)
+	base, _ := filepath.Abs(baseRepoPath)
+	if !isWithinBase(path, base) {
+		// git reports real paths, the base path may go through a symlink
+		real, err := filepath.EvalSymlinks(base)
+		if err != nil || !isWithinBase(path, real) {
+			return "", false
+		}
+		base = real
+	}
+	rel, err := filepath.Rel(base, path)
+	return rel, err == nil
+}
+
+func samePath(a, b string) bool {
+	if a == b {
+		return true
+	}
+	realA, errA := filepath.EvalSymlinks(a)
+	realB, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && realA == realB
+}
+
+// listWorktrees returns the main worktree first, followed by the linked ones
+func listWorktrees(repo Repo) ([]Worktree, error) {
+	infos, err := gitBackend.Worktrees(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	worktrees := make([]Worktree, 0, len(infos))
+	for i, info := range infos {
+		worktree := Worktree{
+			WorktreeInfo: info,
+			Main:         i == 0,
+			Current:      samePath(info.Path, repo.Path),
+		}
+		worktree.RelativePath, _ = relativeToBase(info.Path)
+		worktrees = append(worktrees, worktree)
+	}
+	return worktrees, nil
+}
+
+// findWorktree looks up a worktree of repo by its absolute path or its path relative to the base path
+func findWorktree(repo Repo, path string) (Worktree, bool) {
+	worktrees, err := listWorktrees(repo)
+	if err != nil || path == "" {
+		return Worktree{}, false
+	}
+	for _, worktree := range worktrees {
+		if samePath(worktree.Path, path) || (worktree.RelativePath != "" && worktree.RelativePath == filepath.Clean(path)) {
+			return worktree, true
+		}
+	}
+	return Worktree{}, false
+}
+
+// worktreesDir returns the directory new worktrees of the repository at mainPath
+// go in: <repository>.worktrees next to it, or a directory in its git directory
+// when the base path is the repository itself and its neighbours are out of reach
+func worktreesDir(mainPath string) string {
+	dir := filepath.Clean(mainPath) + ".worktrees"
+	if _, ok := relativeToBase(dir); ok {
+		return dir
+	}
+	gitDir := filepath.Join(mainPath, ".git")
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		gitDir = mainPath // a bare repository
+	}
+	return filepath.Join(gitDir, "airgit-worktrees")
+}
+
+// resolveNewWorktreePath resolves a path for a new worktree, which must not
+// exist yet and must stay within dir (the repository's worktrees directory)
+// even through symlinks. Keeping worktrees there keeps them next to the
+// repository whose roles apply to them.
+func resolveNewWorktreePath(path, dir string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseRepoPath, path)
+	}
+	within := dir
+	if rel, ok := relativeToBase(dir); ok {
+		within = rel
+	}
+	outside := fmt.Errorf("path must be within %s", filepath.ToSlash(within))
+	path, err := filepath.Abs(path)
+	if err != nil || !isWithinBase(path, baseRepoPath) || !isWithinBase(path, dir) || path == filepath.Clean(dir) {
+		return "", outside
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+
+	existing := filepath.Dir(path)
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := relativeToBase(real); !ok {
+		return "", outside
+	}
+	if isWithinBase(existing, dir) {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil || !isWithinBase(real, realDir) {
+			return "", outside
+		}
+	}
+	return path, nil
+}
+
+// handleWorktrees serves GET /api/worktrees
+func handleWorktrees(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	worktrees, err := listWorktrees(repo)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to list worktrees: %v", err),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"worktrees": worktrees,
+	})
+}
+
+// handleAddWorktree checks out branch, or a new branch newBranch created from
+// startPoint, in a new worktree. Without a path the worktree is created in
+// the main repository's worktrees directory, usually <repository>.worktrees/<branch>.
+func handleAddWorktree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path       string `json:"path"`       // relative to the base path
+		Branch     string `json:"branch"`     // existing branch, remote-tracking branch, tag or commit
+		NewBranch  string `json:"newBranch"`  // branch to create instead
+		StartPoint string `json:"startPoint"` // where newBranch starts; HEAD if empty
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	valid := (req.Branch == "") != (req.NewBranch == "")
+	if req.Branch != "" {
+		valid = valid && isValidRef(req.Branch) && req.StartPoint == ""
+	} else {
+		valid = valid && checkBranchName(repo, req.NewBranch) && (req.StartPoint == "" || isValidRef(req.StartPoint))
+	}
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Either a valid branch or a valid newBranch (with an optional startPoint) is required",
+		})
+		return
+	}
+
+	args := []string{"worktree", "add"}
+	name := req.NewBranch
+	if req.Branch != "" {
+		// A remote-tracking branch gets a local branch tracking it, as on checkout
+		local, create, err := trackingBranchFor(repo, req.Branch)
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{
+				Error: err.Error(),
+			})
+			return
+		}
+		switch {
+		case create:
+			name, req.NewBranch, req.StartPoint = local, local, req.Branch
+			args = append(args, "--track")
+		case local != "":
+			name, req.Branch = local, local
+		default:
+			name = req.Branch
+		}
+	}
+	if req.NewBranch != "" {
+		args = append(args, "-b", req.NewBranch)
+	}
+
+	dir := worktreesDir(getMainRepoPath(repo.Path))
+	if req.Path == "" {
+		req.Path = filepath.Join(dir, strings.ReplaceAll(name, "/", "-"))
+	}
+	path, err := resolveNewWorktreePath(req.Path, dir)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Invalid worktree path: %v", err),
+		})
+		return
+	}
+
+	args = append(args, path)
+	if req.Branch != "" {
+		args = append(args, req.Branch)
+	} else if req.StartPoint != "" {
+		args = append(args, req.StartPoint)
+	}
+
+	logs, _, err := runLoggedGit(repo, nil, args...)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to add worktree: %v", err),
+			Log:   logs,
+		})
+		return
+	}
+	logs = append(logs, "✓ Worktree added!")
+
+	worktree, _ := findWorktree(repo, path)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"worktree": worktree,
+		"log":      logs,
+	})
+}
+
+// handleWorktreeAction serves POST /api/worktree/{remove,lock,unlock,prune}
+func handleWorktreeAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	repo, ok := requireRepo(w, r)
+	if !ok {
+		return
+	}
+
+	action := strings.TrimPrefix(r.URL.Path, "/api/worktree/")
+	if action == "prune" {
+		// Drops the administrative files of worktrees whose directory is gone
+		logs, _, err := runLoggedGit(repo, nil, "worktree", "prune", "--verbose")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(Response{
+				Error: fmt.Sprintf("Failed to prune worktrees: %v", err),
+				Log:   logs,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(Response{
+			Log: append(logs, "✓ Worktrees pruned!"),
+		})
+		return
+	}
+
+	pastTense := map[string]string{"remove": "removed", "lock": "locked", "unlock": "unlocked"}
+	if pastTense[action] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Path   string `json:"path"`
+		Force  bool   `json:"force"`  // remove: discard local changes
+		Reason string `json:"reason"` // lock: why the worktree is locked
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	worktree, found := findWorktree(repo, req.Path)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(Response{
+			Error: "Worktree not found",
+		})
+		return
+	}
+	if worktree.Main {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("The main worktree cannot be %s", pastTense[action]),
+		})
+		return
+	}
+
+	var args []string
+	switch action {
+	case "remove":
+		if worktree.Current {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(Response{
+				Error: "Cannot remove the worktree the request was made from; open another worktree of the repository first",
+			})
+			return
+		}
+		args = []string{"worktree", "remove", worktree.Path}
+		if req.Force {
+			args = []string{"worktree", "remove", "--force", worktree.Path}
+		}
+	case "lock":
+		args = []string{"worktree", "lock", worktree.Path}
+		if req.Reason != "" {
+			args = []string{"worktree", "lock", "--reason", req.Reason, worktree.Path}
+		}
+	case "unlock":
+		args = []string{"worktree", "unlock", worktree.Path}
+	}
+
+	logs, output, err := runLoggedGit(repo, nil, args...)
+	if err != nil {
+		// Local changes or a lock are the caller's to deal with; anything else is ours
+		status := http.StatusInternalServerError
+		if strings.Contains(output, "--force") || strings.Contains(output, "locked") {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Response{
+			Error: fmt.Sprintf("Failed to %s worktree: %s", action, output),
+			Log:   logs,
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(Response{
+		Log: append(logs, fmt.Sprintf("✓ Worktree %s!", pastTense[action])),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveNewWorktreePath(t *testing.T) {
+	base := useTestBase(t)
+	repo := filepath.Join(base, "web")
+	dir := worktreesDir(repo)
+	if err := os.MkdirAll(filepath.Join(dir, "taken"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(base, "other"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"web.worktrees/feature", true},
+		{filepath.Join(dir, "deeper", "feature"), true},
+		{"web.worktrees", false},
+		{"web.worktrees/taken", false},
+		{"web.worktrees/link/feature", false},
+		{"other/feature", false},
+		{"web.worktrees/../other", false},
+		{"../outside", false},
+	}
+	for _, tt := range tests {
+		_, err := resolveNewWorktreePath(tt.path, dir)
+		if (err == nil) != tt.ok {
+			t.Errorf("resolveNewWorktreePath(%q) error = %v, want ok=%v", tt.path, err, tt.ok)
+		}
+	}
+
+	// With the base path pointing at a single repository, its .worktrees neighbour
+	// is out of reach and worktrees go in its git directory instead
+	initTestRepo(t, repo)
+	baseRepoPath = repo
+	dir = worktreesDir(repo)
+	if want := filepath.Join(repo, ".git", "airgit-worktrees"); dir != want {
+		t.Fatalf("worktreesDir with the repository as base = %s, want %s", dir, want)
+	}
+	if _, err := resolveNewWorktreePath(".git/airgit-worktrees/feature", dir); err != nil {
+		t.Errorf("resolveNewWorktreePath in the git directory: %v", err)
+	}
+	_, err := resolveNewWorktreePath("feature", dir)
+	if err == nil || !strings.HasSuffix(err.Error(), "within .git/airgit-worktrees") {
+		t.Errorf("resolveNewWorktreePath outside the worktrees directory error = %v", err)
+	}
+
+	setSelectedRepo(Repo{Path: repo})
+	r := httptest.NewRequest("POST", "/api/worktree/add", strings.NewReader(`{"newBranch":"feature"}`))
+	w := httptest.NewRecorder()
+	handleAddWorktree(w, r)
+	if w.Code != 200 {
+		t.Fatalf("adding a worktree returned %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := resolveAndValidateRepoPath(".git/airgit-worktrees/feature", baseRepoPath); !ok {
+		t.Errorf("the new worktree cannot be opened")
+	}
+}
+
+func TestGetMainRepoPath(t *testing.T) {
+	base := useTestBase(t)
+	repo := filepath.Join(base, "web")
+	initTestRepo(t, repo)
+	worktree := filepath.Join(worktreesDir(repo), "feature")
+	runGit(t, repo, "worktree", "add", "-q", "-b", "feature", worktree)
+	bare := filepath.Join(base, "bare.git")
+	runGit(t, base, "clone", "-q", "--bare", repo, bare)
+	bareWorktree := filepath.Join(base, "bare.worktrees", "main")
+	runGit(t, bare, "worktree", "add", "-q", bareWorktree, "main")
+
+	tests := map[string]string{
+		repo:         repo,
+		worktree:     repo,
+		bare:         bare,
+		bareWorktree: bare,
+	}
+	for path, want := range tests {
+		if got := getMainRepoPath(path); got != want {
+			t.Errorf("getMainRepoPath(%s) = %s, want %s", path, got, want)
+		}
+	}
+}